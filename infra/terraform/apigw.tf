@@ -46,7 +46,7 @@ resource "google_api_gateway_api_config" "_" {
 
   openapi_documents {
     document {
-      path     = "spec.yaml"
+      path     = "spec.json"
       contents = base64encode(local.api_config)
     }
   }
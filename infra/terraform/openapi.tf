@@ -12,62 +12,15 @@
 
 # See the License for the specific language governing permissions and limitations under the License.
 
-module "schema_error" {
-  source          = "./modules/jsonschema_openapi"
-  file_jsonschema = "${local.path_code_services}/models/error.json"
-}
-
-module "schema_submission_data_req" {
-  source          = "./modules/jsonschema_openapi"
-  file_jsonschema = "${local.path_code_services_submit}/models/request.json"
-}
-
-module "schema_submit_post_resp_ok" {
-  source          = "./modules/jsonschema_openapi"
-  file_jsonschema = "${local.path_code_services_submit}/models/response_ok.json"
-}
-
-module "schema_submit_post_resp_fail" {
-  source          = "./modules/jsonschema_openapi"
-  file_jsonschema = "${local.path_code_services_submit}/models/response_fail.json"
-}
-
-module "schema_process_resp" {
-  source          = "./modules/jsonschema_openapi"
-  file_jsonschema = "${local.path_code_services_process}/models/response.json"
-}
-
-module "schema_process_req_query" {
-  source          = "./modules/jsonschema_openapi"
-  file_jsonschema = "${local.path_code_services_process}/models/request_query_openapi2.json"
-}
+# The gateway specification is generated from the services' OpenAPI documents
+# by the gateway routes config gateway.json, run `make gateway.spec` to update it.
 
 locals {
-  api_config_template = templatefile("${path.module}/openapi.yaml",
+  api_config = templatefile("${path.module}/openapi.json",
     {
       # gw backends
       submit_service_url  = google_cloud_run_service.submit.status[0].url,
       process_service_url = google_cloud_run_service.process.status[0].url,
-      # schema definitions keys mapping
-      error                = "error"
-      submission_data_req  = "submission_data_req"
-      submission_resp_ok   = "submission_resp_ok"
-      submission_resp_fail = "submission_resp_fail"
-      process_resp         = "process_resp"
-      process_query_req    = "process_query_req"
     },
   )
-  api_config_obj_base = yamldecode(local.api_config_template)
-  api_config_obj = merge(local.api_config_obj_base,
-    {
-      definitions = {
-        error                = module.schema_error.obj
-        submission_data_req  = module.schema_submission_data_req.obj
-        submission_resp_ok   = module.schema_submit_post_resp_ok.obj
-        submission_resp_fail = module.schema_submit_post_resp_fail.obj
-        process_resp         = module.schema_process_resp.obj
-        process_query_req    = module.schema_process_req_query.obj
-      }
-  })
-  api_config = yamlencode(local.api_config_obj)
 }
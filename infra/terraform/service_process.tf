@@ -31,6 +31,33 @@ resource "google_pubsub_topic_iam_member" "process" {
   member   = "serviceAccount:${google_service_account.process.email}"
 }
 
+# the instances create their own subscriptions to the topic of the processed data, see the processed data subscription
+resource "google_pubsub_topic_iam_member" "process_subscriber" {
+  project = local.project
+  topic   = google_pubsub_topic._["process"].name
+  role    = "roles/pubsub.subscriber"
+  member  = "serviceAccount:${google_service_account.process.email}"
+}
+
+resource "google_project_iam_custom_role" "process_subscriptions" {
+  project     = local.project
+  role_id     = "processSubscriptions"
+  title       = "Processing service subscriptions"
+  description = "Manage the temporary subscriptions of the processing service instances."
+  permissions = [
+    "pubsub.subscriptions.create",
+    "pubsub.subscriptions.consume",
+    "pubsub.subscriptions.delete",
+    "pubsub.subscriptions.get",
+  ]
+}
+
+resource "google_project_iam_member" "process_subscriptions" {
+  project = local.project
+  role    = google_project_iam_custom_role.process_subscriptions.id
+  member  = "serviceAccount:${google_service_account.process.email}"
+}
+
 resource "google_storage_bucket_iam_member" "process" {
   bucket = google_storage_bucket.data.name
   role   = "roles/storage.objectAdmin"
@@ -92,7 +119,8 @@ resource "google_cloud_run_service" "process" {
         }
       }
       container_concurrency = 20
-      timeout_seconds       = 30
+      # the subscriptions to the processed data are streamed until the timeout
+      timeout_seconds       = 3600
       service_account_name  = google_service_account.process.email
     }
     metadata {
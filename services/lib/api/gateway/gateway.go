@@ -0,0 +1,373 @@
+/* Copyright 2021 Dmitry Kisler dkisler.com
+
+Licensed under the Apache License,Version 2.0 (the "License");
+you may not use this file except in compliance with the License. You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED,
+INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE,
+AND NONINFRINGEMENT. IN NO EVENT WILL THE LICENSOR OR OTHER CONTRIBUTORS BE LIABLE FOR ANY CLAIM, DAMAGES,
+OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF,
+OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+See the License for the specific language governing permissions and limitations under the License.
+
+Package defines the API gateway specification derived from the services' OpenAPI documents.
+The GCP API Gateway supports the OpenAPI 2 (Swagger) specification only,
+hence the operations documented by the services are converted to the gateway routes.
+*/
+
+package gateway
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"platform/lib/api/openapi"
+	"sort"
+	"strings"
+
+	"github.com/goccy/go-json"
+)
+
+// Config defines the gateway routes to the services.
+type Config struct {
+	// Info, Tags and SecurityDefinitions are set to the specification as is.
+	Info                json.RawMessage `json:"info"`
+	Tags                json.RawMessage `json:"tags,omitempty"`
+	SecurityDefinitions json.RawMessage `json:"securityDefinitions,omitempty"`
+	// Security defines the security requirements of the routes which are not public.
+	Security json.RawMessage     `json:"security,omitempty"`
+	Backends map[string]*Backend `json:"backends"`
+	Routes   []*Route            `json:"routes"`
+}
+
+// Backend defines the service behind the gateway.
+type Backend struct {
+	// Document defines the path to the service's OpenAPI document relative to the config file.
+	Document string `json:"document"`
+	// Address defines the service URL, e.g. the terraform template variable "${submit_service_url}".
+	Address string `json:"address"`
+}
+
+// Route defines the gateway route to the service's operation.
+type Route struct {
+	// Path defines the gateway path, e.g. /raw/{submission_id}.
+	// The service's query parameters named as the path parameters are converted to the path parameters.
+	Path   string `json:"path"`
+	Method string `json:"method"`
+	// Backend defines the service key in the Config.Backends.
+	Backend string `json:"backend"`
+	// Route defines the service's path in the OpenAPI document, e.g. /read.
+	// The route with the path parameters shall be served at the same gateway path.
+	Route string `json:"route"`
+	// OperationID overrides the service's operation ID, it shall be unique across the gateway routes.
+	OperationID string   `json:"operation_id,omitempty"`
+	Tags        []string `json:"tags,omitempty"`
+	// Public defines the route without the security requirements.
+	Public bool `json:"public,omitempty"`
+	// Deadline defines the backend response deadline in seconds.
+	Deadline float64 `json:"deadline,omitempty"`
+}
+
+// ReadConfig reads the config and the OpenAPI documents of its backends.
+func ReadConfig(path string) (*Config, map[string]*openapi.Document, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	var cfg Config
+	if err := json.Unmarshal(b, &cfg); err != nil {
+		return nil, nil, fmt.Errorf("%s: %w", path, err)
+	}
+	docs := map[string]*openapi.Document{}
+	for name, backend := range cfg.Backends {
+		p := filepath.Join(filepath.Dir(path), backend.Document)
+		b, err := os.ReadFile(p)
+		if err != nil {
+			return nil, nil, err
+		}
+		if docs[name], err = openapi.Parse(b); err != nil {
+			return nil, nil, fmt.Errorf("%s: %w", p, err)
+		}
+	}
+	return &cfg, docs, nil
+}
+
+type spec struct {
+	Swagger             string                                `json:"swagger"`
+	Info                json.RawMessage                       `json:"info"`
+	Schemes             []string                              `json:"schemes"`
+	Consumes            []string                              `json:"consumes"`
+	Produces            []string                              `json:"produces"`
+	Tags                json.RawMessage                       `json:"tags,omitempty"`
+	SecurityDefinitions json.RawMessage                       `json:"securityDefinitions,omitempty"`
+	Paths               map[string]map[string]*operation      `json:"paths"`
+	Definitions         map[string]map[string]json.RawMessage `json:"definitions"`
+}
+
+type backend struct {
+	Address         string  `json:"address"`
+	Deadline        float64 `json:"deadline,omitempty"`
+	PathTranslation string  `json:"path_translation,omitempty"`
+}
+
+// appendPathToAddress defines the backend address which the request path is appended to.
+const appendPathToAddress = "APPEND_PATH_TO_ADDRESS"
+
+type operation struct {
+	Backend     backend                  `json:"x-google-backend"`
+	Security    json.RawMessage          `json:"security,omitempty"`
+	Tags        []string                 `json:"tags,omitempty"`
+	Summary     string                   `json:"summary,omitempty"`
+	Description string                   `json:"description,omitempty"`
+	OperationID string                   `json:"operationId"`
+	Produces    []string                 `json:"produces,omitempty"`
+	Parameters  []map[string]interface{} `json:"parameters,omitempty"`
+	Responses   map[string]*response     `json:"responses"`
+}
+
+type response struct {
+	Description string          `json:"description"`
+	Schema      json.RawMessage `json:"schema,omitempty"`
+}
+
+const (
+	jsonContentType = "application/json"
+	componentsRef   = "#/components/schemas/"
+	definitionsRef  = "#/definitions/"
+)
+
+// Spec derives the gateway specification from the services' OpenAPI documents by backend name.
+// The specification is the terraform template with the backend addresses as the template variables,
+// the rest of the specification is escaped.
+func Spec(cfg *Config, docs map[string]*openapi.Document) ([]byte, error) {
+	s := &spec{
+		Swagger:             "2.0",
+		Info:                cfg.Info,
+		Schemes:             []string{"https"},
+		Consumes:            []string{jsonContentType},
+		Produces:            []string{jsonContentType},
+		Tags:                cfg.Tags,
+		SecurityDefinitions: cfg.SecurityDefinitions,
+		Paths:               map[string]map[string]*operation{},
+		Definitions:         map[string]map[string]json.RawMessage{},
+	}
+	for _, r := range cfg.Routes {
+		b, ok := cfg.Backends[r.Backend]
+		if !ok {
+			return nil, fmt.Errorf("route %s %s: unknown backend %q", r.Method, r.Path, r.Backend)
+		}
+		doc := docs[r.Backend]
+		var op *openapi.Operation
+		if doc != nil {
+			op = doc.Paths[r.Route][strings.ToLower(r.Method)]
+		}
+		if op == nil {
+			return nil, fmt.Errorf("route %s %s: %s %s is not documented by backend %q", r.Method, r.Path, r.Method, r.Route, r.Backend)
+		}
+		o, refs, err := convertOperation(r, b, op)
+		if err != nil {
+			return nil, fmt.Errorf("route %s %s: %w", r.Method, r.Path, err)
+		}
+		if cfg.Security != nil && !r.Public {
+			o.Security = cfg.Security
+		}
+		for _, name := range refs {
+			if err := s.addDefinition(name, doc.Components.Schemas[name]); err != nil {
+				return nil, fmt.Errorf("route %s %s: %w", r.Method, r.Path, err)
+			}
+		}
+		item, ok := s.Paths[r.Path]
+		if !ok {
+			item = map[string]*operation{}
+			s.Paths[r.Path] = item
+		}
+		item[strings.ToLower(r.Method)] = o
+	}
+
+	// the HTML characters are not escaped to keep the descriptions readable
+	var o bytes.Buffer
+	enc := json.NewEncoder(&o)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(s); err != nil {
+		return nil, err
+	}
+	return escapeTemplate(o.Bytes(), cfg.Backends), nil
+}
+
+// escapeTemplate escapes the terraform template sequences except for the backend addresses.
+func escapeTemplate(b []byte, backends map[string]*Backend) []byte {
+	escape := strings.NewReplacer("${", "$${", "%{", "%%{")
+	o := escape.Replace(string(b))
+	for _, backend := range backends {
+		o = strings.ReplaceAll(o, escape.Replace(backend.Address), backend.Address)
+	}
+	return []byte(o)
+}
+
+// convertOperation converts the service's operation to the gateway operation.
+// It returns the names of the schemas referenced by the operation.
+func convertOperation(r *Route, b *Backend, op *openapi.Operation) (*operation, []string, error) {
+	o := &operation{
+		Backend:     backend{Address: b.Address + r.Route, Deadline: r.Deadline},
+		Tags:        op.Tags,
+		Summary:     op.Summary,
+		Description: op.Description,
+		OperationID: op.OperationID,
+		Responses:   map[string]*response{},
+	}
+	// the path parameters cannot be set in the backend address, the request path is passed to the backend instead
+	if strings.Contains(r.Route, "{") {
+		if r.Path != r.Route {
+			return nil, nil, fmt.Errorf("the route %s with the path parameters shall be served at the same gateway path", r.Route)
+		}
+		o.Backend.Address = b.Address
+		o.Backend.PathTranslation = appendPathToAddress
+	}
+	if len(r.Tags) > 0 {
+		o.Tags = r.Tags
+	}
+	if r.OperationID != "" {
+		o.OperationID = r.OperationID
+	}
+	var refs []string
+
+	for _, p := range op.Parameters {
+		in := p.In
+		required := p.Required
+		if in == openapi.InQuery && strings.Contains(r.Path, "{"+p.Name+"}") {
+			in, required = openapi.InPath, true
+		}
+		param := map[string]interface{}{}
+		if len(p.Schema) > 0 {
+			if err := json.Unmarshal(p.Schema, &param); err != nil {
+				return nil, nil, fmt.Errorf("parameter %q: %w", p.Name, err)
+			}
+		}
+		delete(param, "description")
+		param["name"] = p.Name
+		param["in"] = in
+		if p.Description != "" {
+			param["description"] = p.Description
+		}
+		param["required"] = required
+		o.Parameters = append(o.Parameters, param)
+	}
+	if body := op.RequestBody; body != nil {
+		name, ok := refName(body.Content[jsonContentType].Schema)
+		if !ok {
+			return nil, nil, fmt.Errorf("request body shall reference the component schema")
+		}
+		refs = append(refs, name)
+		param := map[string]interface{}{
+			"name":     name,
+			"in":       "body",
+			"required": body.Required,
+			"schema":   map[string]string{"$ref": definitionsRef + name},
+		}
+		if body.Description != "" {
+			param["description"] = body.Description
+		}
+		o.Parameters = append(o.Parameters, param)
+	}
+
+	produces := map[string]bool{}
+	for code, resp := range op.Responses {
+		res := &response{Description: resp.Description}
+		for t := range resp.Content {
+			produces[t] = true
+		}
+		if name, ok := refName(resp.Content[jsonContentType].Schema); ok {
+			refs = append(refs, name)
+			res.Schema = json.RawMessage(`{"$ref": "` + definitionsRef + name + `"}`)
+		}
+		o.Responses[code] = res
+	}
+	// the content types are listed if the operation produces other than the default one
+	if len(produces) > 1 || (len(produces) == 1 && !produces[jsonContentType]) {
+		for t := range produces {
+			o.Produces = append(o.Produces, t)
+		}
+		sort.Strings(o.Produces)
+	}
+	return o, refs, nil
+}
+
+// refName returns the name of the component schema referenced by the schema.
+func refName(schema json.RawMessage) (string, bool) {
+	var ref struct {
+		Ref string `json:"$ref"`
+	}
+	if len(schema) == 0 || json.Unmarshal(schema, &ref) != nil || !strings.HasPrefix(ref.Ref, componentsRef) {
+		return "", false
+	}
+	return strings.TrimPrefix(ref.Ref, componentsRef), true
+}
+
+// addDefinition adds the schema to the definitions.
+// The schemas of the same name shall be identical across the backends.
+func (s *spec) addDefinition(name string, schema json.RawMessage) error {
+	if schema == nil {
+		return fmt.Errorf("schema %q is not defined", name)
+	}
+	d, err := downgradeSchema(schema)
+	if err != nil {
+		return fmt.Errorf("schema %q: %w", name, err)
+	}
+	if existing, ok := s.Definitions[name]; ok {
+		a, _ := json.Marshal(existing)
+		b, _ := json.Marshal(d)
+		if !bytes.Equal(a, b) {
+			return fmt.Errorf("schema %q is defined differently by the backends", name)
+		}
+	}
+	s.Definitions[name] = d
+	return nil
+}
+
+// downgradeSchema converts the JSON schema to the subset supported by the OpenAPI 2 specification:
+//   - the keywords $schema, $id and additionalItems are removed;
+//   - the properties of the oneOf and anyOf subschemas are merged to the object properties,
+//     e.g. the query of one of the filters is converted to the query of optional filters.
+func downgradeSchema(schema json.RawMessage) (map[string]json.RawMessage, error) {
+	var o map[string]json.RawMessage
+	if err := json.Unmarshal(schema, &o); err != nil {
+		return nil, err
+	}
+	for _, k := range []string{"$schema", "$id", "additionalItems"} {
+		delete(o, k)
+	}
+	for _, k := range []string{"oneOf", "anyOf"} {
+		raw, ok := o[k]
+		if !ok {
+			continue
+		}
+		delete(o, k)
+		var subschemas []struct {
+			Properties map[string]json.RawMessage `json:"properties"`
+		}
+		if err := json.Unmarshal(raw, &subschemas); err != nil {
+			return nil, err
+		}
+		properties := map[string]json.RawMessage{}
+		if p, ok := o["properties"]; ok {
+			if err := json.Unmarshal(p, &properties); err != nil {
+				return nil, err
+			}
+		}
+		for _, s := range subschemas {
+			for name, p := range s.Properties {
+				properties[name] = p
+			}
+		}
+		b, err := json.Marshal(properties)
+		if err != nil {
+			return nil, err
+		}
+		o["properties"] = b
+	}
+	return o, nil
+}
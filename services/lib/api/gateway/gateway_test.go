@@ -0,0 +1,167 @@
+/* Copyright 2021 Dmitry Kisler dkisler.com
+
+Licensed under the Apache License,Version 2.0 (the "License");
+you may not use this file except in compliance with the License. You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED,
+INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE,
+AND NONINFRINGEMENT. IN NO EVENT WILL THE LICENSOR OR OTHER CONTRIBUTORS BE LIABLE FOR ANY CLAIM, DAMAGES,
+OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF,
+OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+See the License for the specific language governing permissions and limitations under the License.
+*/
+
+package gateway_test
+
+import (
+	"encoding/json"
+	"platform/lib/api/gateway"
+	"platform/lib/api/openapi"
+	"strings"
+	"testing"
+)
+
+const document = `{
+  "openapi": "3.1.0",
+  "info": {"title": "svc", "version": "v1"},
+  "paths": {
+    "/items/{id}": {
+      "get": {
+        "operationId": "item",
+        "parameters": [{"name": "id", "in": "path", "required": true, "schema": {"type": "string"}}],
+        "responses": {"200": {"description": "Success"}}
+      }
+    },
+    "/read": {
+      "get": {
+        "operationId": "read",
+        "description": "Read ${data}.",
+        "parameters": [
+          {"name": "id", "in": "query", "schema": {"type": "string", "format": "uuid"}},
+          {"name": "limit", "in": "query", "schema": {"type": "number", "minimum": 0}}
+        ],
+        "responses": {
+          "200": {
+            "description": "Success",
+            "content": {
+              "application/json": {"schema": {"$ref": "#/components/schemas/query"}},
+              "text/csv": {}
+            }
+          }
+        }
+      },
+      "post": {
+        "operationId": "query",
+        "requestBody": {
+          "required": true,
+          "content": {"application/json": {"schema": {"$ref": "#/components/schemas/query"}}}
+        },
+        "responses": {"200": {"description": "Success"}}
+      }
+    }
+  },
+  "components": {
+    "schemas": {
+      "query": {
+        "$schema": "http://json-schema.org/draft-07/schema",
+        "type": "object",
+        "oneOf": [
+          {"required": ["a"], "properties": {"a": {"type": "number"}}},
+          {"required": ["b"], "properties": {"b": {"type": "string"}}}
+        ]
+      }
+    }
+  }
+}`
+
+func TestSpec(t *testing.T) {
+	doc, err := openapi.Parse([]byte(document))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := &gateway.Config{
+		Info:     json.RawMessage(`{"title": "gw", "version": "v1"}`),
+		Security: json.RawMessage(`[{"api_key": []}]`),
+		Backends: map[string]*gateway.Backend{"svc": {Address: "${svc_url}"}},
+		Routes: []*gateway.Route{
+			{Path: "/data/{id}", Method: "get", Backend: "svc", Route: "/read", Tags: []string{"data"}, Public: true},
+			{Path: "/data", Method: "post", Backend: "svc", Route: "/read", OperationID: "queryData", Deadline: 60},
+			{Path: "/items/{id}", Method: "get", Backend: "svc", Route: "/items/{id}"},
+		},
+	}
+	b, err := gateway.Spec(cfg, map[string]*openapi.Document{"svc": doc})
+	if err != nil {
+		t.Fatalf("error!\nwant: specification\ngot: %v", err)
+	}
+
+	for _, want := range []string{
+		`"address": "${svc_url}/read"`,
+		`"address": "${svc_url}",
+          "path_translation": "APPEND_PATH_TO_ADDRESS"`,
+		`"description": "Read $${data}."`,
+	} {
+		if !strings.Contains(string(b), want) {
+			t.Fatalf("error!\nwant: %v\ngot: %s", want, b)
+		}
+	}
+
+	var spec struct {
+		Swagger string
+		Paths   map[string]map[string]struct {
+			Security    []map[string][]string
+			OperationID string
+			Produces    []string
+			Parameters  []map[string]interface{}
+		}
+		Definitions map[string]map[string]interface{}
+	}
+	if err := json.Unmarshal(b, &spec); err != nil {
+		t.Fatalf("error!\nwant: JSON specification\ngot: %v", err)
+	}
+	if spec.Swagger != "2.0" {
+		t.Fatalf("error!\nwant: %v\ngot: %v", "2.0", spec.Swagger)
+	}
+
+	get := spec.Paths["/data/{id}"]["get"]
+	if get.Security != nil || get.OperationID != "read" || len(get.Produces) != 2 {
+		t.Fatalf("error!\nwant: public operation read producing JSON and CSV\ngot: %+v", get)
+	}
+	if p := get.Parameters[0]; p["in"] != "path" || p["required"] != true || p["format"] != "uuid" {
+		t.Fatalf("error!\nwant: required uuid path parameter\ngot: %v", p)
+	}
+	if p := get.Parameters[1]; p["in"] != "query" || p["required"] != false || p["type"] != "number" {
+		t.Fatalf("error!\nwant: optional number query parameter\ngot: %v", p)
+	}
+
+	post := spec.Paths["/data"]["post"]
+	if post.Security == nil || post.OperationID != "queryData" || post.Parameters[0]["in"] != "body" {
+		t.Fatalf("error!\nwant: secured operation queryData with body parameter\ngot: %+v", post)
+	}
+
+	query := spec.Definitions["query"]
+	if _, ok := query["oneOf"]; ok {
+		t.Fatalf("error!\nwant: oneOf merged to properties\ngot: %v", query)
+	}
+	if _, ok := query["$schema"]; ok {
+		t.Fatalf("error!\nwant: $schema removed\ngot: %v", query)
+	}
+	if properties, _ := query["properties"].(map[string]interface{}); len(properties) != 2 {
+		t.Fatalf("error!\nwant: properties a and b\ngot: %v", query["properties"])
+	}
+
+	if p := spec.Paths["/items/{id}"]["get"].Parameters[0]; p["in"] != "path" || p["required"] != true {
+		t.Fatalf("error!\nwant: required path parameter\ngot: %v", p)
+	}
+
+	for _, r := range []*gateway.Route{
+		{Path: "/missing", Method: "get", Backend: "svc", Route: "/missing"},
+		{Path: "/data/items/{id}", Method: "get", Backend: "svc", Route: "/items/{id}"},
+	} {
+		cfg.Routes = []*gateway.Route{r}
+		if _, err := gateway.Spec(cfg, map[string]*openapi.Document{"svc": doc}); err == nil {
+			t.Fatalf("error for %s!\nwant: error\ngot: nil", r.Path)
+		}
+	}
+}
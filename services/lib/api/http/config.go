@@ -33,6 +33,8 @@ type Config struct {
 	WriterBufferSize int
 	// Concurrency max concurent connections to be served by the server
 	Concurrency int
+	// ShutdownTimeout defines the deadline to drain in-flight requests on shutdown
+	ShutdownTimeout time.Duration
 }
 
 const (
@@ -41,6 +43,8 @@ const (
 	defaultSize         = 10 * 1 << 22
 	defaultBufferSize   = 4 * 1 << 10
 	defaultConcurrency  = 1e6
+	// Cloud Run sends SIGKILL 10 sec after SIGTERM
+	defaultTimeoutShutdown = 8 * time.Second
 )
 
 // NewConfig initiates HTTP server config.
@@ -58,6 +62,8 @@ const (
 // writer buffer size: 10 Mb
 //
 // max connections concurrency: 1 million connections
+//
+// timeout for shutdown: 8 sec
 func NewConfig() *Config {
 	return &Config{
 		ReadTimeout:        defaultTimeoutRead,
@@ -66,6 +72,7 @@ func NewConfig() *Config {
 		ReaderBufferSize:   defaultBufferSize,
 		WriterBufferSize:   defaultBufferSize,
 		Concurrency:        defaultConcurrency,
+		ShutdownTimeout:    defaultTimeoutShutdown,
 	}
 }
 
@@ -98,3 +105,8 @@ func (c *Config) SetWriterBufferSize(size int) {
 func (c *Config) SetMaxCCConnections(n int) {
 	c.Concurrency = n
 }
+
+// SetShutdownTimeout sets the deadline to drain in-flight requests on shutdown.
+func (c *Config) SetShutdownTimeout(t time.Duration) {
+	c.ShutdownTimeout = t
+}
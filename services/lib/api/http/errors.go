@@ -0,0 +1,128 @@
+/* Copyright 2021 Dmitry Kisler dkisler.com
+
+Licensed under the Apache License,Version 2.0 (the "License");
+you may not use this file except in compliance with the License. You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED,
+INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE,
+AND NONINFRINGEMENT. IN NO EVENT WILL THE LICENSOR OR OTHER CONTRIBUTORS BE LIABLE FOR ANY CLAIM, DAMAGES,
+OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF,
+OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+See the License for the specific language governing permissions and limitations under the License.
+*/
+
+package http
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/goccy/go-json"
+	http "github.com/valyala/fasthttp"
+)
+
+// ErrorKind defines the class of the error returned by the action.
+type ErrorKind int
+
+const (
+	// KindInternal defines the server failure, its details are not returned to the client.
+	KindInternal ErrorKind = iota
+	// KindValidation defines the invalid request.
+	KindValidation
+	// KindNotFound defines the request to the missing resource.
+	KindNotFound
+	// KindConflict defines the request conflicting with the resource state.
+	KindConflict
+	// KindUnauthorized defines the request missing valid credentials.
+	KindUnauthorized
+)
+
+var kindStatusCodes = map[ErrorKind]int{
+	KindInternal:     http.StatusInternalServerError,
+	KindValidation:   http.StatusBadRequest,
+	KindNotFound:     http.StatusNotFound,
+	KindConflict:     http.StatusConflict,
+	KindUnauthorized: http.StatusUnauthorized,
+}
+
+// internalErrorMessage defines the message returned to the client instead of the internal error details.
+const internalErrorMessage = "internal error"
+
+// Error defines the error returned by the action to reply with the status code of its kind.
+// The errors of other types returned by the action are considered internal.
+type Error struct {
+	Kind ErrorKind
+	// Message defines the message returned to the client, it's ignored for the internal errors.
+	Message string
+	// Err defines the cause, it's logged, but not returned to the client.
+	Err error
+	// Details defines the details returned to the client, e.g. the list of the validation errors.
+	// It's ignored for the internal errors.
+	Details interface{}
+}
+
+// NewError defines the error of the kind with the message returned to the client.
+func NewError(kind ErrorKind, message string) *Error {
+	return &Error{Kind: kind, Message: message}
+}
+
+// WrapError defines the error of the kind caused by err.
+// The message returned to the client is err.Error() unless the kind is internal.
+func WrapError(kind ErrorKind, err error) *Error {
+	return &Error{Kind: kind, Message: err.Error(), Err: err}
+}
+
+func (e *Error) Error() string {
+	if e.Err != nil && e.Err.Error() != e.Message {
+		return fmt.Sprintf("%s: %v", e.Message, e.Err)
+	}
+	return e.Message
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// StatusCode returns the response status code of the error kind.
+func (e *Error) StatusCode() int {
+	if code, ok := kindStatusCodes[e.Kind]; ok {
+		return code
+	}
+	return http.StatusInternalServerError
+}
+
+// ClientMessage returns the message returned to the client, the details of the internal errors are hidden.
+func (e *Error) ClientMessage() string {
+	if e.Kind == KindInternal || e.Message == "" {
+		return internalErrorMessage
+	}
+	return e.Message
+}
+
+// errorResponse defines the error response body, see error.json.
+type errorResponse struct {
+	Error   string      `json:"error"`
+	Details interface{} `json:"details,omitempty"`
+}
+
+// NewErrorResponse defines the response with the JSON error body.
+func NewErrorResponse(message string, statusCode int) *Response {
+	b, _ := json.Marshal(errorResponse{Error: message})
+	return NewResponse(b, statusCode)
+}
+
+// errorToResponse converts the error returned by the action to the response.
+func errorToResponse(err error) *Response {
+	var e *Error
+	if !errors.As(err, &e) {
+		e = &Error{Kind: KindInternal, Err: err}
+	}
+	body := errorResponse{Error: e.ClientMessage()}
+	if e.Kind != KindInternal {
+		body.Details = e.Details
+	}
+	b, _ := json.Marshal(body)
+	return NewResponse(b, e.StatusCode())
+}
@@ -15,7 +15,14 @@ See the License for the specific language governing permissions and limitations
 
 package http
 
-import http "github.com/valyala/fasthttp"
+import (
+	"context"
+	"io"
+	"platform/lib/health"
+	"time"
+
+	http "github.com/valyala/fasthttp"
+)
 
 // Request defines the HandlerEndpoint's request object.
 type Request struct {
@@ -24,12 +31,40 @@ type Request struct {
 	Headers         map[string]string
 	Query           map[string]string
 	Body            []byte
+	// ValidationError defines the error of the request payload validation,
+	// it's set if the endpoint accepts the invalid payloads, see EndpointDoc.AcceptInvalidRequest.
+	ValidationError error
+	ctx             context.Context
+}
+
+// Context returns the request context.
+// The context is canceled when the endpoint timeout is exceeded, when the response is sent,
+// or when the server fails to drain in-flight requests on shutdown.
+// For the streamed response, it is canceled once the payload is written.
+func (r *Request) Context() context.Context {
+	if r.ctx == nil {
+		return context.Background()
+	}
+	return r.ctx
+}
+
+// WithContext returns the shallow copy of the request with its context changed to ctx.
+func (r *Request) WithContext(ctx context.Context) *Request {
+	r2 := *r
+	r2.ctx = ctx
+	return &r2
 }
 
+// StreamWriter defines the function to write the response payload.
+type StreamWriter func(w io.Writer) error
+
 // Response defines the HandlerEndpoint's response object.
 type Response struct {
 	// Body defines the response payload.
 	Body []byte
+	// BodyStream defines the response payload writer.
+	// If set, the payload is streamed using the chunked transfer encoding and Body is ignored.
+	BodyStream StreamWriter
 	// ContentType defines the response MIME content type.
 	ContentType string
 	// Response status code
@@ -51,6 +86,17 @@ func NewResponse(b []byte, statusCode int) *Response {
 	}
 }
 
+// NewStreamResponse defines the response object with the streamed payload.
+// The status code and headers are sent before the payload is written,
+// hence the errors returned by the StreamWriter are only logged.
+func NewStreamResponse(w StreamWriter, statusCode int) *Response {
+	return &Response{
+		BodyStream:  w,
+		StatusCode:  statusCode,
+		ContentType: defaultContentType,
+	}
+}
+
 // SetContentType sets the custom response content type.
 func (r *Response) SetContentType(s string) {
 	r.ContentType = s
@@ -70,10 +116,24 @@ func (r *Response) AddHeaders(h map[string]string) {
 type Action func(r *Request) (*Response, error)
 
 // ActionHealthcheck defines the function for the status healthcheck.
+// It's the cheap liveness probe, the dependencies are checked by the readiness endpoint.
 func ActionHealthcheck(r *Request) (*Response, error) {
 	return NewResponse([]byte{}, http.StatusOK), nil
 }
 
+// ActionReadiness defines the function to check the service dependencies.
+// The status code is 503 if any check fails, the body reports the status and latency per check.
+func ActionReadiness(c *health.Checker) Action {
+	return func(r *Request) (*Response, error) {
+		report := c.Run(r.Context())
+		status := http.StatusOK
+		if !report.Ready() {
+			status = http.StatusServiceUnavailable
+		}
+		return NewResponse(report.MustSerialize(), status), nil
+	}
+}
+
 // AllowedMethods defines the allowed HTTP request methods.
 type AllowedMethods []string
 
@@ -100,6 +160,15 @@ type HandlerEndpoint struct {
 	AllowedMethods *AllowedMethods
 	// ContentType MIME content type.
 	ContentType string
+	// Timeout defines the deadline to process the request, including the streamed payload.
+	// The deadline is not set if the timeout is not positive.
+	Timeout time.Duration
+	// StreamWriteTimeout replaces the server write timeout of the streamed payload with the deadline of every write,
+	// so the long-lived streams, e.g. Server-Sent Events, are not cut by the server write timeout.
+	// The server write timeout applies if it's not positive.
+	StreamWriteTimeout time.Duration
+	// Doc documents the endpoint in the OpenAPI document.
+	Doc *EndpointDoc
 }
 
 // NewHandlerEndpoint initiates a new HandlerEndpoint.
@@ -120,6 +189,26 @@ func (h *HandlerEndpoint) ProcessRequest(r *Request) (*Response, error) {
 	return h.Action(r)
 }
 
+// WithTimeout sets the deadline to process the request.
+func (h *HandlerEndpoint) WithTimeout(t time.Duration) *HandlerEndpoint {
+	h.Timeout = t
+	return h
+}
+
+// WithStreamWriteTimeout sets the deadline of every write of the streamed payload, see StreamWriteTimeout.
+func (h *HandlerEndpoint) WithStreamWriteTimeout(t time.Duration) *HandlerEndpoint {
+	h.StreamWriteTimeout = t
+	return h
+}
+
+// newContext defines the request context derived from the parent context.
+func (h *HandlerEndpoint) newContext(parent context.Context) (context.Context, context.CancelFunc) {
+	if h.Timeout > 0 {
+		return context.WithTimeout(parent, h.Timeout)
+	}
+	return context.WithCancel(parent)
+}
+
 // WithOPTION sets the OPTION method as allowed.
 func (h *HandlerEndpoint) WithOPTION() *HandlerEndpoint {
 	h.AllowedMethods.Add("OPTIONS")
@@ -144,4 +233,20 @@ func (h *HandlerEndpoint) IsAllowedMethod(method string) bool {
 }
 
 // HealthcheckHandler defines the handler for the status healthckeck endpoint.
-var HealthcheckHandler = NewHandlerEndpoint(ActionHealthcheck, []string{"GET"})
+var HealthcheckHandler = NewHandlerEndpoint(ActionHealthcheck, []string{"GET"}).WithDoc(&EndpointDoc{
+	ID:          "getStatus",
+	Description: "Check the service status.",
+	Responses:   map[int]*ResponseDoc{http.StatusOK: {Description: "Success"}},
+})
+
+// NewReadinessHandler defines the handler for the readiness endpoint.
+func NewReadinessHandler(c *health.Checker) *HandlerEndpoint {
+	return NewHandlerEndpoint(ActionReadiness(c), []string{"GET"}).WithDoc(&EndpointDoc{
+		ID:          "getReadiness",
+		Description: "Check the service dependencies.",
+		Responses: map[int]*ResponseDoc{
+			http.StatusOK:                 {Description: "All dependencies are available"},
+			http.StatusServiceUnavailable: {Description: "Some dependencies are unavailable"},
+		},
+	})
+}
@@ -19,33 +19,125 @@ It wraps around github.com/valyala/fasthttp for base logic plus defines a custom
 package http
 
 import (
+	"bufio"
+	"context"
 	"errors"
 	"fmt"
-	"log"
+	"io"
+	"net"
 	"os"
+	"os/signal"
+	"platform/lib/api/openapi"
+	"platform/lib/health"
+	"platform/lib/logging"
+	"runtime/debug"
+	"syscall"
+	"time"
 
 	http "github.com/valyala/fasthttp"
 )
 
-var logger = log.New(os.Stderr, "", log.Ldate|log.Lmicroseconds|log.Lmsgprefix|log.LUTC|log.Llongfile)
-
 // Server defines the API interface.
 type Server struct {
 	// HTTP server
-	http *http.Server
+	http     *http.Server
+	handlers *Handlers
+	// shutdownTimeout defines the deadline to drain in-flight requests on shutdown
+	shutdownTimeout time.Duration
+	// closers defines the resources to be released on shutdown
+	closers []io.Closer
 }
 
 // Start starts the HTTP service listening on the given port.
+// The server is shut down gracefully on SIGINT or SIGTERM, see the method Shutdown.
 func (s *Server) Start(port interface{}) error {
-	return s.http.ListenAndServe(fmt.Sprintf(":%v", port))
+	ln, err := net.Listen("tcp4", fmt.Sprintf(":%v", port))
+	if err != nil {
+		return err
+	}
+	return s.Serve(ln)
+}
+
+// Serve serves the HTTP requests from the listener.
+// The server is shut down gracefully on SIGINT or SIGTERM, see the method Shutdown.
+func (s *Server) Serve(ln net.Listener) error {
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- s.http.Serve(ln)
+	}()
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(sig)
+
+	select {
+	case err := <-errCh:
+		return err
+	case v := <-sig:
+		logging.Default().Info("shutting down", "signal", v)
+	}
+	return s.Shutdown()
+}
+
+// OnShutdown registers the resources to be closed on shutdown,
+// e.g. message bus publishers to flush pending messages and the storage clients.
+// The resources are closed in the order of registration.
+func (s *Server) OnShutdown(closers ...io.Closer) {
+	s.closers = append(s.closers, closers...)
+}
+
+// ErrShutdownTimeout is returned when in-flight requests are not drained before the shutdown deadline.
+var ErrShutdownTimeout = errors.New("shutdown timeout exceeded, in-flight requests are canceled")
+
+// Shutdown stops accepting new connections, and waits for in-flight requests to complete.
+// If the requests are not complete before the shutdown timeout, their contexts are canceled.
+// The registered resources are closed afterwards.
+func (s *Server) Shutdown() error {
+	done := make(chan error, 1)
+	go func() {
+		done <- s.http.Shutdown()
+	}()
+
+	var err error
+	timer := time.NewTimer(s.shutdownTimeout)
+	defer timer.Stop()
+	select {
+	case err = <-done:
+	case <-timer.C:
+		err = ErrShutdownTimeout
+	}
+	if s.handlers.cancel != nil {
+		s.handlers.cancel()
+	}
+
+	for _, c := range s.closers {
+		if errClose := c.Close(); errClose != nil {
+			logging.Default().Error("failed to close", "error", errClose)
+			if err == nil {
+				err = errClose
+			}
+		}
+	}
+	return err
+}
+
+// serverLogger writes the errors of the underlying server, e.g. the connection errors, to the default logger.
+type serverLogger struct{}
+
+func (serverLogger) Printf(format string, args ...interface{}) {
+	logging.Default().Error(fmt.Sprintf(format, args...))
 }
 
 // NewServerDummy instantiates new HTTP server for test purposes.
 func NewServerDummy() *Server {
+	return newServer(NewRequestHandlersDummy())
+}
+
+func newServer(handlers *Handlers) *Server {
 	cfg := NewConfig()
 	return &Server{
-		&http.Server{
-			Logger:               logger,
+		http: &http.Server{
+			Logger:               serverLogger{},
 			ReadTimeout:          cfg.ReadTimeout,
 			WriteTimeout:         cfg.WriteTimeout,
 			MaxRequestBodySize:   cfg.MaxRequestBodySize,
@@ -53,16 +145,16 @@ func NewServerDummy() *Server {
 			WriteBufferSize:      cfg.WriterBufferSize,
 			Concurrency:          cfg.Concurrency,
 			NoDefaultContentType: true,
-			Handler:              NewRequestHandlersDummy().Router(),
+			Handler:              handlers.Router(),
 		},
+		handlers:        handlers,
+		shutdownTimeout: cfg.ShutdownTimeout,
 	}
 }
 
 // NewServer instantiates new HTTP server.
 func NewServer(handlers *Handlers) *Server {
-	s := NewServerDummy()
-	s.http.Handler = handlers.Router()
-	return s
+	return newServer(handlers)
 }
 
 // WithConfig adds custom configuration to the server.
@@ -73,6 +165,7 @@ func (s *Server) WithConfig(cfg *Config) *Server {
 	s.http.ReadBufferSize = cfg.ReaderBufferSize
 	s.http.WriteBufferSize = cfg.WriterBufferSize
 	s.http.Concurrency = cfg.Concurrency
+	s.shutdownTimeout = cfg.ShutdownTimeout
 	return s
 }
 
@@ -81,26 +174,41 @@ func (s *Server) SetName(n string) {
 	s.http.Name = n
 }
 
-const healthcheckEndpointRoute = "/healthcheck"
+const (
+	healthcheckEndpointRoute = "/healthcheck"
+	metricsEndpointRoute     = "/metrics"
+	readinessEndpointRoute   = "/readiness"
+	openAPIEndpointRoute     = "/openapi.json"
+)
 
 // Handlers defines the request handlers for the server endpoint(s).
-// It includes the endpoint to resolve GET requests to the "/healthcheck" route to check the services status.
+// It includes the endpoint to resolve GET requests to the "/healthcheck" route to check the services status,
+// and the endpoint to resolve GET requests to the "/metrics" route to export the metrics in the Prometheus text format.
 type Handlers struct {
 	// Endpoints defines the map of endpoint handlers.
+	// The key is the route, e.g. "/deadletter/{:submission_id}", served for the endpoint's allowed methods,
+	// or the method and the route, e.g. "GET /deadletter/{:submission_id}", served for that method only,
+	// to set a different handler per method for the same route.
 	Endpoints map[string]*HandlerEndpoint
 	// DefaultHeaders define the handlers to be append to all responses for all endpoints.
 	// It may be useful for CORS settings.
 	DefaultHeaders map[string]string
-	// Routing defines the routes mapping
-	Routing *Routes
+	// ctx defines the parent context of the requests, it is canceled on shutdown
+	ctx    context.Context
+	cancel context.CancelFunc
+	// validateResponses activates the validation of the responses against the documented schemas
+	validateResponses bool
 }
 
 // NewRequestHandlersDummy defines dummy endpoints handler.
 // It includes only "/healthcheck" endpoint for test purposes.
 func NewRequestHandlersDummy() *Handlers {
+	ctx, cancel := context.WithCancel(context.Background())
 	return &Handlers{
 		Endpoints:      map[string]*HandlerEndpoint{healthcheckEndpointRoute: HealthcheckHandler},
 		DefaultHeaders: map[string]string{},
+		ctx:            ctx,
+		cancel:         cancel,
 	}
 }
 
@@ -109,14 +217,15 @@ func NewRequestHandlers(endpoints map[string]*HandlerEndpoint) *Handlers {
 	if _, ok := endpoints[healthcheckEndpointRoute]; !ok {
 		endpoints[healthcheckEndpointRoute] = HealthcheckHandler
 	}
-	r := Routes{}
-	for route := range endpoints {
-		r = append(r, NewRouteElement(route))
+	if _, ok := endpoints[metricsEndpointRoute]; !ok {
+		endpoints[metricsEndpointRoute] = MetricsHandler
 	}
+	ctx, cancel := context.WithCancel(context.Background())
 	return &Handlers{
 		Endpoints:      endpoints,
 		DefaultHeaders: map[string]string{},
-		Routing:        &r,
+		ctx:            ctx,
+		cancel:         cancel,
 	}
 }
 
@@ -132,11 +241,52 @@ func (h *Handlers) WithoutHealthCheck() *Handlers {
 	return h
 }
 
+// WithReadiness activates the endpoint to resolve GET requests to the "/readiness" route
+// to check the service dependencies, e.g. the storage bucket and the message bus topics.
+func (h *Handlers) WithReadiness(c *health.Checker) *Handlers {
+	h.Endpoints[readinessEndpointRoute] = NewReadinessHandler(c)
+	return h
+}
+
+// WithOpenAPI activates the endpoint to resolve GET requests to the "/openapi.json" route
+// to serve the OpenAPI document of the endpoints, see the method OpenAPI.
+func (h *Handlers) WithOpenAPI(info openapi.Info) *Handlers {
+	h.Endpoints[openAPIEndpointRoute] = NewHandlerEndpoint(ActionOpenAPI(h, info), []string{"GET"}).WithDoc(&EndpointDoc{
+		ID:          "getOpenAPI",
+		Description: "Fetch the OpenAPI document of the service.",
+		Responses:   map[int]*ResponseDoc{http.StatusOK: {Description: "OpenAPI document"}},
+	})
+	return h
+}
+
+// WithResponseValidation activates the validation of the JSON responses against the schemas documented for the endpoints.
+// The responses violating the contract are replaced with the internal error response.
+// It's meant for the test and dev environments to catch the contract regressions.
+func (h *Handlers) WithResponseValidation() *Handlers {
+	h.validateResponses = true
+	return h
+}
+
+// WithoutMetrics deactivates the default metrics endpoint.
+func (h *Handlers) WithoutMetrics() *Handlers {
+	delete(h.Endpoints, metricsEndpointRoute)
+	return h
+}
+
 // AddEndpointHandler add the handler to resolve requests to the route endpoint.
+// The route can be prefixed with the method, see Handlers.Endpoints.
 func (h *Handlers) AddEndpointHandler(route string, handler *HandlerEndpoint) {
 	h.Endpoints[route] = handler
 }
 
+// context returns the parent context of the requests.
+func (h *Handlers) context() context.Context {
+	if h.ctx == nil {
+		return context.Background()
+	}
+	return h.ctx
+}
+
 func (h *Handlers) addDefaultHeaders(ctx *http.RequestCtx) {
 	for k, v := range h.DefaultHeaders {
 		ctx.Response.Header.Add(k, v)
@@ -144,7 +294,14 @@ func (h *Handlers) addDefaultHeaders(ctx *http.RequestCtx) {
 }
 
 func (h *Handlers) reply(ctx *http.RequestCtx, actionResp *Response) {
-	ctx.SetBody(actionResp.Body)
+	if actionResp.BodyStream != nil {
+		ctx.SetBodyStreamWriter(func(w *bufio.Writer) {
+			// the error is logged by the stream wrapper set by the router
+			actionResp.BodyStream(w)
+		})
+	} else {
+		ctx.SetBody(actionResp.Body)
+	}
 	ctx.SetStatusCode(actionResp.StatusCode)
 	ctx.SetContentType(actionResp.ContentType)
 	for k, v := range actionResp.Headers {
@@ -152,76 +309,181 @@ func (h *Handlers) reply(ctx *http.RequestCtx, actionResp *Response) {
 	}
 }
 
-func (h *Handlers) router(ctx *http.RequestCtx) {
-	h.addDefaultHeaders(ctx)
-	p := string(ctx.Path())
-	hdlr, ok := h.Endpoints[p]
+// Validate checks that the endpoints routes are well-formed and do not conflict.
+func (h *Handlers) Validate() error {
+	_, err := newRoutingTree(h.Endpoints)
+	return err
+}
 
-	missingRoute := func() {
-		e := "unsupported path"
-		logger.Println(e)
-		h.reply(ctx, NewResponse([]byte(fmt.Sprintf(`{"error": "%s"}`, e)), http.StatusNotFound))
+func (h *Handlers) router(ctx *http.RequestCtx, o *observation, tree *node) {
+	h.addDefaultHeaders(ctx)
+	method := string(ctx.Method())
+	rawPath := ctx.URI().PathOriginal()
+	if len(rawPath) == 0 {
+		rawPath = ctx.Path()
 	}
-
-	routeParameters := map[string]string{}
-
-	if !ok {
-		r := h.Routing.ParseRaw(p)
-		if r == nil {
-			missingRoute()
-			return
-		}
-		hdlr, ok = h.Endpoints[r.Source]
-		if !ok {
-			missingRoute()
-			return
-		}
-		routeParameters = r.GetPositionalQuery()
+	m, err := tree.match(method, string(rawPath))
+	if err != nil {
+		h.replyError(ctx, o, err)
+		return
 	}
-
-	method := string(ctx.Method())
-	if !hdlr.IsAllowedMethod(method) {
+	if m == nil {
+		h.replyError(ctx, o, NewError(KindNotFound, "unsupported path"))
+		return
+	}
+	o.route = m.route
+	if m.endpoint == nil {
 		e := "unsupported method"
-		logger.Println(e)
-		h.reply(ctx, NewResponse([]byte(fmt.Sprintf(`{"error": "%s"}`, e)), http.StatusMethodNotAllowed))
+		o.logger().Warning(e)
+		ctx.Response.Header.Set("Allow", m.allow)
+		h.reply(ctx, NewErrorResponse(e, http.StatusMethodNotAllowed))
 		return
 	}
-	resp, err := hdlr.ProcessRequest(&Request{
+	hdlr := m.endpoint
+	reqCtx, cancel := hdlr.newContext(logging.NewContext(o.ctx, o.logger()))
+	// the context is canceled after the payload is streamed, or when the router returns or panics otherwise
+	streamed := false
+	defer func() {
+		if !streamed {
+			cancel()
+		}
+	}()
+	req := &Request{
 		Method:          method,
-		RouteParameters: routeParameters,
+		RouteParameters: m.params,
 		Query:           ParseRequestKV(ctx.QueryArgs().VisitAll),
 		Headers:         ParseRequestKV(ctx.Request.Header.VisitAll),
 		Body:            ctx.Request.Body(),
-	})
+		ctx:             reqCtx,
+	}
+	if hdlr.Doc != nil {
+		if err := hdlr.Doc.validateRequest(req); err != nil {
+			h.replyError(ctx, o, err)
+			return
+		}
+	}
+	resp, err := hdlr.ProcessRequest(req)
 	if err != nil {
-		h.reply(ctx,
-			NewResponse(
-				[]byte(fmt.Sprintf(`{"error": "%s"}`, err.Error())),
-				http.StatusInternalServerError,
-			),
-		)
+		h.replyError(ctx, o, err)
+		return
+	}
+	if resp == nil {
+		h.replyError(ctx, o, errors.New("no response returned by the action"))
+		return
+	}
+	if h.validateResponses && hdlr.Doc != nil {
+		if err := hdlr.Doc.validateResponse(resp); err != nil {
+			h.replyError(ctx, o, err)
+			return
+		}
+	}
+	if resp.BodyStream != nil {
+		// the payload is streamed after the router returns
+		stream := resp.BodyStream
+		statusCode := resp.StatusCode
+		streamed, o.streamed = true, true
+		conn, writeTimeout := ctx.Conn(), hdlr.StreamWriteTimeout
+		resp.BodyStream = func(w io.Writer) (err error) {
+			defer cancel()
+			if conn != nil && writeTimeout > 0 {
+				w = &deadlineWriter{w: w, conn: conn, timeout: writeTimeout}
+			}
+			cw := &countingWriter{w: w}
+			defer func() {
+				// the stream is run by the server past the router recovery, hence the panic is recovered here;
+				// the status code is already sent, the failure is recorded by the request metrics and span only
+				if r := recover(); r != nil {
+					err = panicError(r)
+					o.logger().Error("stream panic recovered", "error", err, "stack", string(debug.Stack()))
+					statusCode = http.StatusInternalServerError
+				}
+				o.done(statusCode, cw.n)
+			}()
+			err = stream(cw)
+			if err != nil {
+				o.logger().Error("stream failed", "error", err)
+			}
+			return err
+		}
+	}
+	h.reply(ctx, resp)
+}
+
+// deadlineWriter sets the connection write deadline before every write to the underlying writer.
+type deadlineWriter struct {
+	w       io.Writer
+	conn    net.Conn
+	timeout time.Duration
+}
+
+func (d *deadlineWriter) Write(p []byte) (int, error) {
+	if err := d.conn.SetWriteDeadline(time.Now().Add(d.timeout)); err != nil {
+		return 0, err
+	}
+	return d.w.Write(p)
+}
+
+// Flush flushes the underlying writer if it's buffered.
+func (d *deadlineWriter) Flush() error {
+	f, ok := d.w.(interface{ Flush() error })
+	if !ok {
+		return nil
+	}
+	if err := d.conn.SetWriteDeadline(time.Now().Add(d.timeout)); err != nil {
+		return err
+	}
+	return f.Flush()
+}
+
+// replyError replies with the JSON error body and the status code of the error kind.
+// The internal errors are logged with the details hidden from the client.
+func (h *Handlers) replyError(ctx *http.RequestCtx, o *observation, err error) {
+	resp := errorToResponse(err)
+	if resp.StatusCode >= http.StatusInternalServerError {
+		o.logger().Error("request failed", "error", err)
+	} else {
+		o.logger().Info("request rejected", "error", err, "status", resp.StatusCode)
 	}
 	h.reply(ctx, resp)
 }
 
 // Router defines the request routing to corresponding handler.
+// It guarantees the JSON error body for every failure mode, including panics.
+// The routing is set from the endpoints registered by the time of the call,
+// it panics if the routes are malformed or conflict, see the method Validate.
 func (h *Handlers) Router() http.RequestHandler {
+	tree, err := newRoutingTree(h.Endpoints)
+	if err != nil {
+		panic(err)
+	}
 	return func(ctx *http.RequestCtx) {
+		o := newObservation(h.context(), ctx)
 		defer func() {
 			if r := recover(); r != nil {
-				var err error
-				switch t := r.(type) {
-				case string:
-					err = errors.New(t)
-				case error:
-					err = t
-				default:
-					err = errors.New("unknown error")
-				}
-				logger.Println(err)
+				err := panicError(r)
+				o.logger().Error("panic recovered", "error", err, "stack", string(debug.Stack()))
+				// the partially set response, including the body stream, is discarded
+				ctx.Response.ResetBody()
+				o.streamed = false
+				h.reply(ctx, NewErrorResponse(internalErrorMessage, http.StatusInternalServerError))
+			}
+			if !o.streamed {
+				o.done(ctx.Response.StatusCode(), len(ctx.Response.Body()))
 			}
 		}()
-		h.router(ctx)
+		h.router(ctx, o, tree)
+	}
+}
+
+// panicError converts the recovered panic value to the error.
+func panicError(r interface{}) error {
+	switch t := r.(type) {
+	case string:
+		return errors.New(t)
+	case error:
+		return t
+	default:
+		return fmt.Errorf("unknown error: %v", t)
 	}
 }
 
@@ -0,0 +1,477 @@
+/* Copyright 2021 Dmitry Kisler dkisler.com
+
+Licensed under the Apache License,Version 2.0 (the "License");
+you may not use this file except in compliance with the License. You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED,
+INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE,
+AND NONINFRINGEMENT. IN NO EVENT WILL THE LICENSOR OR OTHER CONTRIBUTORS BE LIABLE FOR ANY CLAIM, DAMAGES,
+OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF,
+OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+See the License for the specific language governing permissions and limitations under the License.
+*/
+
+package http_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	nethttp "net/http"
+	"os"
+	"platform/lib/api/http"
+	"platform/lib/health"
+	"platform/lib/logging"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/valyala/fasthttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	oteltrace "go.opentelemetry.io/otel/trace"
+)
+
+func TestMain(m *testing.M) {
+	// the services set the tracer provider and the W3C Trace Context propagator on start
+	otel.SetTracerProvider(sdktrace.NewTracerProvider())
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	os.Exit(m.Run())
+}
+
+func TestRequestContext(t *testing.T) {
+	var reqCtx context.Context
+	action := func(r *http.Request) (*http.Response, error) {
+		reqCtx = r.Context()
+		return http.NewResponse([]byte(`{}`), fasthttp.StatusOK), nil
+	}
+	handlers := http.NewRequestHandlers(map[string]*http.HandlerEndpoint{
+		"/foo": http.NewHandlerEndpoint(action, []string{"GET"}).WithTimeout(time.Minute),
+	})
+
+	ctx := &fasthttp.RequestCtx{}
+	ctx.Request.SetRequestURI("/foo")
+	handlers.Router()(ctx)
+
+	if _, ok := reqCtx.Deadline(); !ok {
+		t.Fatal("error!\nwant: context with deadline\ngot: no deadline")
+	}
+	if reqCtx.Err() != context.Canceled {
+		t.Fatalf("error!\nwant: %v\ngot: %v", context.Canceled, reqCtx.Err())
+	}
+}
+
+type closer struct {
+	closed bool
+}
+
+func (c *closer) Close() error {
+	c.closed = true
+	return nil
+}
+
+// serve starts the server with the endpoint which completes after the delay.
+func serve(t *testing.T, delay, shutdownTimeout time.Duration) (*http.Server, string, chan error) {
+	action := func(r *http.Request) (*http.Response, error) {
+		select {
+		case <-time.After(delay):
+			return http.NewResponse([]byte(`{}`), fasthttp.StatusOK), nil
+		case <-r.Context().Done():
+			return http.NewResponse([]byte(`{}`), fasthttp.StatusServiceUnavailable), nil
+		}
+	}
+	cfg := http.NewConfig()
+	cfg.SetShutdownTimeout(shutdownTimeout)
+	s := http.NewServer(http.NewRequestHandlers(map[string]*http.HandlerEndpoint{
+		"/slow": http.NewHandlerEndpoint(action, []string{"GET"}),
+	})).WithConfig(cfg)
+	ln, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Serve(ln)
+
+	status := make(chan error, 1)
+	go func() {
+		resp, err := nethttp.Get(fmt.Sprintf("http://%s/slow", ln.Addr()))
+		if err != nil {
+			status <- err
+			return
+		}
+		defer resp.Body.Close()
+		io.Copy(io.Discard, resp.Body)
+		if resp.StatusCode != fasthttp.StatusOK {
+			status <- fmt.Errorf("status code %d", resp.StatusCode)
+			return
+		}
+		status <- nil
+	}()
+	// wait for the request to be in-flight
+	time.Sleep(50 * time.Millisecond)
+	return s, ln.Addr().String(), status
+}
+
+func TestShutdown(t *testing.T) {
+	s, addr, status := serve(t, 200*time.Millisecond, time.Second)
+	c := &closer{}
+	s.OnShutdown(c)
+
+	if err := s.Shutdown(); err != nil {
+		t.Fatalf("error!\nwant: %v\ngot: %v", nil, err)
+	}
+	if err := <-status; err != nil {
+		t.Fatalf("error!\nwant: in-flight request completed\ngot: %v", err)
+	}
+	if !c.closed {
+		t.Fatal("error!\nwant: resources closed\ngot: not closed")
+	}
+	if _, err := net.Dial("tcp4", addr); err == nil {
+		t.Fatal("error!\nwant: new connections refused\ngot: connected")
+	}
+}
+
+func TestShutdownTimeout(t *testing.T) {
+	s, _, status := serve(t, time.Minute, 100*time.Millisecond)
+	c := &closer{}
+	s.OnShutdown(c)
+
+	if err := s.Shutdown(); !errors.Is(err, http.ErrShutdownTimeout) {
+		t.Fatalf("error!\nwant: %v\ngot: %v", http.ErrShutdownTimeout, err)
+	}
+	if err := <-status; err == nil {
+		t.Fatal("error!\nwant: in-flight request canceled\ngot: completed")
+	}
+	if !c.closed {
+		t.Fatal("error!\nwant: resources closed\ngot: not closed")
+	}
+}
+
+// TestStreamWriteTimeout checks that the streamed payload outlasting the server write timeout is not cut
+// if the endpoint sets the deadline of every write.
+func TestStreamWriteTimeout(t *testing.T) {
+	const want = "0\n1\n2\n3\n4\n"
+	action := func(r *http.Request) (*http.Response, error) {
+		return http.NewStreamResponse(func(w io.Writer) error {
+			for i := 0; i < 5; i++ {
+				time.Sleep(50 * time.Millisecond)
+				fmt.Fprintf(w, "%d\n", i)
+				if err := w.(interface{ Flush() error }).Flush(); err != nil {
+					return err
+				}
+			}
+			return nil
+		}, fasthttp.StatusOK), nil
+	}
+	cfg := http.NewConfig()
+	cfg.SetWriteTimeout(100 * time.Millisecond)
+	s := http.NewServer(http.NewRequestHandlers(map[string]*http.HandlerEndpoint{
+		"/stream": http.NewHandlerEndpoint(action, []string{"GET"}).WithStreamWriteTimeout(time.Second),
+		"/cut":    http.NewHandlerEndpoint(action, []string{"GET"}),
+	})).WithConfig(cfg)
+	ln, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Serve(ln)
+	defer s.Shutdown()
+
+	get := func(path string) (string, error) {
+		resp, err := nethttp.Get(fmt.Sprintf("http://%s%s", ln.Addr(), path))
+		if err != nil {
+			return "", err
+		}
+		defer resp.Body.Close()
+		b, err := io.ReadAll(resp.Body)
+		return string(b), err
+	}
+	if got, err := get("/stream"); err != nil || got != want {
+		t.Fatalf("error!\nwant: %q\ngot: %q, %v", want, got, err)
+	}
+	if got, err := get("/cut"); err == nil && got == want {
+		t.Fatal("error!\nwant: stream cut by the server write timeout\ngot: completed")
+	}
+}
+
+func TestStreamPanic(t *testing.T) {
+	s := http.NewServer(http.NewRequestHandlers(map[string]*http.HandlerEndpoint{
+		"/panic": http.NewHandlerEndpoint(func(r *http.Request) (*http.Response, error) {
+			return http.NewStreamResponse(func(w io.Writer) error {
+				fmt.Fprintln(w, "0")
+				panic("stream failed")
+			}, fasthttp.StatusOK), nil
+		}, []string{"GET"}),
+		"/ok": http.NewHandlerEndpoint(func(r *http.Request) (*http.Response, error) {
+			return http.NewResponse([]byte(`{}`), fasthttp.StatusOK), nil
+		}, []string{"GET"}),
+	}))
+	ln, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Serve(ln)
+	defer s.Shutdown()
+	defer nethttp.DefaultClient.CloseIdleConnections()
+
+	for _, path := range []string{"/panic", "/ok"} {
+		resp, err := nethttp.Get(fmt.Sprintf("http://%s%s", ln.Addr(), path))
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != fasthttp.StatusOK {
+			t.Fatalf("error!\nwant: %d\ngot: %d", fasthttp.StatusOK, resp.StatusCode)
+		}
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	action := func(r *http.Request) (*http.Response, error) {
+		return http.NewResponse([]byte(`{"id": "`+r.RouteParameters["id"]+`"}`), fasthttp.StatusOK), nil
+	}
+	handlers := http.NewRequestHandlers(map[string]*http.HandlerEndpoint{
+		"/metrics-test/{:id}": http.NewHandlerEndpoint(action, []string{"POST"}),
+	})
+	router := handlers.Router()
+
+	for _, uri := range []string{"/metrics-test/a", "/metrics-test/b", "/metrics-test-missing"} {
+		ctx := &fasthttp.RequestCtx{}
+		ctx.Request.Header.SetMethod("POST")
+		ctx.Request.SetRequestURI(uri)
+		ctx.Request.SetBodyString(`{}`)
+		router(ctx)
+	}
+
+	ctx := &fasthttp.RequestCtx{}
+	ctx.Request.SetRequestURI("/metrics")
+	router(ctx)
+
+	if got := string(ctx.Response.Header.ContentType()); !strings.HasPrefix(got, "text/plain") {
+		t.Fatalf("error!\nwant: %v\ngot: %v", "text/plain", got)
+	}
+	body := string(ctx.Response.Body())
+	for _, want := range []string{
+		`http_requests_total{method="POST",route="/metrics-test/{:id}",status="200"} 2`,
+		`http_requests_total{method="POST",route="unmatched",status="404"} 1`,
+		`http_request_size_bytes_sum{method="POST",route="/metrics-test/{:id}",status="200"} 4`,
+		`http_response_size_bytes_sum{method="POST",route="/metrics-test/{:id}",status="200"} 22`,
+		`http_request_duration_seconds_count{method="POST",route="/metrics-test/{:id}",status="200"} 2`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Fatalf("error!\nwant: %v\ngot: %v", want, body)
+		}
+	}
+
+	// the metrics are compressed for the clients accepting gzip
+	ctx = &fasthttp.RequestCtx{}
+	ctx.Request.SetRequestURI("/metrics")
+	ctx.Request.Header.Set("Accept-Encoding", "gzip")
+	router(ctx)
+	if got := string(ctx.Response.Header.Peek("Content-Encoding")); got != "gzip" {
+		t.Fatalf("error!\nwant: %v\ngot: %v", "gzip", got)
+	}
+}
+
+func TestTracePropagation(t *testing.T) {
+	const traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+	var sc oteltrace.SpanContext
+	action := func(r *http.Request) (*http.Response, error) {
+		sc = oteltrace.SpanContextFromContext(r.Context())
+		return http.NewResponse([]byte(`{}`), fasthttp.StatusOK), nil
+	}
+	handlers := http.NewRequestHandlers(map[string]*http.HandlerEndpoint{
+		"/foo": http.NewHandlerEndpoint(action, []string{"GET"}),
+	})
+
+	ctx := &fasthttp.RequestCtx{}
+	ctx.Request.SetRequestURI("/foo")
+	ctx.Request.Header.Set("traceparent", "00-"+traceID+"-00f067aa0ba902b7-01")
+	handlers.Router()(ctx)
+
+	traceparent := fmt.Sprintf("00-%s-%s-%s", sc.TraceID(), sc.SpanID(), sc.TraceFlags())
+	if sc.TraceID().String() != traceID || sc.SpanID().String() == "00f067aa0ba902b7" {
+		t.Fatalf("error!\nwant: server span of the trace %v\ngot: %v", traceID, traceparent)
+	}
+	if got := string(ctx.Response.Header.Peek("traceparent")); got != traceparent {
+		t.Fatalf("error!\nwant: %v\ngot: %v", traceparent, got)
+	}
+}
+
+func TestRequestLogger(t *testing.T) {
+	var buf bytes.Buffer
+	defaultLogger := logging.Default()
+	logging.SetDefault(logging.New(&buf, logging.NewConfig()))
+	defer logging.SetDefault(defaultLogger)
+
+	action := func(r *http.Request) (*http.Response, error) {
+		logging.FromContext(r.Context()).Info("processed")
+		return http.NewResponse([]byte(`{}`), fasthttp.StatusOK), nil
+	}
+	handlers := http.NewRequestHandlers(map[string]*http.HandlerEndpoint{
+		"/foo/{:id}": http.NewHandlerEndpoint(action, []string{"GET"}),
+	})
+
+	ctx := &fasthttp.RequestCtx{}
+	ctx.Request.SetRequestURI("/foo/bar")
+	ctx.Request.Header.Set("X-Request-Id", "req-1")
+	handlers.Router()(ctx)
+
+	if got := string(ctx.Response.Header.Peek("X-Request-Id")); got != "req-1" {
+		t.Fatalf("error!\nwant: %v\ngot: %v", "req-1", got)
+	}
+	for _, want := range []string{`"message":"processed"`, `"request_id":"req-1"`, `"route":"/foo/{:id}"`, `"trace_id":`} {
+		if !strings.Contains(buf.String(), want) {
+			t.Fatalf("error!\nwant: %v\ngot: %v", want, buf.String())
+		}
+	}
+
+	// the request ID is generated if not set
+	ctx = &fasthttp.RequestCtx{}
+	ctx.Request.SetRequestURI("/foo/bar")
+	handlers.Router()(ctx)
+	if got := ctx.Response.Header.Peek("X-Request-Id"); len(got) != 32 {
+		t.Fatalf("error!\nwant: generated request ID\ngot: %q", got)
+	}
+}
+
+func TestReadiness(t *testing.T) {
+	tests := []struct {
+		err        error
+		wantStatus int
+		wantBody   string
+	}{
+		{nil, fasthttp.StatusOK, `"status":"ok"`},
+		{errors.New("bucket not found"), fasthttp.StatusServiceUnavailable, `"error":"bucket not found"`},
+	}
+	for _, test := range tests {
+		err := test.err
+		checker := health.NewChecker().Register("gcs", func(ctx context.Context) error { return err })
+		handlers := http.NewRequestHandlers(map[string]*http.HandlerEndpoint{}).WithReadiness(checker)
+
+		ctx := &fasthttp.RequestCtx{}
+		ctx.Request.SetRequestURI("/readiness")
+		handlers.Router()(ctx)
+
+		if ctx.Response.StatusCode() != test.wantStatus {
+			t.Fatalf("error!\nwant: %v\ngot: %v", test.wantStatus, ctx.Response.StatusCode())
+		}
+		if body := string(ctx.Response.Body()); !strings.Contains(body, test.wantBody) || !strings.Contains(body, `"latency_ms"`) {
+			t.Fatalf("error!\nwant: %v\ngot: %v", test.wantBody, body)
+		}
+	}
+}
+
+func TestErrorResponses(t *testing.T) {
+	tests := []struct {
+		name       string
+		action     http.Action
+		uri        string
+		wantStatus int
+		wantError  string
+	}{
+		{
+			name: "validation",
+			action: func(r *http.Request) (*http.Response, error) {
+				return nil, http.NewError(http.KindValidation, `invalid "q" param`)
+			},
+			wantStatus: fasthttp.StatusBadRequest,
+			wantError:  `invalid "q" param`,
+		},
+		{
+			name: "not found",
+			action: func(r *http.Request) (*http.Response, error) {
+				return nil, fmt.Errorf("wrapped: %w", http.NewError(http.KindNotFound, "not found"))
+			},
+			wantStatus: fasthttp.StatusNotFound,
+			wantError:  "not found",
+		},
+		{
+			name: "conflict",
+			action: func(r *http.Request) (*http.Response, error) {
+				return nil, http.WrapError(http.KindConflict, errors.New("already exists"))
+			},
+			wantStatus: fasthttp.StatusConflict,
+			wantError:  "already exists",
+		},
+		{
+			name: "unauthorized",
+			action: func(r *http.Request) (*http.Response, error) {
+				return nil, http.NewError(http.KindUnauthorized, "missing token")
+			},
+			wantStatus: fasthttp.StatusUnauthorized,
+			wantError:  "missing token",
+		},
+		{
+			name: "internal typed",
+			action: func(r *http.Request) (*http.Response, error) {
+				return nil, http.WrapError(http.KindInternal, errors.New("dial tcp 10.0.0.1: refused"))
+			},
+			wantStatus: fasthttp.StatusInternalServerError,
+			wantError:  "internal error",
+		},
+		{
+			name: "internal untyped",
+			action: func(r *http.Request) (*http.Response, error) {
+				return nil, errors.New("dial tcp 10.0.0.1: refused")
+			},
+			wantStatus: fasthttp.StatusInternalServerError,
+			wantError:  "internal error",
+		},
+		{
+			name: "nil response",
+			action: func(r *http.Request) (*http.Response, error) {
+				return nil, nil
+			},
+			wantStatus: fasthttp.StatusInternalServerError,
+			wantError:  "internal error",
+		},
+		{
+			name: "panic",
+			action: func(r *http.Request) (*http.Response, error) {
+				var m map[string]string
+				m["boom"] = "boom"
+				return nil, nil
+			},
+			wantStatus: fasthttp.StatusInternalServerError,
+			wantError:  "internal error",
+		},
+		{
+			name:       "unsupported path",
+			uri:        "/bar",
+			wantStatus: fasthttp.StatusNotFound,
+			wantError:  "unsupported path",
+		},
+	}
+	for _, test := range tests {
+		handlers := http.NewRequestHandlers(map[string]*http.HandlerEndpoint{
+			"/foo": http.NewHandlerEndpoint(test.action, []string{"GET"}),
+		})
+		ctx := &fasthttp.RequestCtx{}
+		uri := test.uri
+		if uri == "" {
+			uri = "/foo"
+		}
+		ctx.Request.SetRequestURI(uri)
+		handlers.Router()(ctx)
+
+		if ctx.Response.StatusCode() != test.wantStatus {
+			t.Fatalf("error for %s!\nwant: %v\ngot: %v", test.name, test.wantStatus, ctx.Response.StatusCode())
+		}
+		var body struct {
+			Error string `json:"error"`
+		}
+		if err := json.Unmarshal(ctx.Response.Body(), &body); err != nil {
+			t.Fatalf("error for %s!\nwant: JSON error body\ngot: %q", test.name, ctx.Response.Body())
+		}
+		if body.Error != test.wantError {
+			t.Fatalf("error for %s!\nwant: %v\ngot: %v", test.name, test.wantError, body.Error)
+		}
+	}
+}
@@ -0,0 +1,201 @@
+/* Copyright 2021 Dmitry Kisler dkisler.com
+
+Licensed under the Apache License,Version 2.0 (the "License");
+you may not use this file except in compliance with the License. You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED,
+INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE,
+AND NONINFRINGEMENT. IN NO EVENT WILL THE LICENSOR OR OTHER CONTRIBUTORS BE LIABLE FOR ANY CLAIM, DAMAGES,
+OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF,
+OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+See the License for the specific language governing permissions and limitations under the License.
+*/
+
+package http
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"io"
+	"platform/lib/logging"
+	"platform/lib/trace"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/prometheus/common/expfmt"
+	http "github.com/valyala/fasthttp"
+	"github.com/valyala/fasthttp/fasthttpadaptor"
+	"go.opentelemetry.io/otel"
+	semconv "go.opentelemetry.io/otel/semconv/v1.10.0"
+	oteltrace "go.opentelemetry.io/otel/trace"
+)
+
+var (
+	requestsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "http_requests_total",
+		Help: "Number of HTTP requests.",
+	}, []string{"route", "method", "status"})
+	requestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "http_request_duration_seconds",
+		Help:    "HTTP requests latency in seconds, including the streamed payload.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"route", "method", "status"})
+	requestSize = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "http_request_size_bytes",
+		Help:    "HTTP requests body size in bytes.",
+		Buckets: sizeBuckets,
+	}, []string{"route", "method", "status"})
+	responseSize = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "http_response_size_bytes",
+		Help:    "HTTP responses body size in bytes.",
+		Buckets: sizeBuckets,
+	}, []string{"route", "method", "status"})
+)
+
+// sizeBuckets defines the histogram buckets to measure sizes in bytes, from 64B to 16MB.
+var sizeBuckets = prometheus.ExponentialBuckets(64, 4, 10)
+
+// tracer starts the server spans.
+var tracer = otel.Tracer("platform/lib/api/http")
+
+// routeUnmatched defines the route label of requests to unsupported paths.
+// The raw path is not used to bound the number of series.
+const routeUnmatched = "unmatched"
+
+// observation defines the request metrics and the server span recorded once the response is sent.
+type observation struct {
+	// route defines the route template, e.g. /deadletter/{:submission_id}
+	route       string
+	method      string
+	start       time.Time
+	requestSize int
+	// streamed is set if the response payload is streamed after the router returns
+	streamed bool
+	// requestID defines the request identifier, either from the X-Request-Id header or the generated one
+	requestID string
+	// ctx defines the parent context of the request with the server span set as the current span
+	ctx  context.Context
+	span oteltrace.Span
+}
+
+// requestIDHeader defines the header to correlate the requests, the request ID is returned in the response header.
+const requestIDHeader = "X-Request-Id"
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// newObservation starts the server span as the child of the span propagated by the request headers, e.g. W3C traceparent.
+// The trace context of the server span is returned to the client in the response headers.
+func newObservation(parent context.Context, ctx *http.RequestCtx) *observation {
+	o := &observation{
+		route:       routeUnmatched,
+		method:      string(ctx.Method()),
+		start:       time.Now(),
+		requestSize: len(ctx.Request.Body()),
+		requestID:   string(ctx.Request.Header.Peek(requestIDHeader)),
+	}
+	if o.requestID == "" {
+		o.requestID = newRequestID()
+	}
+	ctx.Response.Header.Set(requestIDHeader, o.requestID)
+	// the trace context is read from, and the one of the server span is returned in, the propagator's headers
+	propagator := otel.GetTextMapPropagator()
+	carrier := trace.Carrier{}
+	for _, k := range propagator.Fields() {
+		if v := ctx.Request.Header.Peek(k); len(v) > 0 {
+			carrier[k] = string(v)
+		}
+	}
+	o.ctx, o.span = tracer.Start(propagator.Extract(parent, carrier), "HTTP "+o.method,
+		oteltrace.WithSpanKind(oteltrace.SpanKindServer),
+		oteltrace.WithAttributes(semconv.HTTPMethodKey.String(o.method), semconv.HTTPTargetKey.String(string(ctx.Path()))),
+	)
+	carrier = trace.Carrier{}
+	propagator.Inject(o.ctx, carrier)
+	for k, v := range carrier {
+		ctx.Response.Header.Set(k, v)
+	}
+	return o
+}
+
+// logger returns the request logger correlated to the server span.
+func (o *observation) logger() *logging.Logger {
+	l := logging.Default().With("request_id", o.requestID, "method", o.method, "route", o.route)
+	if sc := o.span.SpanContext(); sc.IsValid() {
+		l = l.WithTrace(sc.TraceID().String(), sc.SpanID().String(), sc.IsSampled())
+	}
+	return l
+}
+
+// done records the request metrics and ends the server span.
+func (o *observation) done(statusCode, size int) {
+	o.span.SetName(o.method + " " + o.route)
+	o.span.SetAttributes(semconv.HTTPRouteKey.String(o.route), semconv.HTTPStatusCodeKey.Int(statusCode))
+	o.span.SetStatus(semconv.SpanStatusFromHTTPStatusCodeAndSpanKind(statusCode, oteltrace.SpanKindServer))
+	o.span.End()
+
+	status := strconv.Itoa(statusCode)
+	requestsTotal.WithLabelValues(o.route, o.method, status).Inc()
+	requestDuration.WithLabelValues(o.route, o.method, status).Observe(time.Since(o.start).Seconds())
+	requestSize.WithLabelValues(o.route, o.method, status).Observe(float64(o.requestSize))
+	responseSize.WithLabelValues(o.route, o.method, status).Observe(float64(size))
+}
+
+// countingWriter counts the bytes written to the underlying writer.
+type countingWriter struct {
+	w io.Writer
+	n int
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += n
+	return n, err
+}
+
+// Flush flushes the underlying writer if it's buffered.
+func (c *countingWriter) Flush() error {
+	if f, ok := c.w.(interface{ Flush() error }); ok {
+		return f.Flush()
+	}
+	return nil
+}
+
+// metricsHandler serves the metrics of the default Prometheus registry.
+var metricsHandler = fasthttpadaptor.NewFastHTTPHandler(promhttp.Handler())
+
+// ActionMetrics defines the function to export the metrics in the Prometheus text format.
+// The request is served by the Prometheus handler, e.g. the response is compressed if the client accepts gzip.
+func ActionMetrics(r *Request) (*Response, error) {
+	ctx := &http.RequestCtx{}
+	ctx.Request.Header.SetMethod(r.Method)
+	ctx.Request.SetRequestURI(metricsEndpointRoute)
+	for k, v := range r.Headers {
+		ctx.Request.Header.Set(k, v)
+	}
+	metricsHandler(ctx)
+	resp := NewResponse(append([]byte(nil), ctx.Response.Body()...), ctx.Response.StatusCode())
+	resp.SetContentType(string(ctx.Response.Header.ContentType()))
+	if enc := ctx.Response.Header.Peek("Content-Encoding"); len(enc) > 0 {
+		resp.AddHeaders(map[string]string{"Content-Encoding": string(enc)})
+	}
+	return resp, nil
+}
+
+// MetricsHandler defines the handler for the metrics endpoint.
+var MetricsHandler = NewHandlerEndpoint(ActionMetrics, []string{"GET"}).WithDoc(&EndpointDoc{
+	ID:          "getMetrics",
+	Description: "Export the metrics in the Prometheus text format.",
+	Responses: map[int]*ResponseDoc{
+		http.StatusOK: {Description: "Success", ContentTypes: []string{string(expfmt.FmtText)}},
+	},
+})
@@ -0,0 +1,253 @@
+/* Copyright 2021 Dmitry Kisler dkisler.com
+
+Licensed under the Apache License,Version 2.0 (the "License");
+you may not use this file except in compliance with the License. You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED,
+INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE,
+AND NONINFRINGEMENT. IN NO EVENT WILL THE LICENSOR OR OTHER CONTRIBUTORS BE LIABLE FOR ANY CLAIM, DAMAGES,
+OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF,
+OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+See the License for the specific language governing permissions and limitations under the License.
+*/
+
+package http
+
+import (
+	_ "embed"
+	"fmt"
+	"platform/lib/api/openapi"
+	"platform/lib/jsonschema"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/goccy/go-json"
+	http "github.com/valyala/fasthttp"
+)
+
+// Schema defines the named JSON schema of the request or the response payload.
+// It's set to the OpenAPI document components and referenced by name,
+// and the payloads are validated against it, see EndpointDoc.
+type Schema struct {
+	Name string
+	JSON json.RawMessage
+	// Validator defines the compiled schema.
+	Validator *jsonschema.Schema
+}
+
+// NewSchema defines the named JSON schema.
+// It panics if the schema is invalid, hence the schemas defined on startup are checked early.
+func NewSchema(name string, b []byte) *Schema {
+	v, err := jsonschema.NewSchema(b)
+	if err != nil {
+		panic(fmt.Sprintf("schema %q: %v", name, err))
+	}
+	return &Schema{Name: name, JSON: b, Validator: v}
+}
+
+//go:embed error.json
+var errorSchema []byte
+
+// ErrorSchema defines the schema of the JSON error body, it documents the error responses of all endpoints.
+var ErrorSchema = NewSchema("error", errorSchema)
+
+// ResponseDoc documents the response of the status code.
+type ResponseDoc struct {
+	Description string
+	// Schema defines the JSON payload schema, it's not set for the payload of other content types.
+	Schema *Schema
+	// ContentTypes defines the payload content types, application/json is set by default.
+	ContentTypes []string
+}
+
+// EndpointDoc documents the endpoint in the OpenAPI document.
+// The requests are validated against the documented request and query parameters schemas,
+// the invalid requests are rejected with the status code 400 and the list of the validation errors.
+// The responses are validated against the documented schemas if the response validation is activated,
+// see the method Handlers.WithResponseValidation.
+type EndpointDoc struct {
+	// ID defines the operation ID, it's suffixed with the method if the endpoint serves more than one method.
+	ID          string
+	Summary     string
+	Description string
+	Tags        []string
+	// Parameters defines the query and the header parameters, the path parameters are derived from the route.
+	Parameters []openapi.Parameter
+	// Request defines the JSON schema of the request payload.
+	Request            *Schema
+	RequestDescription string
+	// SelectRequest selects the JSON schema to validate the request payload against instead of Request,
+	// e.g. by the declared schema version. The error is handled as the payload validation error.
+	SelectRequest func(r *Request) (*Schema, error)
+	// RequestOptional defines that the request payload may be empty, the empty payload is not validated.
+	RequestOptional bool
+	// AcceptInvalidRequest defines that the invalid request payload is passed to the action
+	// with Request.ValidationError set instead of being rejected.
+	AcceptInvalidRequest bool
+	// Responses defines the responses by status code,
+	// the error response is documented as the default response unless set.
+	Responses map[int]*ResponseDoc
+
+	// query defines the schema of the query parameters object
+	query *jsonschema.Schema
+	// queryTypes defines the types of the query parameters values
+	queryTypes map[string]string
+}
+
+// WithDoc sets the endpoint documentation.
+// It panics if the schemas of the query parameters are invalid.
+func (h *HandlerEndpoint) WithDoc(doc *EndpointDoc) *HandlerEndpoint {
+	if err := doc.compile(); err != nil {
+		panic(err)
+	}
+	h.Doc = doc
+	return h
+}
+
+// openAPIPath converts the route to the OpenAPI path, and lists its path parameters.
+func openAPIPath(route string) (string, []openapi.Parameter, error) {
+	segments, err := parseRoute(route)
+	if err != nil {
+		return "", nil, err
+	}
+	if len(segments) == 0 {
+		return "/", nil, nil
+	}
+	var params []openapi.Parameter
+	el := make([]string, len(segments))
+	for i, s := range segments {
+		if s.kind == segmentLiteral {
+			el[i] = s.value
+			continue
+		}
+		el[i] = "{" + s.value + "}"
+		schema := `{"type": "string"}`
+		switch {
+		case s.kind == segmentCatchAll:
+			schema = `{"type": "string", "description": "The rest of the path."}`
+		case s.paramType == ParamInt:
+			schema = `{"type": "integer"}`
+		case s.paramType == ParamUUID:
+			schema = `{"type": "string", "format": "uuid"}`
+		}
+		params = append(params, openapi.Parameter{
+			Name:     s.value,
+			In:       openapi.InPath,
+			Required: true,
+			Schema:   json.RawMessage(schema),
+		})
+	}
+	return "/" + strings.Join(el, "/"), params, nil
+}
+
+// content defines the payload content of the schema.
+// The content is not set if neither the schema, nor the content types are set, e.g. for the empty payload.
+func content(schema *Schema, contentTypes []string, schemas map[string]json.RawMessage) map[string]openapi.MediaType {
+	if schema == nil && len(contentTypes) == 0 {
+		return nil
+	}
+	if len(contentTypes) == 0 {
+		contentTypes = []string{defaultContentType}
+	}
+	o := map[string]openapi.MediaType{}
+	for _, t := range contentTypes {
+		m := openapi.MediaType{}
+		if schema != nil && t == defaultContentType {
+			schemas[schema.Name] = schema.JSON
+			m.Schema = openapi.Ref(schema.Name)
+		}
+		o[t] = m
+	}
+	return o
+}
+
+// operation converts the endpoint documentation to the OpenAPI operation.
+func (d *EndpointDoc) operation(id string, params []openapi.Parameter, schemas map[string]json.RawMessage) *openapi.Operation {
+	o := &openapi.Operation{
+		OperationID: id,
+		Summary:     d.Summary,
+		Description: d.Description,
+		Tags:        d.Tags,
+		Parameters:  append(append([]openapi.Parameter{}, params...), d.Parameters...),
+		Responses:   map[string]*openapi.Response{},
+	}
+	if d.Request != nil {
+		o.RequestBody = &openapi.RequestBody{
+			Description: d.RequestDescription,
+			Required:    !d.RequestOptional,
+			Content:     content(d.Request, nil, schemas),
+		}
+	}
+	for code, r := range d.Responses {
+		o.Responses[strconv.Itoa(code)] = &openapi.Response{
+			Description: r.Description,
+			Content:     content(r.Schema, r.ContentTypes, schemas),
+		}
+	}
+	if _, ok := o.Responses["default"]; !ok {
+		o.Responses["default"] = &openapi.Response{
+			Description: "Error",
+			Content:     content(ErrorSchema, nil, schemas),
+		}
+	}
+	return o
+}
+
+// OpenAPI renders the OpenAPI document of the endpoints.
+// The endpoints without documentation are listed with the error response only.
+func (h *Handlers) OpenAPI(info openapi.Info) (*openapi.Document, error) {
+	doc := &openapi.Document{
+		OpenAPI:    openapi.Version,
+		Info:       info,
+		Paths:      map[string]openapi.PathItem{},
+		Components: openapi.Components{Schemas: map[string]json.RawMessage{}},
+	}
+	for pattern, endpoint := range h.Endpoints {
+		method, route := splitPattern(pattern)
+		methods := []string{method}
+		if method == "" {
+			methods = append([]string{}, *endpoint.AllowedMethods...)
+		}
+		sort.Strings(methods)
+
+		p, params, err := openAPIPath(route)
+		if err != nil {
+			return nil, err
+		}
+		item, ok := doc.Paths[p]
+		if !ok {
+			item = openapi.PathItem{}
+			doc.Paths[p] = item
+		}
+		d := endpoint.Doc
+		if d == nil {
+			d = &EndpointDoc{}
+		}
+		for _, m := range methods {
+			id := d.ID
+			if id != "" && len(methods) > 1 {
+				id += strings.ToUpper(m[:1]) + strings.ToLower(m[1:])
+			}
+			item[strings.ToLower(m)] = d.operation(id, params, doc.Components.Schemas)
+		}
+	}
+	return doc, nil
+}
+
+// ActionOpenAPI defines the function to serve the OpenAPI document of the endpoints.
+func ActionOpenAPI(h *Handlers, info openapi.Info) Action {
+	return func(r *Request) (*Response, error) {
+		doc, err := h.OpenAPI(info)
+		if err != nil {
+			return nil, err
+		}
+		b, err := doc.Serialize()
+		if err != nil {
+			return nil, err
+		}
+		return NewResponse(b, http.StatusOK), nil
+	}
+}
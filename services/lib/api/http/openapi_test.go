@@ -0,0 +1,106 @@
+/* Copyright 2021 Dmitry Kisler dkisler.com
+
+Licensed under the Apache License,Version 2.0 (the "License");
+you may not use this file except in compliance with the License. You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED,
+INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE,
+AND NONINFRINGEMENT. IN NO EVENT WILL THE LICENSOR OR OTHER CONTRIBUTORS BE LIABLE FOR ANY CLAIM, DAMAGES,
+OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF,
+OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+See the License for the specific language governing permissions and limitations under the License.
+*/
+
+package http_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"platform/lib/api/http"
+	"platform/lib/api/openapi"
+	"reflect"
+	"testing"
+
+	"github.com/valyala/fasthttp"
+)
+
+func compact(b []byte) string {
+	var o bytes.Buffer
+	_ = json.Compact(&o, b)
+	return o.String()
+}
+
+func TestOpenAPI(t *testing.T) {
+	item := http.NewSchema("item", []byte(`{"type": "object"}`))
+	handlers := http.NewRequestHandlers(map[string]*http.HandlerEndpoint{
+		"/items/{:id:int}": http.NewHandlerEndpoint(http.ActionHealthcheck, []string{"GET", "PUT"}).WithDoc(&http.EndpointDoc{
+			ID:          "item",
+			Description: "Item.",
+			Request:     item,
+			Parameters: []openapi.Parameter{
+				{Name: "q", In: openapi.InQuery, Schema: json.RawMessage(`{"type":"string"}`)},
+			},
+			Responses: map[int]*http.ResponseDoc{
+				fasthttp.StatusOK: {Description: "Success", Schema: item, ContentTypes: []string{"application/json", "text/csv"}},
+			},
+		}),
+		"DELETE /items/{:id:int}": http.NewHandlerEndpoint(http.ActionHealthcheck, nil),
+	}).WithoutMetrics().WithOpenAPI(openapi.Info{Title: "test", Version: "v1"})
+
+	ctx := &fasthttp.RequestCtx{}
+	ctx.Request.SetRequestURI("/openapi.json")
+	handlers.Router()(ctx)
+	if ctx.Response.StatusCode() != fasthttp.StatusOK {
+		t.Fatalf("error!\nwant: %v\ngot: %v", fasthttp.StatusOK, ctx.Response.StatusCode())
+	}
+	doc, err := openapi.Parse(ctx.Response.Body())
+	if err != nil {
+		t.Fatalf("error!\nwant: OpenAPI document\ngot: %v", err)
+	}
+
+	if doc.OpenAPI != openapi.Version || doc.Info.Title != "test" {
+		t.Fatalf("error!\nwant: %v %v\ngot: %v %v", openapi.Version, "test", doc.OpenAPI, doc.Info.Title)
+	}
+	wantPaths := []string{"/healthcheck", "/items/{id}", "/openapi.json"}
+	gotPaths := []string{}
+	for _, p := range wantPaths {
+		if _, ok := doc.Paths[p]; ok {
+			gotPaths = append(gotPaths, p)
+		}
+	}
+	if len(doc.Paths) != len(wantPaths) || !reflect.DeepEqual(gotPaths, wantPaths) {
+		t.Fatalf("error!\nwant: %v\ngot: %v", wantPaths, doc.Paths)
+	}
+
+	items := doc.Paths["/items/{id}"]
+	for method, id := range map[string]string{"get": "itemGet", "put": "itemPut", "delete": ""} {
+		op, ok := items[method]
+		if !ok || op.OperationID != id {
+			t.Fatalf("error!\nwant: %s operation %q\ngot: %v", method, id, op)
+		}
+		if op.Parameters[0].Name != "id" || op.Parameters[0].In != openapi.InPath || compact(op.Parameters[0].Schema) != `{"type":"integer"}` {
+			t.Fatalf("error!\nwant: integer path parameter id\ngot: %v", op.Parameters[0])
+		}
+		if compact(op.Responses["default"].Content["application/json"].Schema) != `{"$ref":"#/components/schemas/error"}` {
+			t.Fatalf("error!\nwant: default error response\ngot: %v", op.Responses["default"])
+		}
+	}
+
+	get := items["get"]
+	if len(get.Parameters) != 2 || get.Parameters[1].Name != "q" {
+		t.Fatalf("error!\nwant: path and query parameters\ngot: %v", get.Parameters)
+	}
+	if compact(get.RequestBody.Content["application/json"].Schema) != `{"$ref":"#/components/schemas/item"}` {
+		t.Fatalf("error!\nwant: item request body\ngot: %v", get.RequestBody)
+	}
+	if _, ok := get.Responses["200"].Content["text/csv"]; !ok {
+		t.Fatalf("error!\nwant: text/csv response\ngot: %v", get.Responses["200"])
+	}
+	for _, name := range []string{"item", "error"} {
+		if _, ok := doc.Components.Schemas[name]; !ok {
+			t.Fatalf("error!\nwant: schema %q\ngot: %v", name, doc.Components.Schemas)
+		}
+	}
+}
@@ -0,0 +1,310 @@
+/* Copyright 2021 Dmitry Kisler dkisler.com
+
+Licensed under the Apache License,Version 2.0 (the "License");
+you may not use this file except in compliance with the License. You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED,
+INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE,
+AND NONINFRINGEMENT. IN NO EVENT WILL THE LICENSOR OR OTHER CONTRIBUTORS BE LIABLE FOR ANY CLAIM, DAMAGES,
+OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF,
+OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+See the License for the specific language governing permissions and limitations under the License.
+*/
+
+package http
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// The route is the slash separated list of segments, each segment is either:
+//  - the literal, e.g. /deadletter;
+//  - the path parameter, e.g. /deadletter/{:submission_id};
+//  - the typed path parameter, e.g. /items/{:id:int}, the supported types are string, int and uuid;
+//  - the catch-all parameter matching the rest of the path, e.g. /files/{*path}, it shall be the last segment.
+// The literal segments take precedence over the parameters, and the parameters over the catch-all,
+// hence the match does not depend on the order of registration.
+// The trailing slash is ignored, and the path segments are URL-decoded before matching,
+// i.e. the encoded slash %2F is a part of the parameter value.
+
+// ParamType defines the type of the path parameter value.
+type ParamType string
+
+const (
+	ParamString ParamType = "string"
+	ParamInt    ParamType = "int"
+	ParamUUID   ParamType = "uuid"
+)
+
+var reUUID = regexp.MustCompile("^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$")
+
+// match checks if the path parameter value is of the type.
+func (t ParamType) match(v string) bool {
+	switch t {
+	case ParamInt:
+		_, err := strconv.ParseInt(v, 10, 64)
+		return err == nil
+	case ParamUUID:
+		return reUUID.MatchString(v)
+	default:
+		return true
+	}
+}
+
+type segmentKind int
+
+const (
+	segmentLiteral segmentKind = iota
+	segmentParam
+	segmentCatchAll
+)
+
+// segment defines the parsed route segment.
+type segment struct {
+	kind segmentKind
+	// value defines the literal, or the parameter name
+	value     string
+	paramType ParamType
+}
+
+var (
+	reParam    = regexp.MustCompile(`^\{:([A-Za-z0-9_]+)(?::([a-z]+))?\}$`)
+	reCatchAll = regexp.MustCompile(`^\{\*([A-Za-z0-9_]+)\}$`)
+)
+
+// splitPath splits the path to segments ignoring the leading and the trailing slash.
+func splitPath(p string) []string {
+	p = strings.TrimSuffix(strings.TrimPrefix(p, "/"), "/")
+	if p == "" {
+		return nil
+	}
+	return strings.Split(p, "/")
+}
+
+// parseRoute parses the route to segments.
+func parseRoute(route string) ([]segment, error) {
+	if !strings.HasPrefix(route, "/") {
+		return nil, fmt.Errorf("route %q shall start with a slash", route)
+	}
+	el := splitPath(route)
+	o := make([]segment, len(el))
+	names := map[string]bool{}
+	for i, e := range el {
+		if m := reCatchAll.FindStringSubmatch(e); m != nil {
+			if i != len(el)-1 {
+				return nil, fmt.Errorf("route %q: catch-all parameter %q shall be the last segment", route, m[1])
+			}
+			o[i] = segment{kind: segmentCatchAll, value: m[1]}
+		} else if m := reParam.FindStringSubmatch(e); m != nil {
+			t := ParamType(m[2])
+			switch t {
+			case "":
+				t = ParamString
+			case ParamString, ParamInt, ParamUUID:
+			default:
+				return nil, fmt.Errorf("route %q: unsupported type %q of parameter %q", route, m[2], m[1])
+			}
+			o[i] = segment{kind: segmentParam, value: m[1], paramType: t}
+		} else if strings.ContainsAny(e, "{}") {
+			return nil, fmt.Errorf("route %q: malformed segment %q", route, e)
+		} else {
+			o[i] = segment{kind: segmentLiteral, value: e}
+			continue
+		}
+		if names[o[i].value] {
+			return nil, fmt.Errorf("route %q: duplicate parameter %q", route, o[i].value)
+		}
+		names[o[i].value] = true
+	}
+	return o, nil
+}
+
+// node defines the node of the routing trie.
+type node struct {
+	literals map[string]*node
+	// param defines the child matching any segment of the parameter type
+	param    *node
+	catchAll *node
+	// segment defines the parameter of the param and the catchAll nodes
+	segment segment
+	// route defines the route of the endpoints, it's used as the metrics and the trace label
+	route     string
+	endpoints map[string]*HandlerEndpoint
+}
+
+func newNode() *node {
+	return &node{literals: map[string]*node{}}
+}
+
+// add registers the endpoint to serve the route requests with the methods.
+func (n *node) add(route string, methods []string, endpoint *HandlerEndpoint) error {
+	segments, err := parseRoute(route)
+	if err != nil {
+		return err
+	}
+	for _, s := range segments {
+		n, err = n.child(route, s)
+		if err != nil {
+			return err
+		}
+	}
+	if n.endpoints == nil {
+		n.route = route
+		n.endpoints = map[string]*HandlerEndpoint{}
+	}
+	for _, method := range methods {
+		if _, ok := n.endpoints[method]; ok {
+			return fmt.Errorf("route %q conflicts with %q for method %s", route, n.route, method)
+		}
+		n.endpoints[method] = endpoint
+	}
+	return nil
+}
+
+// child returns the child node for the segment, it's created if missing.
+// The parameters at the same position shall have the same name and type.
+func (n *node) child(route string, s segment) (*node, error) {
+	switch s.kind {
+	case segmentLiteral:
+		c, ok := n.literals[s.value]
+		if !ok {
+			c = newNode()
+			n.literals[s.value] = c
+		}
+		return c, nil
+	case segmentParam:
+		if n.param == nil {
+			n.param = newNode()
+			n.param.segment = s
+		}
+		if n.param.segment != s {
+			return nil, fmt.Errorf("route %q: parameter {:%s:%s} conflicts with {:%s:%s}",
+				route, s.value, s.paramType, n.param.segment.value, n.param.segment.paramType)
+		}
+		return n.param, nil
+	default:
+		if n.catchAll == nil {
+			n.catchAll = newNode()
+			n.catchAll.segment = s
+		}
+		if n.catchAll.segment != s {
+			return nil, fmt.Errorf("route %q: catch-all parameter {*%s} conflicts with {*%s}",
+				route, s.value, n.catchAll.segment.value)
+		}
+		return n.catchAll, nil
+	}
+}
+
+// lookup finds the node with the endpoints matching the URL-decoded path segments.
+// The values of the path parameters are set to params.
+func (n *node) lookup(el []string, params map[string]string) *node {
+	if len(el) == 0 {
+		if n.endpoints != nil {
+			return n
+		}
+		// the catch-all parameter matches the non-empty rest of the path only
+		return nil
+	}
+	if c, ok := n.literals[el[0]]; ok {
+		if o := c.lookup(el[1:], params); o != nil {
+			return o
+		}
+	}
+	if c := n.param; c != nil && el[0] != "" && c.segment.paramType.match(el[0]) {
+		if o := c.lookup(el[1:], params); o != nil {
+			params[c.segment.value] = el[0]
+			return o
+		}
+	}
+	if c := n.catchAll; c != nil && c.endpoints != nil {
+		params[c.segment.value] = strings.Join(el, "/")
+		return c
+	}
+	return nil
+}
+
+// allow returns the sorted list of the methods allowed for the node's route.
+func (n *node) allow() string {
+	o := make([]string, 0, len(n.endpoints))
+	for method := range n.endpoints {
+		o = append(o, method)
+	}
+	sort.Strings(o)
+	return strings.Join(o, ", ")
+}
+
+// splitPattern splits the endpoints map key to the optional method and the route, e.g. "GET /deadletter".
+func splitPattern(pattern string) (method, route string) {
+	if i := strings.IndexByte(pattern, ' '); i > 0 {
+		return pattern[:i], strings.TrimSpace(pattern[i+1:])
+	}
+	return "", pattern
+}
+
+// newRoutingTree builds the routing trie of the endpoints.
+// An error is returned if the routes are malformed, or if they conflict,
+// i.e. the same method is served by more than one endpoint for the same path.
+func newRoutingTree(endpoints map[string]*HandlerEndpoint) (*node, error) {
+	patterns := make([]string, 0, len(endpoints))
+	for pattern := range endpoints {
+		patterns = append(patterns, pattern)
+	}
+	// sorted to make the reported conflict deterministic
+	sort.Strings(patterns)
+
+	root := newNode()
+	for _, pattern := range patterns {
+		endpoint := endpoints[pattern]
+		method, route := splitPattern(pattern)
+		methods := []string{method}
+		if method == "" {
+			methods = *endpoint.AllowedMethods
+		}
+		if err := root.add(route, methods, endpoint); err != nil {
+			return nil, err
+		}
+	}
+	return root, nil
+}
+
+// routeMatch defines the result of the path lookup.
+type routeMatch struct {
+	route    string
+	endpoint *HandlerEndpoint
+	params   map[string]string
+	// allow defines the methods allowed for the route, it's set if the method is not allowed
+	allow string
+}
+
+// errMalformedPath is returned if the path segments fail to be URL-decoded.
+var errMalformedPath = NewError(KindValidation, "malformed path")
+
+// match finds the endpoint to serve the request to the raw, i.e. not decoded path.
+// It returns nil if the path is not matched.
+func (n *node) match(method, rawPath string) (*routeMatch, error) {
+	el := splitPath(rawPath)
+	for i, e := range el {
+		v, err := url.PathUnescape(e)
+		if err != nil {
+			return nil, errMalformedPath
+		}
+		el[i] = v
+	}
+	params := map[string]string{}
+	o := n.lookup(el, params)
+	if o == nil {
+		return nil, nil
+	}
+	m := &routeMatch{route: o.route, params: params}
+	if m.endpoint = o.endpoints[method]; m.endpoint == nil {
+		m.allow = o.allow()
+	}
+	return m, nil
+}
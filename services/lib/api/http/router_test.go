@@ -0,0 +1,173 @@
+/* Copyright 2021 Dmitry Kisler dkisler.com
+
+Licensed under the Apache License,Version 2.0 (the "License");
+you may not use this file except in compliance with the License. You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED,
+INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE,
+AND NONINFRINGEMENT. IN NO EVENT WILL THE LICENSOR OR OTHER CONTRIBUTORS BE LIABLE FOR ANY CLAIM, DAMAGES,
+OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF,
+OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+See the License for the specific language governing permissions and limitations under the License.
+*/
+
+package http_test
+
+import (
+	"platform/lib/api/http"
+	"reflect"
+	"testing"
+
+	"github.com/valyala/fasthttp"
+)
+
+func TestRouter(t *testing.T) {
+	action := func(name string) http.Action {
+		return func(r *http.Request) (*http.Response, error) {
+			resp := http.NewResponse([]byte(name), fasthttp.StatusOK)
+			resp.AddHeaders(r.RouteParameters)
+			return resp, nil
+		}
+	}
+	handlers := http.NewRequestHandlers(map[string]*http.HandlerEndpoint{
+		"/":                          http.NewHandlerEndpoint(action("root"), []string{"POST"}),
+		"/deadletter":                http.NewHandlerEndpoint(action("list"), []string{"GET"}),
+		"/deadletter/replay":         http.NewHandlerEndpoint(action("replay-all"), []string{"POST"}),
+		"/deadletter/{:id}":          http.NewHandlerEndpoint(action("inspect"), []string{"GET"}),
+		"/deadletter/{:id}/replay":   http.NewHandlerEndpoint(action("replay"), []string{"POST"}),
+		"/items/{:n:int}":            http.NewHandlerEndpoint(action("item"), []string{"GET"}),
+		"/users/{:id:uuid}":          http.NewHandlerEndpoint(action("user"), []string{"GET"}),
+		"/files/{*path}":             http.NewHandlerEndpoint(action("file"), []string{"GET"}),
+		"GET /resources/{:name}":     http.NewHandlerEndpoint(action("get"), nil),
+		"DELETE /resources/{:name}":  http.NewHandlerEndpoint(action("delete"), nil),
+		"/resources/{:name}/{*rest}": http.NewHandlerEndpoint(action("nested"), []string{"GET"}),
+	})
+	router := handlers.Router()
+
+	tests := []struct {
+		method, uri string
+		status      int
+		body        string
+		params      map[string]string
+		allow       string
+	}{
+		{method: "POST", uri: "/", status: 200, body: "root"},
+		{method: "GET", uri: "/deadletter/", status: 200, body: "list"},
+		{method: "POST", uri: "/deadletter/replay", status: 200, body: "replay-all"},
+		{method: "GET", uri: "/deadletter/replay", status: 405, allow: "POST"},
+		{method: "GET", uri: "/deadletter/foo", status: 200, body: "inspect", params: map[string]string{"id": "foo"}},
+		{method: "POST", uri: "/deadletter/foo/replay/", status: 200, body: "replay", params: map[string]string{"id": "foo"}},
+		{method: "GET", uri: "/deadletter/a%2Fb%20c", status: 200, body: "inspect", params: map[string]string{"id": "a/b c"}},
+		{method: "GET", uri: "/deadletter/%zz", status: 400},
+		{method: "GET", uri: "/items/42", status: 200, body: "item", params: map[string]string{"n": "42"}},
+		{method: "GET", uri: "/items/abc", status: 404},
+		{method: "GET", uri: "/users/0b7f0a40-6c4e-4b8a-9c4b-1c2d3e4f5a6b", status: 200, body: "user"},
+		{method: "GET", uri: "/users/42", status: 404},
+		{method: "GET", uri: "/files/a/b/c.json", status: 200, body: "file", params: map[string]string{"path": "a/b/c.json"}},
+		{method: "GET", uri: "/files", status: 404},
+		{method: "GET", uri: "/resources/foo", status: 200, body: "get", params: map[string]string{"name": "foo"}},
+		{method: "DELETE", uri: "/resources/foo", status: 200, body: "delete", params: map[string]string{"name": "foo"}},
+		{method: "PUT", uri: "/resources/foo", status: 405, allow: "DELETE, GET"},
+		{method: "GET", uri: "/resources/foo/bar/baz", status: 200, body: "nested",
+			params: map[string]string{"name": "foo", "rest": "bar/baz"}},
+		{method: "GET", uri: "/missing", status: 404},
+	}
+	for _, test := range tests {
+		ctx := &fasthttp.RequestCtx{}
+		ctx.Request.Header.SetMethod(test.method)
+		ctx.Request.SetRequestURI(test.uri)
+		router(ctx)
+
+		if got := ctx.Response.StatusCode(); got != test.status {
+			t.Fatalf("error!\n%s %s\nwant: %v\ngot: %v", test.method, test.uri, test.status, got)
+		}
+		if got := string(ctx.Response.Header.Peek("Allow")); got != test.allow {
+			t.Fatalf("error!\n%s %s\nwant: %v\ngot: %v", test.method, test.uri, test.allow, got)
+		}
+		if test.status != 200 {
+			continue
+		}
+		if got := string(ctx.Response.Body()); got != test.body {
+			t.Fatalf("error!\n%s %s\nwant: %v\ngot: %v", test.method, test.uri, test.body, got)
+		}
+		for k, want := range test.params {
+			if got := string(ctx.Response.Header.Peek(k)); got != want {
+				t.Fatalf("error!\n%s %s\nwant: %v\ngot: %v", test.method, test.uri, want, got)
+			}
+		}
+	}
+}
+
+func TestRoutesConflict(t *testing.T) {
+	endpoint := func(methods ...string) *http.HandlerEndpoint {
+		return http.NewHandlerEndpoint(http.ActionHealthcheck, methods)
+	}
+	tests := []struct {
+		endpoints map[string]*http.HandlerEndpoint
+		wantErr   bool
+	}{
+		{
+			endpoints: map[string]*http.HandlerEndpoint{
+				"/foo/{:id}":  endpoint("GET"),
+				"/foo/bar":    endpoint("GET"),
+				"/foo/{*all}": endpoint("GET"),
+			},
+			wantErr: false,
+		},
+		{
+			endpoints: map[string]*http.HandlerEndpoint{
+				"/foo/{:id}":   endpoint("GET"),
+				"/foo/{:name}": endpoint("POST"),
+			},
+			wantErr: true,
+		},
+		{
+			endpoints: map[string]*http.HandlerEndpoint{
+				"/foo/{:id}":     endpoint("GET"),
+				"/foo/{:id:int}": endpoint("POST"),
+			},
+			wantErr: true,
+		},
+		{
+			endpoints: map[string]*http.HandlerEndpoint{
+				"/foo":      endpoint("GET", "POST"),
+				"POST /foo": endpoint(),
+			},
+			wantErr: true,
+		},
+		{
+			endpoints: map[string]*http.HandlerEndpoint{
+				"/foo":  endpoint("GET"),
+				"/foo/": endpoint("GET"),
+			},
+			wantErr: true,
+		},
+		{
+			endpoints: map[string]*http.HandlerEndpoint{"/foo/{*all}/bar": endpoint("GET")},
+			wantErr:   true,
+		},
+		{
+			endpoints: map[string]*http.HandlerEndpoint{"/foo/{:id:float}": endpoint("GET")},
+			wantErr:   true,
+		},
+		{
+			endpoints: map[string]*http.HandlerEndpoint{"/foo/{:id}/{:id}": endpoint("GET")},
+			wantErr:   true,
+		},
+	}
+	for _, test := range tests {
+		err := http.NewRequestHandlers(test.endpoints).Validate()
+		if (err != nil) != test.wantErr {
+			t.Fatalf("error!\n%v\nwant: error %v\ngot: %v", reflect.ValueOf(test.endpoints).MapKeys(), test.wantErr, err)
+		}
+	}
+
+	defer func() {
+		if r := recover(); r == nil {
+			t.Fatal("error!\nwant: panic on conflicting routes\ngot: no panic")
+		}
+	}()
+	http.NewRequestHandlers(tests[1].endpoints).Router()
+}
@@ -0,0 +1,161 @@
+/* Copyright 2021 Dmitry Kisler dkisler.com
+
+Licensed under the Apache License,Version 2.0 (the "License");
+you may not use this file except in compliance with the License. You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED,
+INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE,
+AND NONINFRINGEMENT. IN NO EVENT WILL THE LICENSOR OR OTHER CONTRIBUTORS BE LIABLE FOR ANY CLAIM, DAMAGES,
+OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF,
+OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+See the License for the specific language governing permissions and limitations under the License.
+*/
+
+package http
+
+import (
+	"bytes"
+	"fmt"
+	"platform/lib/api/openapi"
+	"platform/lib/jsonschema"
+	"strconv"
+
+	"github.com/goccy/go-json"
+)
+
+// querySchema defines the schema of the query parameters object.
+type querySchema struct {
+	Type       string          `json:"type"`
+	Properties json.RawMessage `json:"properties"`
+	Required   []string        `json:"required"`
+}
+
+// compile compiles the schema of the query parameters object from the documented query parameters.
+func (d *EndpointDoc) compile() error {
+	if d.query != nil {
+		return nil
+	}
+	// the properties object is written in the order of the parameters
+	properties := &bytes.Buffer{}
+	required := []string{}
+	types := map[string]string{}
+	for _, p := range d.Parameters {
+		if p.In != openapi.InQuery {
+			continue
+		}
+		schema := p.Schema
+		if len(schema) == 0 {
+			schema = json.RawMessage(`{}`)
+		}
+		var t struct {
+			Type string `json:"type"`
+		}
+		if err := json.Unmarshal(schema, &t); err != nil {
+			return fmt.Errorf("query parameter %q: %w", p.Name, err)
+		}
+		name, err := json.Marshal(p.Name)
+		if err != nil {
+			return err
+		}
+		if properties.Len() > 0 {
+			properties.WriteByte(',')
+		}
+		properties.Write(name)
+		properties.WriteByte(':')
+		properties.Write(schema)
+		types[p.Name] = t.Type
+		if p.Required {
+			required = append(required, p.Name)
+		}
+	}
+	if len(types) == 0 {
+		return nil
+	}
+	b, err := json.Marshal(querySchema{
+		Type:       "object",
+		Properties: json.RawMessage("{" + properties.String() + "}"),
+		Required:   required,
+	})
+	if err != nil {
+		return err
+	}
+	if d.query, err = jsonschema.NewSchema(b); err != nil {
+		return fmt.Errorf("query parameters: %w", err)
+	}
+	d.queryTypes = types
+	return nil
+}
+
+// queryObject converts the documented query parameters to the values of their types.
+// The values failed to be converted are kept as strings to be reported by the validation.
+func (d *EndpointDoc) queryObject(query map[string]string) map[string]interface{} {
+	o := map[string]interface{}{}
+	for k, t := range d.queryTypes {
+		v, ok := query[k]
+		if !ok {
+			continue
+		}
+		o[k] = v
+		switch t {
+		case "number":
+			if f, err := strconv.ParseFloat(v, 64); err == nil {
+				o[k] = f
+			}
+		case "integer":
+			if i, err := strconv.ParseInt(v, 10, 64); err == nil {
+				o[k] = i
+			}
+		case "boolean":
+			if b, err := strconv.ParseBool(v); err == nil {
+				o[k] = b
+			}
+		}
+	}
+	return o
+}
+
+// validationError defines the validation error with the list of the invalid elements as details.
+func validationError(message string, err error) *Error {
+	return &Error{Kind: KindValidation, Message: message, Err: err, Details: jsonschema.FieldErrors(err)}
+}
+
+// validateRequest validates the query parameters and the payload of the request.
+func (d *EndpointDoc) validateRequest(r *Request) error {
+	if d.query != nil {
+		if err := d.query.ValidateObject(d.queryObject(r.Query)); err != nil {
+			return validationError("invalid query parameters", err)
+		}
+	}
+	if d.Request == nil || (d.RequestOptional && len(r.Body) == 0) {
+		return nil
+	}
+	schema := d.Request
+	var err error
+	if d.SelectRequest != nil {
+		schema, err = d.SelectRequest(r)
+	}
+	if err == nil {
+		err = schema.Validator.ValidateBytes(r.Body)
+	}
+	if err != nil {
+		if !d.AcceptInvalidRequest {
+			return validationError("invalid request payload", err)
+		}
+		r.ValidationError = err
+	}
+	return nil
+}
+
+// validateResponse validates the JSON payload of the response against the schema documented for its status code.
+func (d *EndpointDoc) validateResponse(resp *Response) error {
+	doc, ok := d.Responses[resp.StatusCode]
+	if !ok || doc.Schema == nil || resp.BodyStream != nil || resp.ContentType != defaultContentType {
+		return nil
+	}
+	if err := doc.Schema.Validator.ValidateBytes(resp.Body); err != nil {
+		return fmt.Errorf("response %d violates the schema %q: %w", resp.StatusCode, doc.Schema.Name, err)
+	}
+	return nil
+}
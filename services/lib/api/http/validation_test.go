@@ -0,0 +1,121 @@
+/* Copyright 2021 Dmitry Kisler dkisler.com
+
+Licensed under the Apache License,Version 2.0 (the "License");
+you may not use this file except in compliance with the License. You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED,
+INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE,
+AND NONINFRINGEMENT. IN NO EVENT WILL THE LICENSOR OR OTHER CONTRIBUTORS BE LIABLE FOR ANY CLAIM, DAMAGES,
+OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF,
+OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+See the License for the specific language governing permissions and limitations under the License.
+*/
+
+package http_test
+
+import (
+	"encoding/json"
+	"errors"
+	"platform/lib/api/http"
+	"platform/lib/api/openapi"
+	"platform/lib/jsonschema"
+	"testing"
+
+	"github.com/valyala/fasthttp"
+)
+
+func TestValidation(t *testing.T) {
+	item := http.NewSchema("item", []byte(`{"type": "object", "required": ["n"], "properties": {"n": {"type": "number"}}}`))
+	doc := func() *http.EndpointDoc {
+		return &http.EndpointDoc{
+			Request: item,
+			Parameters: []openapi.Parameter{
+				{Name: "limit", In: openapi.InQuery, Schema: json.RawMessage(`{"type": "integer", "minimum": 0}`)},
+			},
+			Responses: map[int]*http.ResponseDoc{fasthttp.StatusOK: {Schema: item}},
+		}
+	}
+	echo := func(r *http.Request) (*http.Response, error) {
+		if r.ValidationError != nil {
+			return http.NewResponse([]byte(`{"n": 0}`), fasthttp.StatusAccepted), nil
+		}
+		return http.NewResponse(r.Body, fasthttp.StatusOK), nil
+	}
+	lenient := doc()
+	lenient.AcceptInvalidRequest = true
+	itemV2 := http.NewSchema("item_v2", []byte(`{"type": "object", "required": ["n", "m"]}`))
+	versioned := doc()
+	versioned.SelectRequest = func(r *http.Request) (*http.Schema, error) {
+		switch r.Headers["Version"] {
+		case "":
+			return item, nil
+		case "2":
+			return itemV2, nil
+		}
+		return nil, errors.New("unknown version")
+	}
+	handlers := http.NewRequestHandlers(map[string]*http.HandlerEndpoint{
+		"/items":     http.NewHandlerEndpoint(echo, []string{"POST"}).WithDoc(doc()),
+		"/lenient":   http.NewHandlerEndpoint(echo, []string{"POST"}).WithDoc(lenient),
+		"/versioned": http.NewHandlerEndpoint(echo, []string{"POST"}).WithDoc(versioned),
+	}).WithResponseValidation()
+	router := handlers.Router()
+
+	tests := []struct {
+		uri, body   string
+		version     string
+		status      int
+		wantDetails bool
+	}{
+		{uri: "/items?limit=10", body: `{"n": 1}`, status: fasthttp.StatusOK},
+		{uri: "/items", body: `{"n": "1"}`, status: fasthttp.StatusBadRequest, wantDetails: true},
+		{uri: "/items", body: `{`, status: fasthttp.StatusBadRequest, wantDetails: true},
+		{uri: "/items", body: ``, status: fasthttp.StatusBadRequest, wantDetails: true},
+		{uri: "/items?limit=abc", body: `{"n": 1}`, status: fasthttp.StatusBadRequest, wantDetails: true},
+		{uri: "/items?limit=-1", body: `{"n": 1}`, status: fasthttp.StatusBadRequest, wantDetails: true},
+		{uri: "/items", body: `{"n": 1, "m": 2}`, status: fasthttp.StatusOK},
+		{uri: "/items", body: `{"m": 2}`, status: fasthttp.StatusBadRequest, wantDetails: true},
+		{uri: "/lenient", body: `{"m": 2}`, status: fasthttp.StatusAccepted},
+		{uri: "/versioned", body: `{"n": 1}`, status: fasthttp.StatusOK},
+		{uri: "/versioned", body: `{"n": 1}`, version: "2", status: fasthttp.StatusBadRequest, wantDetails: true},
+		{uri: "/versioned", body: `{"n": 1, "m": 2}`, version: "2", status: fasthttp.StatusOK},
+		{uri: "/versioned", body: `{"n": 1}`, version: "3", status: fasthttp.StatusBadRequest, wantDetails: true},
+	}
+	for _, test := range tests {
+		ctx := &fasthttp.RequestCtx{}
+		ctx.Request.Header.SetMethod("POST")
+		ctx.Request.SetRequestURI(test.uri)
+		ctx.Request.SetBodyString(test.body)
+		if test.version != "" {
+			ctx.Request.Header.Set("Version", test.version)
+		}
+		router(ctx)
+
+		if got := ctx.Response.StatusCode(); got != test.status {
+			t.Fatalf("error!\n%s %s\nwant: %v\ngot: %v %s", test.uri, test.body, test.status, got, ctx.Response.Body())
+		}
+		var body struct {
+			Details []jsonschema.FieldError `json:"details"`
+		}
+		_ = json.Unmarshal(ctx.Response.Body(), &body)
+		if got := len(body.Details) > 0; got != test.wantDetails {
+			t.Fatalf("error!\n%s %s\nwant: details %v\ngot: %s", test.uri, test.body, test.wantDetails, ctx.Response.Body())
+		}
+	}
+
+	ctx := &fasthttp.RequestCtx{}
+	ctx.Request.Header.SetMethod("POST")
+	ctx.Request.SetRequestURI("/lenient")
+	ctx.Request.SetBodyString(`{"n": "1"}`)
+	handlers = http.NewRequestHandlers(map[string]*http.HandlerEndpoint{
+		"/lenient": http.NewHandlerEndpoint(func(r *http.Request) (*http.Response, error) {
+			return http.NewResponse([]byte(`{}`), fasthttp.StatusOK), nil
+		}, []string{"POST"}).WithDoc(lenient),
+	}).WithResponseValidation()
+	handlers.Router()(ctx)
+	if got := ctx.Response.StatusCode(); got != fasthttp.StatusInternalServerError {
+		t.Fatalf("error!\nwant: %v for the response violating the schema\ngot: %v", fasthttp.StatusInternalServerError, got)
+	}
+}
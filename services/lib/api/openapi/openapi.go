@@ -0,0 +1,127 @@
+/* Copyright 2021 Dmitry Kisler dkisler.com
+
+Licensed under the Apache License,Version 2.0 (the "License");
+you may not use this file except in compliance with the License. You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED,
+INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE,
+AND NONINFRINGEMENT. IN NO EVENT WILL THE LICENSOR OR OTHER CONTRIBUTORS BE LIABLE FOR ANY CLAIM, DAMAGES,
+OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF,
+OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+See the License for the specific language governing permissions and limitations under the License.
+
+Package defines the OpenAPI 3.1 document model.
+The services render the document of their endpoints, see platform/lib/api/http,
+and the API gateway specification is derived from it, see platform/lib/api/gateway.
+*/
+
+package openapi
+
+import (
+	"bytes"
+
+	"github.com/goccy/go-json"
+)
+
+// Version defines the version of the OpenAPI specification.
+const Version = "3.1.0"
+
+// Document defines the OpenAPI document.
+type Document struct {
+	OpenAPI    string              `json:"openapi"`
+	Info       Info                `json:"info"`
+	Paths      map[string]PathItem `json:"paths"`
+	Components Components          `json:"components"`
+}
+
+// Info defines the API metadata.
+type Info struct {
+	Title       string `json:"title"`
+	Description string `json:"description,omitempty"`
+	Version     string `json:"version"`
+}
+
+// PathItem defines the operations of the path by lower case method.
+type PathItem map[string]*Operation
+
+// Operation documents the endpoint's method.
+type Operation struct {
+	OperationID string               `json:"operationId,omitempty"`
+	Summary     string               `json:"summary,omitempty"`
+	Description string               `json:"description,omitempty"`
+	Tags        []string             `json:"tags,omitempty"`
+	Parameters  []Parameter          `json:"parameters,omitempty"`
+	RequestBody *RequestBody         `json:"requestBody,omitempty"`
+	Responses   map[string]*Response `json:"responses,omitempty"`
+}
+
+// Parameter documents the path, query or header parameter.
+type Parameter struct {
+	Name        string `json:"name"`
+	In          string `json:"in"`
+	Description string `json:"description,omitempty"`
+	Required    bool   `json:"required,omitempty"`
+	// Schema defines the JSON schema of the parameter value.
+	Schema json.RawMessage `json:"schema,omitempty"`
+}
+
+// Parameter locations.
+const (
+	InPath   = "path"
+	InQuery  = "query"
+	InHeader = "header"
+)
+
+// RequestBody documents the request payload.
+type RequestBody struct {
+	Description string               `json:"description,omitempty"`
+	Required    bool                 `json:"required,omitempty"`
+	Content     map[string]MediaType `json:"content"`
+}
+
+// Response documents the response payload.
+type Response struct {
+	Description string               `json:"description"`
+	Content     map[string]MediaType `json:"content,omitempty"`
+}
+
+// MediaType defines the payload schema of the content type.
+type MediaType struct {
+	Schema json.RawMessage `json:"schema,omitempty"`
+}
+
+// Components defines the JSON schemas referenced by the operations.
+type Components struct {
+	Schemas map[string]json.RawMessage `json:"schemas,omitempty"`
+}
+
+// Ref returns the reference to the component schema.
+func Ref(name string) json.RawMessage {
+	b, _ := json.Marshal(map[string]string{"$ref": "#/components/schemas/" + name})
+	return b
+}
+
+// Serialize serializes the document to the indented JSON.
+// The keys are sorted, hence the document is stable to be committed and compared.
+func (d *Document) Serialize() ([]byte, error) {
+	var o bytes.Buffer
+	enc := json.NewEncoder(&o)
+	// the HTML characters are not escaped to keep the descriptions readable, e.g. the filter expressions
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(d); err != nil {
+		return nil, err
+	}
+	return o.Bytes(), nil
+}
+
+// Parse deserializes the document.
+func Parse(data []byte) (*Document, error) {
+	var d Document
+	if err := json.Unmarshal(data, &d); err != nil {
+		return nil, err
+	}
+	return &d, nil
+}
@@ -0,0 +1,55 @@
+/* Copyright 2021 Dmitry Kisler dkisler.com
+
+Licensed under the Apache License,Version 2.0 (the "License");
+you may not use this file except in compliance with the License. You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED,
+INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE,
+AND NONINFRINGEMENT. IN NO EVENT WILL THE LICENSOR OR OTHER CONTRIBUTORS BE LIABLE FOR ANY CLAIM, DAMAGES,
+OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF,
+OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+See the License for the specific language governing permissions and limitations under the License.
+
+Command generates the API gateway specification from the services' OpenAPI documents.
+
+Usage:
+
+	go run ./cmd/gateway -config ../../infra/terraform/gateway.json -out ../../infra/terraform/openapi.json
+*/
+
+package main
+
+import (
+	"flag"
+	"os"
+	"platform/lib/api/gateway"
+	"platform/lib/logging"
+)
+
+func main() {
+	config := flag.String("config", "", "path to the gateway routes config")
+	out := flag.String("out", "", "path to write the gateway specification to, stdout if not set")
+	flag.Parse()
+	if *config == "" {
+		logging.Default().Fatal("specify the gateway routes config, e.g. as flag '-config gateway.json'")
+	}
+
+	cfg, docs, err := gateway.ReadConfig(*config)
+	if err != nil {
+		logging.Default().Fatal("failed to read the config", "error", err)
+	}
+	spec, err := gateway.Spec(cfg, docs)
+	if err != nil {
+		logging.Default().Fatal("failed to generate the specification", "error", err)
+	}
+	if *out == "" {
+		_, err = os.Stdout.Write(spec)
+	} else {
+		err = os.WriteFile(*out, spec, 0644)
+	}
+	if err != nil {
+		logging.Default().Fatal("failed to write the specification", "error", err)
+	}
+}
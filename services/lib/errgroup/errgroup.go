@@ -0,0 +1,147 @@
+/* Copyright 2021 Dmitry Kisler dkisler.com
+
+Licensed under the Apache License,Version 2.0 (the "License");
+you may not use this file except in compliance with the License. You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED,
+INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE,
+AND NONINFRINGEMENT. IN NO EVENT WILL THE LICENSOR OR OTHER CONTRIBUTORS BE LIABLE FOR ANY CLAIM, DAMAGES,
+OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF,
+OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+See the License for the specific language governing permissions and limitations under the License.
+
+Package defines the group of concurrent tasks collecting their errors.
+
+Unlike the package golang.org/x/sync/errgroup, the group collects the errors
+of all tasks, every error is labeled with the task name.
+The group is meant to be used per request, it must not be shared across requests.
+*/
+
+package errgroup
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// Error defines the error of the task.
+type Error struct {
+	// Task defines the task name.
+	Task string `json:"task"`
+	Err  error  `json:"-"`
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s: %v", e.Task, e.Err)
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Errors defines the errors of the group tasks.
+type Errors []*Error
+
+func (e Errors) Error() string {
+	return strings.Join(e.Strings(), "; ")
+}
+
+// Strings returns the errors messages.
+func (e Errors) Strings() []string {
+	o := make([]string, len(e))
+	for i, err := range e {
+		o[i] = err.Err.Error()
+	}
+	return o
+}
+
+// Err returns the errors as the error, or nil if there are none.
+func (e Errors) Err() error {
+	if len(e) == 0 {
+		return nil
+	}
+	return e
+}
+
+// Group defines the group of tasks.
+type Group struct {
+	cancel context.CancelFunc
+	sem    chan struct{}
+	wg     sync.WaitGroup
+
+	mu   sync.Mutex
+	n    int
+	errs map[int]*Error
+}
+
+// New initiates the group.
+func New() *Group {
+	return &Group{errs: map[int]*Error{}}
+}
+
+// WithContext initiates the group and the context derived from ctx.
+// The context is canceled when a task fails, or when Wait returns.
+func WithContext(ctx context.Context) (*Group, context.Context) {
+	ctx, cancel := context.WithCancel(ctx)
+	g := New()
+	g.cancel = cancel
+	return g, ctx
+}
+
+// WithLimit limits the number of tasks running concurrently.
+// It must be set before the tasks are started.
+func (g *Group) WithLimit(n int) *Group {
+	if n > 0 {
+		g.sem = make(chan struct{}, n)
+	}
+	return g
+}
+
+// Go runs the task in a new goroutine.
+// If the concurrency is limited, it blocks until the task can be started.
+func (g *Group) Go(task string, fn func() error) {
+	g.mu.Lock()
+	i := g.n
+	g.n++
+	g.mu.Unlock()
+
+	if g.sem != nil {
+		g.sem <- struct{}{}
+	}
+	g.wg.Add(1)
+	go func() {
+		defer g.wg.Done()
+		if g.sem != nil {
+			defer func() { <-g.sem }()
+		}
+		if err := fn(); err != nil {
+			g.mu.Lock()
+			g.errs[i] = &Error{Task: task, Err: err}
+			g.mu.Unlock()
+			if g.cancel != nil {
+				g.cancel()
+			}
+		}
+	}()
+}
+
+// Wait waits for all tasks to complete and returns their errors in the order the tasks were started.
+func (g *Group) Wait() Errors {
+	g.wg.Wait()
+	if g.cancel != nil {
+		g.cancel()
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	var o Errors
+	for i := 0; i < g.n; i++ {
+		if err, ok := g.errs[i]; ok {
+			o = append(o, err)
+		}
+	}
+	return o
+}
@@ -0,0 +1,96 @@
+/* Copyright 2021 Dmitry Kisler dkisler.com
+
+Licensed under the Apache License,Version 2.0 (the "License");
+you may not use this file except in compliance with the License. You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED,
+INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE,
+AND NONINFRINGEMENT. IN NO EVENT WILL THE LICENSOR OR OTHER CONTRIBUTORS BE LIABLE FOR ANY CLAIM, DAMAGES,
+OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF,
+OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+See the License for the specific language governing permissions and limitations under the License.
+*/
+
+package errgroup_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"platform/lib/errgroup"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestWait(t *testing.T) {
+	g := errgroup.New()
+	for i := 0; i < 100; i++ {
+		i := i
+		g.Go(fmt.Sprintf("task%d", i), func() error {
+			if i%25 == 0 {
+				return fmt.Errorf("failed %d", i)
+			}
+			return nil
+		})
+	}
+	errs := g.Wait()
+	want := []string{"failed 0", "failed 25", "failed 50", "failed 75"}
+	if !reflect.DeepEqual(errs.Strings(), want) {
+		t.Fatalf("error!\nwant: %v\ngot: %v", want, errs.Strings())
+	}
+	if errs[1].Task != "task25" {
+		t.Fatalf("error!\nwant: %v\ngot: %v", "task25", errs[1].Task)
+	}
+	if errs.Err() == nil {
+		t.Fatal("error!\nwant: error\ngot: nil")
+	}
+}
+
+func TestWaitNoErrors(t *testing.T) {
+	g := errgroup.New()
+	g.Go("foo", func() error { return nil })
+	if err := g.Wait().Err(); err != nil {
+		t.Fatalf("error!\nwant: %v\ngot: %v", nil, err)
+	}
+}
+
+func TestWithLimit(t *testing.T) {
+	var running, max int32
+	g := errgroup.New().WithLimit(3)
+	for i := 0; i < 20; i++ {
+		g.Go("task", func() error {
+			n := atomic.AddInt32(&running, 1)
+			for {
+				m := atomic.LoadInt32(&max)
+				if n <= m || atomic.CompareAndSwapInt32(&max, m, n) {
+					break
+				}
+			}
+			time.Sleep(time.Millisecond)
+			atomic.AddInt32(&running, -1)
+			return nil
+		})
+	}
+	g.Wait()
+	if max > 3 {
+		t.Fatalf("error!\nwant: at most 3 concurrent tasks\ngot: %d", max)
+	}
+}
+
+func TestWithContext(t *testing.T) {
+	errFoo := errors.New("foo")
+	g, ctx := errgroup.WithContext(context.Background())
+	g.Go("fail", func() error { return errFoo })
+	g.Go("wait", func() error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	errs := g.Wait()
+	if len(errs) != 2 || !errors.Is(errs[0], errFoo) || !errors.Is(errs[1], context.Canceled) {
+		t.Fatalf("error!\nwant: [%v %v]\ngot: %v", errFoo, context.Canceled, errs)
+	}
+}
@@ -18,19 +18,23 @@ go 1.16
 
 require (
 	cloud.google.com/go v0.86.0 // indirect
-	cloud.google.com/go/bigquery v1.19.0 // indirect
-	cloud.google.com/go/pubsub v1.12.1 // indirect
-	cloud.google.com/go/storage v1.16.0 // indirect
+	cloud.google.com/go/pubsub v1.12.1
+	cloud.google.com/go/storage v1.16.0
 	github.com/andybalholm/brotli v1.0.3 // indirect
-	github.com/goccy/go-json v0.7.4 // indirect
+	github.com/goccy/go-json v0.10.5
 	github.com/golang/groupcache v0.0.0-20210331224755-41bb18bfe9da // indirect
-	github.com/google/uuid v1.2.0 // indirect
+	github.com/google/uuid v1.2.0
 	github.com/klauspost/compress v1.13.1 // indirect
+	github.com/prometheus/client_golang v1.12.2
+	github.com/prometheus/common v0.32.1
 	github.com/valyala/fasthttp v1.28.0
 	github.com/xeipuuv/gojsonpointer v0.0.0-20190905194746-02993c407bfb // indirect
-	github.com/xeipuuv/gojsonschema v1.2.0 // indirect
+	github.com/xeipuuv/gojsonschema v1.2.0
+	go.opentelemetry.io/otel v1.7.0
+	go.opentelemetry.io/otel/sdk v1.7.0
+	go.opentelemetry.io/otel/trace v1.7.0
 	golang.org/x/net v0.0.0-20210614182718-04defd469f4e // indirect
-	golang.org/x/sys v0.0.0-20210630005230-0f9fa26af87c // indirect
+	google.golang.org/api v0.50.0
 	google.golang.org/genproto v0.0.0-20210707164411-8c882eb9abba // indirect
-	google.golang.org/grpc v1.39.0 // indirect
+	google.golang.org/grpc v1.39.0
 )
@@ -0,0 +1,173 @@
+/* Copyright 2021 Dmitry Kisler dkisler.com
+
+Licensed under the Apache License,Version 2.0 (the "License");
+you may not use this file except in compliance with the License. You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED,
+INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE,
+AND NONINFRINGEMENT. IN NO EVENT WILL THE LICENSOR OR OTHER CONTRIBUTORS BE LIABLE FOR ANY CLAIM, DAMAGES,
+OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF,
+OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+See the License for the specific language governing permissions and limitations under the License.
+
+Package defines the readiness checks of the service dependencies, e.g. the storage bucket or the message bus topic.
+*/
+
+package health
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/goccy/go-json"
+)
+
+// Check defines the function to check the dependency is reachable.
+type Check func(ctx context.Context) error
+
+const (
+	defaultTimeout  = 2 * time.Second
+	defaultCacheTTL = 10 * time.Second
+)
+
+// Config contains configuration for the checker.
+type Config struct {
+	timeout  time.Duration
+	cacheTTL time.Duration
+}
+
+// NewConfig return configuration for the checker.
+// The checks timeout is 2 sec, the results are cached for 10 sec.
+func NewConfig() *Config {
+	return &Config{timeout: defaultTimeout, cacheTTL: defaultCacheTTL}
+}
+
+// WithTimeout sets the deadline of every check.
+func (c *Config) WithTimeout(t time.Duration) *Config {
+	c.timeout = t
+	return c
+}
+
+// WithCacheTTL sets the duration to reuse the check result,
+// it prevents the dependencies from being overloaded by frequent probes.
+// The results are not cached if the duration is not positive.
+func (c *Config) WithCacheTTL(t time.Duration) *Config {
+	c.cacheTTL = t
+	return c
+}
+
+// statuses
+const (
+	StatusOK    = "ok"
+	StatusError = "error"
+)
+
+// Result defines the check result.
+type Result struct {
+	Name   string `json:"name"`
+	Status string `json:"status"`
+	// LatencyMs defines the check duration in milliseconds.
+	LatencyMs float64   `json:"latency_ms"`
+	Error     string    `json:"error,omitempty"`
+	CheckedAt time.Time `json:"checked_at"`
+}
+
+// check defines the registered check with the cached result.
+type check struct {
+	name string
+	fn   Check
+	// mu serializes the check runs, the concurrent callers get the result of the single run
+	mu   sync.Mutex
+	last *Result
+}
+
+func (c *check) run(ctx context.Context, cfg *Config) Result {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.last != nil && cfg.cacheTTL > 0 && time.Since(c.last.CheckedAt) < cfg.cacheTTL {
+		return *c.last
+	}
+
+	checkCtx, cancel := context.WithTimeout(ctx, cfg.timeout)
+	defer cancel()
+	start := time.Now()
+	err := c.fn(checkCtx)
+	o := &Result{
+		Name:      c.name,
+		Status:    StatusOK,
+		LatencyMs: float64(time.Since(start).Microseconds()) / 1000,
+		CheckedAt: start.UTC(),
+	}
+	if err != nil {
+		o.Status = StatusError
+		o.Error = err.Error()
+	}
+	// the result is not cached if the caller gave up, e.g. the probe request is canceled
+	if ctx.Err() == nil {
+		c.last = o
+	}
+	return *o
+}
+
+// Checker defines the set of the checks.
+type Checker struct {
+	cfg    *Config
+	checks []*check
+}
+
+// NewChecker initiates the checker with the default configuration.
+func NewChecker() *Checker {
+	return &Checker{cfg: NewConfig()}
+}
+
+// WithCfg configures the checker.
+func (c *Checker) WithCfg(cfg *Config) *Checker {
+	c.cfg = cfg
+	return c
+}
+
+// Register adds the check, the checks are reported in the order of registration.
+func (c *Checker) Register(name string, fn Check) *Checker {
+	c.checks = append(c.checks, &check{name: name, fn: fn})
+	return c
+}
+
+// Report defines the results of all checks.
+type Report struct {
+	Status string   `json:"status"`
+	Checks []Result `json:"checks"`
+}
+
+// Ready checks if all checks passed.
+func (r *Report) Ready() bool {
+	return r.Status == StatusOK
+}
+
+// MustSerialize serializes the report to JSON.
+func (r *Report) MustSerialize() []byte {
+	o, _ := json.Marshal(r)
+	return o
+}
+
+// Run runs the checks concurrently, the results are reused if they are not older than the cache TTL.
+func (c *Checker) Run(ctx context.Context) *Report {
+	o := &Report{Status: StatusOK, Checks: make([]Result, len(c.checks))}
+	var wg sync.WaitGroup
+	for i, ch := range c.checks {
+		wg.Add(1)
+		go func(i int, ch *check) {
+			defer wg.Done()
+			o.Checks[i] = ch.run(ctx, c.cfg)
+		}(i, ch)
+	}
+	wg.Wait()
+	for _, r := range o.Checks {
+		if r.Status != StatusOK {
+			o.Status = StatusError
+		}
+	}
+	return o
+}
@@ -0,0 +1,122 @@
+/* Copyright 2021 Dmitry Kisler dkisler.com
+
+Licensed under the Apache License,Version 2.0 (the "License");
+you may not use this file except in compliance with the License. You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED,
+INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE,
+AND NONINFRINGEMENT. IN NO EVENT WILL THE LICENSOR OR OTHER CONTRIBUTORS BE LIABLE FOR ANY CLAIM, DAMAGES,
+OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF,
+OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+See the License for the specific language governing permissions and limitations under the License.
+*/
+
+package health_test
+
+import (
+	"context"
+	"errors"
+	"platform/lib/health"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// counter counts the check calls.
+type counter struct {
+	calls int32
+	err   error
+}
+
+func (c *counter) check(ctx context.Context) error {
+	atomic.AddInt32(&c.calls, 1)
+	return c.err
+}
+
+func TestRun(t *testing.T) {
+	slow := func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+	tests := []struct {
+		name       string
+		checks     map[string]health.Check
+		order      []string
+		wantStatus string
+		wantChecks []string
+	}{
+		{
+			name:       "ok",
+			checks:     map[string]health.Check{"a": (&counter{}).check, "b": (&counter{}).check},
+			order:      []string{"a", "b"},
+			wantStatus: health.StatusOK,
+			wantChecks: []string{health.StatusOK, health.StatusOK},
+		},
+		{
+			name:       "failed",
+			checks:     map[string]health.Check{"a": (&counter{}).check, "b": (&counter{err: errors.New("down")}).check},
+			order:      []string{"a", "b"},
+			wantStatus: health.StatusError,
+			wantChecks: []string{health.StatusOK, health.StatusError},
+		},
+		{
+			name:       "timeout",
+			checks:     map[string]health.Check{"slow": slow},
+			order:      []string{"slow"},
+			wantStatus: health.StatusError,
+			wantChecks: []string{health.StatusError},
+		},
+	}
+	for _, test := range tests {
+		c := health.NewChecker().WithCfg(health.NewConfig().WithTimeout(10 * time.Millisecond))
+		for _, name := range test.order {
+			c.Register(name, test.checks[name])
+		}
+		got := c.Run(context.Background())
+		if got.Status != test.wantStatus || got.Ready() != (test.wantStatus == health.StatusOK) {
+			t.Fatalf("error for %s!\nwant: %v\ngot: %v", test.name, test.wantStatus, got.Status)
+		}
+		for i, r := range got.Checks {
+			if r.Name != test.order[i] || r.Status != test.wantChecks[i] {
+				t.Fatalf("error for %s!\nwant: %v %v\ngot: %v", test.name, test.order[i], test.wantChecks[i], r)
+			}
+			if r.Status == health.StatusError && r.Error == "" {
+				t.Fatalf("error for %s!\nwant: error message\ngot: %v", test.name, r)
+			}
+		}
+	}
+}
+
+func TestCache(t *testing.T) {
+	tests := []struct {
+		ttl       time.Duration
+		wantCalls int32
+	}{
+		{time.Hour, 1},
+		{0, 3},
+	}
+	for _, test := range tests {
+		cnt := &counter{}
+		c := health.NewChecker().WithCfg(health.NewConfig().WithCacheTTL(test.ttl)).Register("a", cnt.check)
+		for i := 0; i < 3; i++ {
+			c.Run(context.Background())
+		}
+		if cnt.calls != test.wantCalls {
+			t.Fatalf("error for ttl %v!\nwant: %v\ngot: %v", test.ttl, test.wantCalls, cnt.calls)
+		}
+	}
+}
+
+func TestCanceledNotCached(t *testing.T) {
+	cnt := &counter{}
+	c := health.NewChecker().WithCfg(health.NewConfig().WithCacheTTL(time.Hour)).Register("a", cnt.check)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	c.Run(ctx)
+	c.Run(context.Background())
+	if cnt.calls != 2 {
+		t.Fatalf("error!\nwant: %v\ngot: %v", 2, cnt.calls)
+	}
+}
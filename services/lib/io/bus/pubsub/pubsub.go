@@ -19,12 +19,37 @@ package pubsub
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	bg "platform/lib/io/context"
+	"platform/lib/metrics"
+	"platform/lib/trace"
+	"sync"
+	"time"
 
 	"cloud.google.com/go/pubsub"
 	"github.com/goccy/go-json"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	oteltrace "go.opentelemetry.io/otel/trace"
 )
 
-var ctx = context.Background()
+var (
+	publishDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "pubsub_publish_duration_seconds",
+		Help:    "Latency to publish messages in seconds, including retries.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"topic", "status"})
+	publishErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "pubsub_publish_errors_total",
+		Help: "Number of messages failed to be published after retries.",
+	}, []string{"topic"})
+	// tracer starts the spans of the published messages
+	tracer = otel.Tracer("platform/lib/io/bus/pubsub")
+)
 
 // Client defines the PubSub client.
 type Client struct {
@@ -34,7 +59,7 @@ type Client struct {
 
 // NewClient init a new PubSub client.
 func NewClient(projectID string) (*Client, error) {
-	c, err := pubsub.NewClient(ctx, projectID)
+	c, err := pubsub.NewClient(bg.CtxBG, projectID)
 	return &Client{
 		projectID: projectID,
 		Handler:   c,
@@ -62,6 +87,18 @@ func (c *Config) WithCCLimit(n int) *Config {
 	return c
 }
 
+// Close closes the client, the publishers must be closed beforehand.
+func (c *Client) Close() error {
+	return c.Handler.Close()
+}
+
+// WithTimeout sets the max time the client attempts to publish the message, including the retries.
+// The client retries the transient errors itself, see cloud.google.com/go/pubsub/topic for details.
+func (c *Config) WithTimeout(t time.Duration) *Config {
+	c.Timeout = t
+	return c
+}
+
 // GetPublisher init a publisher for a topic.
 func (c *Client) GetPublisher(topic string) *Publisher {
 	if topic == "" {
@@ -97,22 +134,134 @@ func (p *Publisher) WithCCLimit(n int) *Publisher {
 	return p
 }
 
+// WithTimeout overwrites the publish timeout specified in the client cfg, see Config.WithTimeout.
+// The message can be published more than once if the acknowledgement of a successful attempt is lost.
+func (p *Publisher) WithTimeout(t time.Duration) *Publisher {
+	p.t.PublishSettings.Timeout = t
+	return p
+}
+
 // Push pushes data to the topic.
-func (p *Publisher) Push(data []byte) (id string, err error) {
-	return p.t.Publish(ctx, &pubsub.Message{Data: data}).Get(ctx)
+// The trace context is propagated to the subscribers in the message attributes.
+func (p *Publisher) Push(ctx context.Context, data []byte) (id string, err error) {
+	start := time.Now()
+	ctx, span := tracer.Start(ctx, "pubsub.publish",
+		oteltrace.WithSpanKind(oteltrace.SpanKindProducer),
+		oteltrace.WithAttributes(attribute.String("pubsub.topic", p.t.ID())),
+	)
+	defer func() {
+		publishDuration.WithLabelValues(p.t.ID(), metrics.Status(err)).Observe(time.Since(start).Seconds())
+		if err != nil {
+			publishErrors.WithLabelValues(p.t.ID()).Inc()
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		span.End()
+	}()
+	attributes := trace.Carrier{}
+	otel.GetTextMapPropagator().Inject(ctx, attributes)
+	// the client retries the transient errors until the publish timeout
+	id, err = p.t.Publish(ctx, &pubsub.Message{Data: data, Attributes: attributes}).Get(ctx)
+	span.SetAttributes(attribute.String("pubsub.message_id", id))
+	return id, err
+}
+
+// CheckTopic checks the topic exists, e.g. for the readiness probe.
+func (p *Publisher) CheckTopic(ctx context.Context) error {
+	ok, err := p.t.Exists(ctx)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("topic %s does not exist", p.t.ID())
+	}
+	return nil
+}
+
+// Close publishes the pending messages and stops the publisher.
+func (p *Publisher) Close() error {
+	p.t.Stop()
+	return nil
+}
+
+// subscriptionExpiration defines the period of inactivity after which the temporary subscription is deleted,
+// it's the minimum period allowed by PubSub.
+const subscriptionExpiration = 24 * time.Hour
+
+// subscriptionRetention defines how long the unacknowledged messages of the temporary subscription are retained,
+// it's the minimum duration allowed by PubSub.
+const subscriptionRetention = 10 * time.Minute
+
+// Subscriber defines the client to receive the messages of the subscription.
+type Subscriber struct {
+	s *pubsub.Subscription
+
+	mu      sync.Mutex
+	cancel  context.CancelFunc
+	receive sync.WaitGroup
+}
+
+// NewTemporarySubscriber creates the subscription to the topic, e.g. for the service instance to receive
+// every message published to the topic. The subscription is deleted on Close,
+// and it expires after a day of inactivity if the subscriber failed to delete it.
+func (c *Client) NewTemporarySubscriber(ctx context.Context, topic, id string) (*Subscriber, error) {
+	s, err := c.Handler.CreateSubscription(ctx, id, pubsub.SubscriptionConfig{
+		Topic:             c.Handler.Topic(topic),
+		RetentionDuration: subscriptionRetention,
+		ExpirationPolicy:  subscriptionExpiration,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &Subscriber{s: s}, nil
+}
+
+// Receive calls fn for every received message until the context is done, or the subscriber is closed.
+// The message is acknowledged once fn returns, hence fn is called at least once per message.
+// The context passed to fn has the publisher's span set as the current span,
+// if the trace context is propagated in the message attributes.
+func (s *Subscriber) Receive(ctx context.Context, fn func(ctx context.Context, data []byte)) error {
+	s.mu.Lock()
+	if s.cancel != nil {
+		s.mu.Unlock()
+		return errors.New("the subscriber is receiving or closed")
+	}
+	ctx, s.cancel = context.WithCancel(ctx)
+	s.receive.Add(1)
+	s.mu.Unlock()
+	defer s.receive.Done()
+	return s.s.Receive(ctx, func(ctx context.Context, m *pubsub.Message) {
+		fn(otel.GetTextMapPropagator().Extract(ctx, trace.Carrier(m.Attributes)), m.Data)
+		m.Ack()
+	})
+}
+
+// Close stops receiving the messages, and deletes the subscription.
+func (s *Subscriber) Close() error {
+	s.mu.Lock()
+	if s.cancel == nil {
+		s.cancel = func() {}
+	}
+	s.cancel()
+	s.mu.Unlock()
+	s.receive.Wait()
+	return s.s.Delete(bg.CtxBG)
 }
 
 type pubsubMessage struct {
 	Message struct {
-		Data []byte `json:"data"`
+		Data       []byte            `json:"data"`
+		Attributes map[string]string `json:"attributes"`
 	} `json:"message"`
 }
 
-// ExtractMessage function to extract data from the PubSub message.
-func ExtractMessage(data []byte) ([]byte, error) {
+// ExtractMessage function to extract data from the PubSub push message.
+// The returned context has the publisher's span set as the current span,
+// if the trace context is propagated in the message attributes.
+func ExtractMessage(ctx context.Context, data []byte) (context.Context, []byte, error) {
 	var raw *pubsubMessage
 	if err := json.Unmarshal(data, &raw); err != nil {
-		return nil, err
+		return ctx, nil, err
 	}
-	return raw.Message.Data, nil
+	return otel.GetTextMapPropagator().Extract(ctx, trace.Carrier(raw.Message.Attributes)), raw.Message.Data, nil
 }
@@ -0,0 +1,89 @@
+/* Copyright 2021 Dmitry Kisler dkisler.com
+
+Licensed under the Apache License,Version 2.0 (the "License");
+you may not use this file except in compliance with the License. You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED,
+INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE,
+AND NONINFRINGEMENT. IN NO EVENT WILL THE LICENSOR OR OTHER CONTRIBUTORS BE LIABLE FOR ANY CLAIM, DAMAGES,
+OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF,
+OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+See the License for the specific language governing permissions and limitations under the License.
+*/
+
+package pubsub_test
+
+import (
+	"context"
+	"platform/lib/io/bus/pubsub"
+	"testing"
+	"time"
+
+	gpubsub "cloud.google.com/go/pubsub"
+	"cloud.google.com/go/pubsub/pstest"
+	"google.golang.org/api/option"
+	"google.golang.org/grpc"
+)
+
+// newFakeClient returns the client of the in-memory PubSub server with the topic created.
+func newFakeClient(t *testing.T, topic string) *pubsub.Client {
+	srv := pstest.NewServer()
+	t.Cleanup(func() { srv.Close() })
+	conn, err := grpc.Dial(srv.Addr, grpc.WithInsecure())
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := gpubsub.NewClient(context.Background(), "project", option.WithGRPCConn(conn))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	if _, err := c.CreateTopic(context.Background(), topic); err != nil {
+		t.Fatal(err)
+	}
+	return &pubsub.Client{Handler: c}
+}
+
+func TestTemporarySubscriber(t *testing.T) {
+	ctx := context.Background()
+	c := newFakeClient(t, "topic")
+	s, err := c.NewTemporarySubscriber(ctx, "topic", "topic-instance")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	cfg, err := c.Handler.Subscription("topic-instance").Config(ctx)
+	if err != nil || cfg.ExpirationPolicy != 24*time.Hour {
+		t.Fatalf("error!\nwant: subscription expiring after a day of inactivity\ngot: %+v, %v", cfg, err)
+	}
+
+	received := make(chan string, 1)
+	errs := make(chan error, 1)
+	go func() {
+		errs <- s.Receive(ctx, func(ctx context.Context, data []byte) { received <- string(data) })
+	}()
+	p := c.GetPublisher("topic")
+	defer p.Close()
+	if _, err := p.Push(ctx, []byte("data")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	select {
+	case got := <-received:
+		if got != "data" {
+			t.Fatalf("error!\nwant: data\ngot: %s", got)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("error!\nwant: message received\ngot: timeout")
+	}
+
+	if err := s.Close(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := <-errs; err != nil {
+		t.Fatalf("error!\nwant: receiving stopped on close\ngot: %v", err)
+	}
+	if ok, err := c.Handler.Subscription("topic-instance").Exists(ctx); ok || err != nil {
+		t.Fatalf("error!\nwant: subscription deleted on close\ngot: %v, %v", ok, err)
+	}
+}
@@ -18,37 +18,343 @@ Package wraps the interface to GCP Storage.
 package gcs
 
 import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"hash/crc32"
 	"io"
+	"mime"
+	"net/http"
+	"path/filepath"
 	bg "platform/lib/io/context"
+	"platform/lib/metrics"
+	"platform/lib/retry"
+	"time"
 
 	"cloud.google.com/go/storage"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/iterator"
+	"google.golang.org/api/option"
 )
 
+var operationDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+	Name:    "gcs_operation_duration_seconds",
+	Help:    "Latency of the storage operations in seconds, including retries.",
+	Buckets: prometheus.DefBuckets,
+}, []string{"operation", "bucket", "status"})
+
+// tracer starts the spans of the storage operations.
+var tracer = otel.Tracer("platform/lib/io/store/gcs")
+
+// instrument starts the span and the latency measurement of the operation.
+// The returned function ends them with the operation error.
+func instrument(ctx context.Context, operation, bucket, path string) (context.Context, func(err error)) {
+	start := time.Now()
+	ctx, span := tracer.Start(ctx, "gcs."+operation,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attribute.String("gcs.bucket", bucket), attribute.String("gcs.object", path)),
+	)
+	return ctx, func(err error) {
+		operationDuration.WithLabelValues(operation, bucket, metrics.Status(err)).Observe(time.Since(start).Seconds())
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		span.End()
+	}
+}
+
 // Client defines the client to interact with bigquery
 type Client struct {
 	*storage.Client
+	retry *retry.Policy
 }
 
 // NewClient init a new Bigquery client.
-func NewClient() (*Client, error) {
-	c, err := storage.NewClient(bg.CtxBG)
-	return &Client{c}, err
+// The options configure the storage client, e.g. the endpoint of the emulator.
+func NewClient(opts ...option.ClientOption) (*Client, error) {
+	c, err := storage.NewClient(bg.CtxBG, opts...)
+	return &Client{c, retry.NewPolicy()}, err
+}
+
+// WithRetry sets the retry policy of the uploads, and of the reads failed the checksums verification.
+// The other calls are retried by the storage client itself.
+func (c *Client) WithRetry(p *retry.Policy) *Client {
+	c.retry = p
+	return c
 }
 
+// ErrObjectNotExist defines the error of the missing object, the client errors are checked against it with errors.Is.
+var ErrObjectNotExist = storage.ErrObjectNotExist
+
 // Write writes object to the bucket.
-func (c *Client) Write(bucket, path string, obj []byte) error {
-	writer := c.Bucket(bucket).Object(path).NewWriter(bg.CtxBG)
-	defer writer.Close()
-	_, err := writer.Write(obj)
-	return err
+func (c *Client) Write(ctx context.Context, bucket, path string, obj []byte) error {
+	return c.WriteWithMetadata(ctx, bucket, path, obj, nil)
+}
+
+// crc32cTable defines the CRC32 table with the Castagnoli polynomial used by the storage.
+var crc32cTable = crc32.MakeTable(crc32.Castagnoli)
+
+// CRC32C returns the CRC32C checksum of the data as computed by the storage.
+func CRC32C(data []byte) uint32 {
+	return crc32.Checksum(data, crc32cTable)
+}
+
+// ErrChecksumMismatch defines the error of the object data not matching its checksums.
+var ErrChecksumMismatch = errors.New("checksum mismatch")
+
+// verifyChecksums checks the data against the object's checksums.
+func verifyChecksums(data []byte, attrs *storage.ObjectAttrs) error {
+	if c := CRC32C(data); c != attrs.CRC32C {
+		return fmt.Errorf("%w: crc32c %08x, expected %08x", ErrChecksumMismatch, c, attrs.CRC32C)
+	}
+	// the composite objects do not have the MD5 hash
+	if len(attrs.MD5) > 0 {
+		if h := md5.Sum(data); !bytes.Equal(h[:], attrs.MD5) {
+			return fmt.Errorf("%w: md5 %x, expected %x", ErrChecksumMismatch, h, attrs.MD5)
+		}
+	}
+	return nil
+}
+
+// contentType identifies the content type of the object by the path extension, or by the data.
+func contentType(path string, obj []byte) string {
+	if t := mime.TypeByExtension(filepath.Ext(path)); t != "" {
+		return t
+	}
+	return http.DetectContentType(obj)
+}
+
+// WriteWithMetadata writes object with the custom metadata to the bucket.
+// The object is written with its content type and checksums, the storage rejects the data not matching them.
+// The object is not created if the write fails.
+func (c *Client) WriteWithMetadata(ctx context.Context, bucket, path string, obj []byte, metadata map[string]string) (err error) {
+	ctx, done := instrument(ctx, "write", bucket, path)
+	defer func() { done(err) }()
+	return c.retry.Do(ctx, func(ctx context.Context) error {
+		_, err := write(ctx, c.Bucket(bucket).Object(path), obj, metadata)
+		return err
+	})
+}
+
+// ErrPreconditionFailed defines the error of the object changed since its generation was read.
+var ErrPreconditionFailed = errors.New("precondition failed")
+
+// WriteIfGeneration writes object with the custom metadata to the bucket if the object's generation matches,
+// the generation 0 requires the object to not exist. The generation of the written object is returned,
+// ErrPreconditionFailed is returned if the object was changed, e.g. by the concurrent write.
+// The write is not retried, the retried write would fail the precondition if the former one was committed.
+func (c *Client) WriteIfGeneration(ctx context.Context, bucket, path string, obj []byte, metadata map[string]string,
+	generation int64) (written int64, err error) {
+	ctx, done := instrument(ctx, "write", bucket, path)
+	defer func() { done(err) }()
+	cond := storage.Conditions{GenerationMatch: generation}
+	if generation == 0 {
+		cond = storage.Conditions{DoesNotExist: true}
+	}
+	written, err = write(ctx, c.Bucket(bucket).Object(path).If(cond), obj, metadata)
+	var e *googleapi.Error
+	if errors.As(err, &e) && e.Code == http.StatusPreconditionFailed {
+		return 0, fmt.Errorf("%w: %v", ErrPreconditionFailed, err)
+	}
+	return written, err
+}
+
+// write uploads the object with its content type and checksums, and returns the generation of the written object.
+func write(ctx context.Context, o *storage.ObjectHandle, obj []byte, metadata map[string]string) (int64, error) {
+	// the upload is aborted by canceling the context
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	h := md5.Sum(obj)
+	writer := o.NewWriter(ctx)
+	writer.Metadata = metadata
+	writer.ContentType = contentType(o.ObjectName(), obj)
+	writer.CRC32C = CRC32C(obj)
+	writer.SendCRC32C = true
+	writer.MD5 = h[:]
+	if _, err := writer.Write(obj); err != nil {
+		cancel()
+		_ = writer.Close()
+		return 0, err
+	}
+	// the object is committed on close, the upload errors are returned by it
+	if err := writer.Close(); err != nil {
+		return 0, err
+	}
+	return writer.Attrs().Generation, nil
 }
 
 // Read reads object from bucket.
-func (c *Client) Read(bucket, path string) (data []byte, err error) {
-	r, err := c.Bucket(bucket).Object(path).NewReader(bg.CtxBG)
-	if err != nil {
-		return nil, err
+// The data is verified against the object's checksums, ErrChecksumMismatch is returned if it's corrupted.
+func (c *Client) Read(ctx context.Context, bucket, path string) ([]byte, error) {
+	data, _, err := c.ReadGeneration(ctx, bucket, path)
+	return data, err
+}
+
+// ReadGeneration reads object from bucket as Read, and returns the generation of the read object,
+// e.g. to write the object if it was not changed since, see WriteIfGeneration.
+func (c *Client) ReadGeneration(ctx context.Context, bucket, path string) (data []byte, generation int64, err error) {
+	ctx, done := instrument(ctx, "read", bucket, path)
+	defer func() { done(err) }()
+	err = c.retry.Do(ctx, func(ctx context.Context) error {
+		// the calls are retried by the storage client
+		o := c.Bucket(bucket).Object(path)
+		attrs, err := o.Attrs(ctx)
+		if err != nil {
+			return retry.Permanent(err)
+		}
+		// the read is pinned to the generation the checksums belong to
+		generation = attrs.Generation
+		r, err := o.Generation(generation).NewReader(ctx)
+		if err != nil {
+			return retry.Permanent(err)
+		}
+		defer r.Close()
+		if data, err = io.ReadAll(r); err != nil {
+			return retry.Permanent(err)
+		}
+		// the data may be corrupted in transit, so it's read again
+		return retry.Transient(verifyChecksums(data, attrs))
+	})
+	return data, generation, err
+}
+
+// UpdateMetadata sets the custom metadata keys of the object, other keys are preserved.
+func (c *Client) UpdateMetadata(ctx context.Context, bucket, path string, metadata map[string]string) (err error) {
+	ctx, done := instrument(ctx, "update_metadata", bucket, path)
+	defer func() { done(err) }()
+	// the update is retried by the storage client
+	_, err = c.Bucket(bucket).Object(path).Update(ctx, storage.ObjectAttrsToUpdate{Metadata: metadata})
+	return err
+}
+
+// CheckBucket checks the bucket is reachable, e.g. for the readiness probe.
+func (c *Client) CheckBucket(ctx context.Context, bucket string) error {
+	_, err := c.Bucket(bucket).Attrs(ctx)
+	return err
+}
+
+// Object defines the stored object attributes.
+type Object struct {
+	Name        string
+	Created     time.Time
+	Size        int64
+	ContentType string
+	// CRC32C and MD5 define the checksums of the object's data, MD5 is not set for the composite objects.
+	CRC32C   uint32
+	MD5      []byte
+	Metadata map[string]string
+}
+
+func newObject(attrs *storage.ObjectAttrs) *Object {
+	return &Object{
+		Name:        attrs.Name,
+		Created:     attrs.Created,
+		Size:        attrs.Size,
+		ContentType: attrs.ContentType,
+		CRC32C:      attrs.CRC32C,
+		MD5:         attrs.MD5,
+		Metadata:    attrs.Metadata,
+	}
+}
+
+// Walk calls fn for every object in the bucket with the name starting with the prefix.
+// It stops at the first error returned by fn.
+func (c *Client) Walk(ctx context.Context, bucket, prefix string, fn func(o *Object) error) (err error) {
+	ctx, done := instrument(ctx, "walk", bucket, prefix)
+	defer func() { done(err) }()
+	it := c.Bucket(bucket).Objects(ctx, &storage.Query{Prefix: prefix})
+	for {
+		attrs, err := it.Next()
+		if err == iterator.Done {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := fn(newObject(attrs)); err != nil {
+			return err
+		}
+	}
+}
+
+// Page defines the page of the listed objects.
+type Page struct {
+	Objects []*Object
+	// NextPageToken defines the token to list the next page, it's empty if all objects are listed.
+	NextPageToken string
+}
+
+// ErrInvalidPageToken defines the error of the malformed page token.
+var ErrInvalidPageToken = errors.New("invalid page token")
+
+// ListScanLimit defines the max number of objects scanned to list a page.
+// The page may contain less objects than requested if the filter skips the scanned objects,
+// the listing continues from the last scanned object.
+const ListScanLimit = 1000
+
+// EncodePageToken encodes the name of the last listed object as the page token.
+func EncodePageToken(name string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(name))
+}
+
+// DecodePageToken decodes the name of the last listed object from the page token.
+func DecodePageToken(token string) (string, error) {
+	b, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil || len(b) == 0 {
+		return "", ErrInvalidPageToken
+	}
+	return string(b), nil
+}
+
+// List lists up to limit objects in the bucket with the name starting with the prefix, in the name order.
+// The objects are listed after the page token returned by the previous call, from the start if it's empty.
+// The objects not accepted by the filter are skipped, the filter accepts all objects if it's nil.
+// The next page token is set if the objects are left to be scanned, they may be skipped by the filter though.
+func (c *Client) List(ctx context.Context, bucket, prefix, pageToken string, limit int, filter func(o *Object) bool) (page *Page, err error) {
+	ctx, done := instrument(ctx, "list", bucket, prefix)
+	defer func() { done(err) }()
+	q := &storage.Query{Prefix: prefix}
+	if pageToken != "" {
+		last, err := DecodePageToken(pageToken)
+		if err != nil {
+			return nil, err
+		}
+		// the offset is inclusive, the listing starts from the name following the last listed one
+		q.StartOffset = last + "\x00"
+	}
+	page = &Page{}
+	it := c.Bucket(bucket).Objects(ctx, q)
+	for scanned := 0; ; scanned++ {
+		attrs, err := it.Next()
+		if err == iterator.Done {
+			return page, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		o := newObject(attrs)
+		if filter == nil || filter(o) {
+			page.Objects = append(page.Objects, o)
+		}
+		if len(page.Objects) == limit || scanned+1 == ListScanLimit {
+			// the next object is peeked not to return the token to the empty page
+			if _, err := it.Next(); err == iterator.Done {
+				return page, nil
+			} else if err != nil {
+				return nil, err
+			}
+			page.NextPageToken = EncodePageToken(o.Name)
+			return page, nil
+		}
 	}
-	defer r.Close()
-	return io.ReadAll(r)
 }
@@ -0,0 +1,133 @@
+/* Copyright 2021 Dmitry Kisler dkisler.com
+
+Licensed under the Apache License,Version 2.0 (the "License");
+you may not use this file except in compliance with the License. You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED,
+INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE,
+AND NONINFRINGEMENT. IN NO EVENT WILL THE LICENSOR OR OTHER CONTRIBUTORS BE LIABLE FOR ANY CLAIM, DAMAGES,
+OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF,
+OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+See the License for the specific language governing permissions and limitations under the License.
+*/
+
+package gcs_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"platform/lib/io/store/gcs"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/goccy/go-json"
+	"google.golang.org/api/option"
+)
+
+// newFakeStorage starts the server serving the objects listing of the storage JSON API,
+// the conditional writes fail their preconditions, and the other objects requests fail as the objects do not exist.
+func newFakeStorage(t *testing.T, names []string) *gcs.Client {
+	sort.Strings(names)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("ifGenerationMatch") != "" {
+			http.Error(w, `{"error": {"code": 412, "message": "Precondition Failed"}}`, http.StatusPreconditionFailed)
+			return
+		}
+		if !strings.HasSuffix(r.URL.Path, "/o") {
+			http.Error(w, `{"error": {"code": 404, "message": "No such object"}}`, http.StatusNotFound)
+			return
+		}
+		type item struct {
+			Name string `json:"name"`
+			Size string `json:"size"`
+		}
+		q := r.URL.Query()
+		items := []item{}
+		for _, name := range names {
+			if strings.HasPrefix(name, q.Get("prefix")) && name >= q.Get("startOffset") {
+				items = append(items, item{Name: name, Size: "1"})
+			}
+		}
+		b, _ := json.Marshal(struct {
+			Items []item `json:"items"`
+		}{items})
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(b)
+	}))
+	t.Cleanup(srv.Close)
+	c, err := gcs.NewClient(option.WithEndpoint(srv.URL+"/storage/v1/"), option.WithoutAuthentication())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestList(t *testing.T) {
+	c := newFakeStorage(t, []string{"a/1", "a/2", "a/3", "a/4", "b/1"})
+	skipEven := func(o *gcs.Object) bool { return o.Name != "a/2" && o.Name != "a/4" }
+
+	tests := []struct {
+		name   string
+		limit  int
+		filter func(o *gcs.Object) bool
+		want   [][]string
+	}{
+		{name: "limit above count", limit: 10, want: [][]string{{"a/1", "a/2", "a/3", "a/4"}}},
+		// the last page is not followed by the empty one
+		{name: "limit equal to count", limit: 4, want: [][]string{{"a/1", "a/2", "a/3", "a/4"}}},
+		{name: "limit below count", limit: 3, want: [][]string{{"a/1", "a/2", "a/3"}, {"a/4"}}},
+		{name: "limit dividing count", limit: 2, want: [][]string{{"a/1", "a/2"}, {"a/3", "a/4"}}},
+		// the objects left to be scanned are skipped by the filter
+		{name: "filter", limit: 2, filter: skipEven, want: [][]string{{"a/1", "a/3"}, {}}},
+	}
+
+	for _, test := range tests {
+		got := [][]string{}
+		token := ""
+		for {
+			page, err := c.List(context.Background(), "bucket", "a/", token, test.limit, test.filter)
+			if err != nil {
+				t.Fatalf("error for %s!\nwant: nil\ngot: %v", test.name, err)
+			}
+			names := []string{}
+			for _, o := range page.Objects {
+				names = append(names, o.Name)
+			}
+			got = append(got, names)
+			if token = page.NextPageToken; token == "" {
+				break
+			}
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Fatalf("error for %s!\nwant: %v\ngot: %v", test.name, test.want, got)
+		}
+	}
+
+	if _, err := c.List(context.Background(), "bucket", "a/", "!", 1, nil); !errors.Is(err, gcs.ErrInvalidPageToken) {
+		t.Fatalf("error!\nwant: %v\ngot: %v", gcs.ErrInvalidPageToken, err)
+	}
+}
+
+func TestErrObjectNotExist(t *testing.T) {
+	c := newFakeStorage(t, nil)
+	if _, err := c.Read(context.Background(), "bucket", "a/1"); !errors.Is(err, gcs.ErrObjectNotExist) {
+		t.Fatalf("error!\nwant: %v\ngot: %v", gcs.ErrObjectNotExist, err)
+	}
+	if err := c.UpdateMetadata(context.Background(), "bucket", "a/1", map[string]string{"k": "v"}); !errors.Is(err, gcs.ErrObjectNotExist) {
+		t.Fatalf("error!\nwant: %v\ngot: %v", gcs.ErrObjectNotExist, err)
+	}
+}
+
+func TestErrPreconditionFailed(t *testing.T) {
+	c := newFakeStorage(t, nil)
+	_, err := c.WriteIfGeneration(context.Background(), "bucket", "a/1.json", []byte(`{}`), nil, 1)
+	if !errors.Is(err, gcs.ErrPreconditionFailed) {
+		t.Fatalf("error!\nwant: %v\ngot: %v", gcs.ErrPreconditionFailed, err)
+	}
+}
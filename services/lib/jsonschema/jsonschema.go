@@ -18,25 +18,152 @@ Package wraps around github.com/xeipuuv/gojsonschema.
 package jsonschema
 
 import (
+	"errors"
 	"fmt"
+	"sort"
 	"strings"
 
+	"github.com/goccy/go-json"
 	"github.com/xeipuuv/gojsonschema"
 )
 
 // ValidationError defines JSON validation errors.
 type ValidationError struct {
-	o []gojsonschema.ResultError
+	o []FieldError
+}
+
+// NewValidationError defines the ValidationError, e.g. of the semantic checks, see Rule.
+func NewValidationError(fields []FieldError) *ValidationError {
+	return &ValidationError{fields}
 }
 
 func (e *ValidationError) Error() string {
 	o := []string{}
 	for _, i := range e.o {
-		o = append(o, fmt.Sprintf("Field %s: %s", i.Field(), i.Description()))
+		o = append(o, fmt.Sprintf("Field %s: %s", field(i.Pointer), i.Message))
 	}
 	return strings.Join(o, "\n")
 }
 
+// Errors returns the validation errors per element.
+func (e *ValidationError) Errors() []FieldError {
+	return append([]FieldError{}, e.o...)
+}
+
+// FieldError defines the validation error of the JSON document element.
+type FieldError struct {
+	// Pointer defines the JSON Pointer (RFC 6901) to the invalid element, e.g. /data/0.
+	// For the missing and the not allowed properties, it points to the property.
+	Pointer string `json:"pointer"`
+	// Keyword defines the JSON schema keyword the element violates, e.g. minItems.
+	Keyword string `json:"keyword,omitempty"`
+	Message string `json:"message"`
+	// Value defines the JSON encoded invalid value, it's not set for the missing and the not allowed properties.
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+// keywords maps the validator's error types to the JSON schema keywords.
+var keywords = map[string]string{
+	"false":                           "false",
+	"required":                        "required",
+	"invalid_type":                    "type",
+	"number_any_of":                   "anyOf",
+	"number_one_of":                   "oneOf",
+	"number_all_of":                   "allOf",
+	"number_not":                      "not",
+	"missing_dependency":              "dependencies",
+	"const":                           "const",
+	"enum":                            "enum",
+	"array_no_additional_items":       "additionalItems",
+	"array_min_items":                 "minItems",
+	"array_max_items":                 "maxItems",
+	"unique":                          "uniqueItems",
+	"contains":                        "contains",
+	"array_min_properties":            "minProperties",
+	"array_max_properties":            "maxProperties",
+	"additional_property_not_allowed": "additionalProperties",
+	"invalid_property_pattern":        "patternProperties",
+	"invalid_property_name":           "propertyNames",
+	"string_gte":                      "minLength",
+	"string_lte":                      "maxLength",
+	"pattern":                         "pattern",
+	"format":                          "format",
+	"multiple_of":                     "multipleOf",
+	"number_gte":                      "minimum",
+	"number_gt":                       "exclusiveMinimum",
+	"number_lte":                      "maximum",
+	"number_lt":                       "exclusiveMaximum",
+	"condition_then":                  "then",
+	"condition_else":                  "else",
+}
+
+// pointerEscaper and pointerUnescaper escape and unescape the JSON Pointer reference tokens.
+var (
+	pointerEscaper   = strings.NewReplacer("~", "~0", "/", "~1")
+	pointerUnescaper = strings.NewReplacer("~1", "/", "~0", "~")
+)
+
+// pointer converts the validator's context, e.g. (root).data.0, to the JSON Pointer, e.g. /data/0.
+func pointer(c *gojsonschema.JsonContext, property string) string {
+	// the delimiter is not expected in the keys
+	const delimiter = "\x00"
+	el := strings.Split(c.String(delimiter), delimiter)[1:]
+	if property != "" {
+		el = append(el, property)
+	}
+	var o strings.Builder
+	for _, e := range el {
+		o.WriteString("/")
+		o.WriteString(pointerEscaper.Replace(e))
+	}
+	return o.String()
+}
+
+// field converts the JSON Pointer to the dot-separated field path, e.g. /data/0 to data.0.
+func field(pointer string) string {
+	if pointer == "" {
+		return "(root)"
+	}
+	el := strings.Split(pointer[1:], "/")
+	for i, e := range el {
+		el[i] = pointerUnescaper.Replace(e)
+	}
+	return strings.Join(el, ".")
+}
+
+// newValidationError converts the validator's errors.
+func newValidationError(res []gojsonschema.ResultError) *ValidationError {
+	o := make([]FieldError, 0, len(res))
+	for _, i := range res {
+		f := FieldError{
+			Keyword: keywords[i.Type()],
+			Message: i.Description(),
+		}
+		property := ""
+		switch i.Type() {
+		case "required", "additional_property_not_allowed":
+			property, _ = i.Details()["property"].(string)
+		default:
+			f.Value, _ = json.Marshal(i.Value())
+		}
+		f.Pointer = pointer(i.Context(), property)
+		o = append(o, f)
+	}
+	// the validator checks the properties in random order, the errors are sorted to be reported consistently
+	sort.SliceStable(o, func(i, j int) bool { return o[i].Pointer < o[j].Pointer })
+	return &ValidationError{o}
+}
+
+// FieldErrors returns the validation errors per element,
+// the errors other than ValidationError, e.g. the malformed JSON, are returned as the error of the document root.
+func FieldErrors(err error) []FieldError {
+	var e *ValidationError
+	if errors.As(err, &e) {
+		return e.Errors()
+	}
+	return []FieldError{{Message: err.Error()}}
+}
+
 // Schema defines the schema object.
 type Schema struct {
 	*gojsonschema.Schema
@@ -60,7 +187,7 @@ func (s *Schema) ValidateBytes(data []byte) error {
 	if res.Valid() {
 		return nil
 	}
-	return &ValidationError{res.Errors()}
+	return newValidationError(res.Errors())
 }
 
 // ValidateObject validates GO object.
@@ -72,5 +199,5 @@ func (s *Schema) ValidateObject(data interface{}) error {
 	if res.Valid() {
 		return nil
 	}
-	return &ValidationError{res.Errors()}
+	return newValidationError(res.Errors())
 }
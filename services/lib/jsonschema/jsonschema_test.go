@@ -0,0 +1,82 @@
+/* Copyright 2021 Dmitry Kisler dkisler.com
+
+Licensed under the Apache License,Version 2.0 (the "License");
+you may not use this file except in compliance with the License. You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED,
+INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE,
+AND NONINFRINGEMENT. IN NO EVENT WILL THE LICENSOR OR OTHER CONTRIBUTORS BE LIABLE FOR ANY CLAIM, DAMAGES,
+OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF,
+OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+See the License for the specific language governing permissions and limitations under the License.
+*/
+
+package jsonschema_test
+
+import (
+	"encoding/json"
+	"platform/lib/jsonschema"
+	"reflect"
+	"testing"
+)
+
+func TestFieldErrors(t *testing.T) {
+	schema, err := jsonschema.NewSchema([]byte(`{
+		"type": "object",
+		"required": ["id", "data"],
+		"additionalProperties": false,
+		"properties": {
+			"id": {"type": "string"},
+			"data": {"type": "array", "minItems": 1, "items": {"type": "integer", "minimum": 0}},
+			"a/b": {"type": "string"}
+		}
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		in   string
+		want []jsonschema.FieldError
+	}{
+		{in: `{"id": "a", "data": [1]}`},
+		{
+			in:   `{"data": [1]}`,
+			want: []jsonschema.FieldError{{Pointer: "/id", Keyword: "required", Message: "id is required"}},
+		},
+		{
+			in: `{"id": "a", "data": [1, -1]}`,
+			want: []jsonschema.FieldError{
+				{Pointer: "/data/1", Keyword: "minimum", Message: "Must be greater than or equal to 0", Value: json.RawMessage("-1")},
+			},
+		},
+		{
+			in: `{"id": "a", "data": [], "a/b": 1}`,
+			want: []jsonschema.FieldError{
+				{Pointer: "/a~1b", Keyword: "type", Message: "Invalid type. Expected: string, given: integer", Value: json.RawMessage("1")},
+				{Pointer: "/data", Keyword: "minItems", Message: "Array must have at least 1 items", Value: json.RawMessage("[]")},
+			},
+		},
+		{
+			in:   `{"id": "a", "data": [1], "x": 1}`,
+			want: []jsonschema.FieldError{{Pointer: "/x", Keyword: "additionalProperties", Message: "Additional property x is not allowed"}},
+		},
+		{
+			in:   `{`,
+			want: []jsonschema.FieldError{{Message: "unexpected EOF"}},
+		},
+	}
+
+	for _, test := range tests {
+		err := schema.ValidateBytes([]byte(test.in))
+		var got []jsonschema.FieldError
+		if err != nil {
+			got = jsonschema.FieldErrors(err)
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Fatalf("error for %s!\nwant: %#v\ngot: %#v", test.in, test.want, got)
+		}
+	}
+}
@@ -0,0 +1,407 @@
+/* Copyright 2021 Dmitry Kisler dkisler.com
+
+Licensed under the Apache License,Version 2.0 (the "License");
+you may not use this file except in compliance with the License. You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED,
+INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE,
+AND NONINFRINGEMENT. IN NO EVENT WILL THE LICENSOR OR OTHER CONTRIBUTORS BE LIABLE FOR ANY CLAIM, DAMAGES,
+OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF,
+OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+See the License for the specific language governing permissions and limitations under the License.
+*/
+
+package jsonschema
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/goccy/go-json"
+	"github.com/xeipuuv/gojsonschema"
+)
+
+// AddFormat registers the custom format to be checked by the "format" keyword of all schemas.
+// The checker is called for the values of any type, the numbers are passed as float64,
+// it shall accept the values of the types it does not check.
+func AddFormat(name string, check func(input interface{}) bool) {
+	gojsonschema.FormatCheckers.Add(name, formatChecker(check))
+}
+
+type formatChecker func(input interface{}) bool
+
+func (f formatChecker) IsFormat(input interface{}) bool {
+	// the validator passes the numbers as *big.Rat
+	if n, ok := input.(*big.Rat); ok {
+		input, _ = n.Float64()
+	}
+	return f(input)
+}
+
+// Rule defines the semantic check of the document which is valid against its schema.
+type Rule interface {
+	// Check returns the violations of the rule by the decoded JSON document.
+	Check(doc interface{}) []FieldError
+}
+
+// ruleTypes defines the rules by name to be configured, see Rules.UnmarshalJSON.
+var ruleTypes = map[string]func() Rule{
+	"time_window": func() Rule { return &TimeWindow{} },
+	"bounds":      func() Rule { return &Bounds{} },
+	"max_items":   func() Rule { return &MaxItems{} },
+	"sentinel":    func() Rule { return &Sentinel{} },
+	"constant":    func() Rule { return &Constant{} },
+	"format":      func() Rule { return &Format{} },
+}
+
+// AddRule registers the custom rule type to be configured by name.
+func AddRule(name string, new func() Rule) {
+	ruleTypes[name] = new
+}
+
+// Rules defines the set of the rules.
+type Rules []Rule
+
+// UnmarshalJSON decodes the rules from the list of rule configurations,
+// each configuration defines the rule type by the "rule" field, e.g. {"rule": "max_items", "pointer": "/data", "max": 100}.
+func (r *Rules) UnmarshalJSON(b []byte) error {
+	var configs []json.RawMessage
+	if err := json.Unmarshal(b, &configs); err != nil {
+		return err
+	}
+	o := Rules{}
+	for i, c := range configs {
+		var t struct {
+			Rule    string `json:"rule"`
+			Pointer string `json:"pointer"`
+		}
+		if err := json.Unmarshal(c, &t); err != nil {
+			return fmt.Errorf("rule %d: %w", i, err)
+		}
+		new, ok := ruleTypes[t.Rule]
+		if !ok {
+			return fmt.Errorf("rule %d: unknown rule %q", i, t.Rule)
+		}
+		if t.Pointer != "" && !strings.HasPrefix(t.Pointer, "/") {
+			return fmt.Errorf("rule %d: invalid pointer %q", i, t.Pointer)
+		}
+		rule := new()
+		if err := json.Unmarshal(c, rule); err != nil {
+			return fmt.Errorf("rule %d: %w", i, err)
+		}
+		o = append(o, rule)
+	}
+	*r = o
+	return nil
+}
+
+// Validate checks the JSON document against the rules.
+// It returns ValidationError listing the violations of all rules.
+func (r Rules) Validate(data []byte) error {
+	if len(r) == 0 {
+		return nil
+	}
+	var doc interface{}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return err
+	}
+	o := []FieldError{}
+	for _, rule := range r {
+		o = append(o, rule.Check(doc)...)
+	}
+	if len(o) == 0 {
+		return nil
+	}
+	return NewValidationError(o)
+}
+
+// RuleSets defines the default rules, and the rules by submitter which replace the default ones.
+type RuleSets struct {
+	Default    Rules            `json:"default"`
+	Submitters map[string]Rules `json:"submitters"`
+}
+
+// ParseRuleSets decodes the rule sets configuration.
+func ParseRuleSets(b []byte) (*RuleSets, error) {
+	var o *RuleSets
+	if err := json.Unmarshal(b, &o); err != nil {
+		return nil, err
+	}
+	if o == nil {
+		return nil, errors.New("no rule sets")
+	}
+	return o, nil
+}
+
+// For returns the rules of the submitter.
+func (s *RuleSets) For(submitter string) Rules {
+	if s == nil {
+		return nil
+	}
+	if r, ok := s.Submitters[submitter]; ok {
+		return r
+	}
+	return s.Default
+}
+
+// Duration defines the duration decoded from the string, e.g. "1h30m".
+type Duration struct {
+	time.Duration
+}
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	d.Duration = v
+	return nil
+}
+
+// maxRuleErrors defines the max number of the violations reported per rule.
+const maxRuleErrors = 10
+
+// resolve returns the element of the document the pointer points to.
+func resolve(doc interface{}, pointer string) (interface{}, bool) {
+	if pointer == "" {
+		return doc, true
+	}
+	for _, t := range strings.Split(pointer[1:], "/") {
+		t = pointerUnescaper.Replace(t)
+		switch v := doc.(type) {
+		case map[string]interface{}:
+			el, ok := v[t]
+			if !ok {
+				return nil, false
+			}
+			doc = el
+		case []interface{}:
+			i, err := strconv.Atoi(t)
+			if err != nil || i < 0 || i >= len(v) {
+				return nil, false
+			}
+			doc = v[i]
+		default:
+			return nil, false
+		}
+	}
+	return doc, true
+}
+
+func rawValue(v interface{}) json.RawMessage {
+	o, _ := json.Marshal(v)
+	return o
+}
+
+// checkValues checks the value, or the values of the array the pointer points to.
+// The number of the reported violations is limited.
+func checkValues(doc interface{}, pointer, keyword string, check func(v interface{}) (string, bool)) []FieldError {
+	el, ok := resolve(doc, pointer)
+	if !ok {
+		return nil
+	}
+	values, isArray := el.([]interface{})
+	if !isArray {
+		values = []interface{}{el}
+	}
+	o := []FieldError{}
+	violations := 0
+	for i, v := range values {
+		msg, ok := check(v)
+		if ok {
+			continue
+		}
+		violations++
+		if len(o) == maxRuleErrors {
+			continue
+		}
+		p := pointer
+		if isArray {
+			p = fmt.Sprintf("%s/%d", pointer, i)
+		}
+		o = append(o, FieldError{Pointer: p, Keyword: keyword, Message: msg, Value: rawValue(v)})
+	}
+	if violations > len(o) {
+		o = append(o, FieldError{
+			Pointer: pointer,
+			Keyword: keyword,
+			Message: fmt.Sprintf("%d more values violate the rule", violations-len(o)),
+		})
+	}
+	return o
+}
+
+// checkNumbers checks the number, or the numbers of the array the pointer points to.
+func checkNumbers(doc interface{}, pointer, keyword string, check func(v float64) (string, bool)) []FieldError {
+	return checkValues(doc, pointer, keyword, func(v interface{}) (string, bool) {
+		n, ok := v.(float64)
+		if !ok {
+			return "", true
+		}
+		return check(n)
+	})
+}
+
+// TimeWindow checks that the timestamp is within the window relative to the time of the check,
+// and not before the fixed lower bound. The timestamp is expected in the RFC 3339 format, the zero limits are not checked.
+type TimeWindow struct {
+	Pointer string `json:"pointer"`
+	// NotBefore defines the earliest timestamp accepted regardless of its age, e.g. the unix epoch.
+	NotBefore time.Time `json:"not_before"`
+	// MaxAge defines how far in the past the timestamp can be.
+	MaxAge Duration `json:"max_age"`
+	// MaxAhead defines how far in the future the timestamp can be, e.g. to tolerate the clock skew.
+	MaxAhead Duration `json:"max_ahead"`
+}
+
+func (r *TimeWindow) Check(doc interface{}) []FieldError {
+	el, ok := resolve(doc, r.Pointer)
+	if !ok {
+		return nil
+	}
+	s, ok := el.(string)
+	if !ok {
+		return nil
+	}
+	t, err := time.Parse(time.RFC3339Nano, s)
+	if err != nil {
+		return nil
+	}
+	now := time.Now()
+	msg := ""
+	switch {
+	case !r.NotBefore.IsZero() && t.Before(r.NotBefore):
+		msg = fmt.Sprintf("Timestamp must not be before %s", r.NotBefore.Format(time.RFC3339))
+	case r.MaxAge.Duration > 0 && t.Before(now.Add(-r.MaxAge.Duration)):
+		msg = fmt.Sprintf("Timestamp must be within %s in the past", r.MaxAge.Duration)
+	case r.MaxAhead.Duration > 0 && t.After(now.Add(r.MaxAhead.Duration)):
+		msg = fmt.Sprintf("Timestamp must be within %s in the future", r.MaxAhead.Duration)
+	default:
+		return nil
+	}
+	return []FieldError{{Pointer: r.Pointer, Keyword: "timeWindow", Message: msg, Value: rawValue(s)}}
+}
+
+// Bounds checks that the number, or the numbers of the array, are within the bounds.
+type Bounds struct {
+	Pointer string   `json:"pointer"`
+	Min     *float64 `json:"min,omitempty"`
+	Max     *float64 `json:"max,omitempty"`
+}
+
+func (r *Bounds) Check(doc interface{}) []FieldError {
+	return checkNumbers(doc, r.Pointer, "bounds", func(v float64) (string, bool) {
+		if r.Min != nil && v < *r.Min {
+			return fmt.Sprintf("Must be greater than or equal to %v", *r.Min), false
+		}
+		if r.Max != nil && v > *r.Max {
+			return fmt.Sprintf("Must be less than or equal to %v", *r.Max), false
+		}
+		return "", true
+	})
+}
+
+// MaxItems checks the max length of the array.
+type MaxItems struct {
+	Pointer string `json:"pointer"`
+	Max     int    `json:"max"`
+}
+
+func (r *MaxItems) Check(doc interface{}) []FieldError {
+	el, ok := resolve(doc, r.Pointer)
+	if !ok {
+		return nil
+	}
+	if v, ok := el.([]interface{}); ok && len(v) > r.Max {
+		return []FieldError{{
+			Pointer: r.Pointer,
+			Keyword: "maxItems",
+			Message: fmt.Sprintf("Array must have at most %d items, given %d", r.Max, len(v)),
+		}}
+	}
+	return nil
+}
+
+// Sentinel checks that the number, or the numbers of the array, are not the placeholders of the missing values, e.g. -9999.
+type Sentinel struct {
+	Pointer string    `json:"pointer"`
+	Values  []float64 `json:"values"`
+}
+
+func (r *Sentinel) Check(doc interface{}) []FieldError {
+	return checkNumbers(doc, r.Pointer, "sentinel", func(v float64) (string, bool) {
+		for _, s := range r.Values {
+			if v == s {
+				return fmt.Sprintf("Value %v is a placeholder of the missing value", v), false
+			}
+		}
+		return "", true
+	})
+}
+
+// Constant checks that the array of at least MinItems items does not consist of the identical values.
+type Constant struct {
+	Pointer  string `json:"pointer"`
+	MinItems int    `json:"min_items"`
+}
+
+func (r *Constant) Check(doc interface{}) []FieldError {
+	el, ok := resolve(doc, r.Pointer)
+	if !ok {
+		return nil
+	}
+	v, ok := el.([]interface{})
+	if !ok || len(v) < 2 || len(v) < r.MinItems {
+		return nil
+	}
+	for _, i := range v[1:] {
+		if !reflect.DeepEqual(i, v[0]) {
+			return nil
+		}
+	}
+	return []FieldError{{
+		Pointer: r.Pointer,
+		Keyword: "constant",
+		Message: fmt.Sprintf("Array of %d items must not consist of identical values", len(v)),
+		Value:   rawValue(v[0]),
+	}}
+}
+
+// Format checks that the value, or the values of the array, are of the custom format registered by AddFormat.
+// It allows to check the formats of the fields without changing the submission schema.
+type Format struct {
+	Pointer string `json:"pointer"`
+	Format  string `json:"format"`
+}
+
+func (r *Format) UnmarshalJSON(b []byte) error {
+	type format Format
+	if err := json.Unmarshal(b, (*format)(r)); err != nil {
+		return err
+	}
+	// the unknown formats are accepted by the validator, hence the misconfiguration is reported here
+	if !gojsonschema.FormatCheckers.Has(r.Format) {
+		return fmt.Errorf("unknown format %q", r.Format)
+	}
+	return nil
+}
+
+func (r *Format) Check(doc interface{}) []FieldError {
+	return checkValues(doc, r.Pointer, "format", func(v interface{}) (string, bool) {
+		if gojsonschema.FormatCheckers.IsFormat(r.Format, v) {
+			return "", true
+		}
+		return fmt.Sprintf("Does not match format '%s'", r.Format), false
+	})
+}
@@ -0,0 +1,154 @@
+/* Copyright 2021 Dmitry Kisler dkisler.com
+
+Licensed under the Apache License,Version 2.0 (the "License");
+you may not use this file except in compliance with the License. You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED,
+INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE,
+AND NONINFRINGEMENT. IN NO EVENT WILL THE LICENSOR OR OTHER CONTRIBUTORS BE LIABLE FOR ANY CLAIM, DAMAGES,
+OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF,
+OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+See the License for the specific language governing permissions and limitations under the License.
+*/
+
+package jsonschema_test
+
+import (
+	"fmt"
+	"platform/lib/jsonschema"
+	"strings"
+	"testing"
+	"time"
+)
+
+const ruleSets = `{
+	"default": [
+		{"rule": "time_window", "pointer": "/time_stamp", "max_age": "24h", "max_ahead": "5m"},
+		{"rule": "bounds", "pointer": "/data", "min": -100, "max": 100},
+		{"rule": "max_items", "pointer": "/data", "max": 20},
+		{"rule": "sentinel", "pointer": "/data", "values": [-99]},
+		{"rule": "constant", "pointer": "/data", "min_items": 3}
+	],
+	"submitters": {
+		"lenient": [],
+		"recent": [
+			{"rule": "time_window", "pointer": "/time_stamp", "not_before": "2000-01-01T00:00:00Z"}
+		]
+	}
+}`
+
+func TestRules(t *testing.T) {
+	sets, err := jsonschema.ParseRuleSets([]byte(ruleSets))
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now().UTC()
+	doc := func(ts time.Time, data string) string {
+		return fmt.Sprintf(`{"time_stamp": "%s", "data": %s}`, ts.Format(time.RFC3339), data)
+	}
+
+	tests := []struct {
+		submitter string
+		in        string
+		want      []string
+	}{
+		{in: doc(now, `[1, 2]`)},
+		{in: doc(now.Add(-48*time.Hour), `[1, 2]`), want: []string{"/time_stamp timeWindow"}},
+		{in: doc(now.Add(time.Hour), `[1, 2]`), want: []string{"/time_stamp timeWindow"}},
+		{in: doc(time.Unix(0, 0), `[1, 2]`), want: []string{"/time_stamp timeWindow"}},
+		{in: doc(now, `[1, 200, -99]`), want: []string{"/data/1 bounds", "/data/2 sentinel"}},
+		{in: doc(now, `[5, 5, 5]`), want: []string{"/data constant"}},
+		{in: doc(now, `[5, 5]`)},
+		{
+			in:   doc(now, `[`+strings.Repeat("1000, ", 20)+`1]`),
+			want: []string{"/data/0 bounds", "/data/1 bounds", "/data/2 bounds", "/data/3 bounds", "/data/4 bounds", "/data/5 bounds", "/data/6 bounds", "/data/7 bounds", "/data/8 bounds", "/data/9 bounds", "/data bounds", "/data maxItems"},
+		},
+		{submitter: "lenient", in: doc(time.Unix(0, 0), `[-99]`)},
+		{submitter: "recent", in: doc(now.AddDate(-10, 0, 0), `[1, 2]`)},
+		{submitter: "recent", in: doc(time.Date(1999, 12, 31, 0, 0, 0, 0, time.UTC), `[1, 2]`), want: []string{"/time_stamp timeWindow"}},
+	}
+
+	for _, test := range tests {
+		err := sets.For(test.submitter).Validate([]byte(test.in))
+		got := []string{}
+		if err != nil {
+			for _, e := range jsonschema.FieldErrors(err) {
+				got = append(got, e.Pointer+" "+e.Keyword)
+			}
+		}
+		if strings.Join(got, ",") != strings.Join(test.want, ",") {
+			t.Fatalf("error for %s %s!\nwant: %v\ngot: %v", test.submitter, test.in, test.want, got)
+		}
+	}
+}
+
+func TestParseRuleSets(t *testing.T) {
+	tests := []string{
+		`{"default": [{"rule": "unknown"}]}`,
+		`{"default": [{"rule": "max_items", "pointer": "data"}]}`,
+		`{"default": [{"rule": "time_window", "max_age": "1 day"}]}`,
+		`{"default": [{"rule": "time_window", "not_before": "2000"}]}`,
+		`{"default": [{"rule": "format", "pointer": "/id", "format": "unknown"}]}`,
+		`null`,
+	}
+	for _, test := range tests {
+		if _, err := jsonschema.ParseRuleSets([]byte(test)); err == nil {
+			t.Fatalf("error for %s!\nwant: error\ngot: nil", test)
+		}
+	}
+}
+
+func TestFormatRule(t *testing.T) {
+	jsonschema.AddFormat("lowercase", func(input interface{}) bool {
+		v, ok := input.(string)
+		return !ok || v == strings.ToLower(v)
+	})
+	sets, err := jsonschema.ParseRuleSets([]byte(`{"default": [
+		{"rule": "format", "pointer": "/id", "format": "lowercase"},
+		{"rule": "format", "pointer": "/tags", "format": "lowercase"}
+	]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		in   string
+		want []string
+	}{
+		{in: `{"id": "a", "tags": ["b", "c"]}`},
+		// the missing fields, and the values of the other types are not checked
+		{in: `{"tags": [1]}`},
+		{in: `{"id": "A", "tags": ["b", "C"]}`, want: []string{"/id format", "/tags/1 format"}},
+	}
+	for _, test := range tests {
+		err := sets.For("").Validate([]byte(test.in))
+		got := []string{}
+		if err != nil {
+			for _, e := range jsonschema.FieldErrors(err) {
+				got = append(got, e.Pointer+" "+e.Keyword)
+			}
+		}
+		if strings.Join(got, ",") != strings.Join(test.want, ",") {
+			t.Fatalf("error for %s!\nwant: %v\ngot: %v", test.in, test.want, got)
+		}
+	}
+}
+
+func TestAddFormat(t *testing.T) {
+	jsonschema.AddFormat("even", func(input interface{}) bool {
+		v, ok := input.(float64)
+		return !ok || int(v)%2 == 0
+	})
+	schema, err := jsonschema.NewSchema([]byte(`{"type": "integer", "format": "even"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := schema.ValidateBytes([]byte(`2`)); err != nil {
+		t.Fatalf("error!\nwant: nil\ngot: %v", err)
+	}
+	if err := schema.ValidateBytes([]byte(`3`)); err == nil {
+		t.Fatal("error!\nwant: error\ngot: nil")
+	}
+}
@@ -0,0 +1,278 @@
+/* Copyright 2021 Dmitry Kisler dkisler.com
+
+Licensed under the Apache License,Version 2.0 (the "License");
+you may not use this file except in compliance with the License. You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED,
+INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE,
+AND NONINFRINGEMENT. IN NO EVENT WILL THE LICENSOR OR OTHER CONTRIBUTORS BE LIABLE FOR ANY CLAIM, DAMAGES,
+OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF,
+OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+See the License for the specific language governing permissions and limitations under the License.
+
+Package defines the structured logger writing JSON lines compatible with Cloud Logging.
+
+Every line has the fields:
+	- severity: DEBUG, INFO, WARNING, ERROR or CRITICAL
+	- message
+	- time: RFC3339 timestamp
+	- logging.googleapis.com/sourceLocation: the file, line and function of the call
+plus the key-value pairs attached to the logger, e.g. the request and submission IDs.
+See: https://cloud.google.com/logging/docs/structured-logging
+*/
+
+package logging
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/goccy/go-json"
+)
+
+// Level defines the log severity.
+type Level int
+
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarning
+	LevelError
+	LevelCritical
+)
+
+var levelNames = map[Level]string{
+	LevelDebug:    "DEBUG",
+	LevelInfo:     "INFO",
+	LevelWarning:  "WARNING",
+	LevelError:    "ERROR",
+	LevelCritical: "CRITICAL",
+}
+
+func (l Level) String() string {
+	return levelNames[l]
+}
+
+// ParseLevel parses the level name case-insensitively, e.g. "info" or "WARNING".
+// The level defaults to info if the name is empty.
+func ParseLevel(s string) (Level, error) {
+	switch strings.ToUpper(s) {
+	case "":
+		return LevelInfo, nil
+	case "WARN":
+		return LevelWarning, nil
+	}
+	for l, name := range levelNames {
+		if strings.ToUpper(s) == name {
+			return l, nil
+		}
+	}
+	return LevelInfo, fmt.Errorf("unknown log level %s", s)
+}
+
+// Config contains configuration for the logger.
+type Config struct {
+	level     Level
+	projectID string
+}
+
+// NewConfig return configuration for the logger with the info level.
+func NewConfig() *Config {
+	return &Config{level: LevelInfo}
+}
+
+// WithLevel sets the minimal level of the lines to be written.
+func (c *Config) WithLevel(l Level) *Config {
+	c.level = l
+	return c
+}
+
+// WithProjectID sets the GCP project ID to correlate the lines with the traces in Cloud Trace.
+func (c *Config) WithProjectID(id string) *Config {
+	c.projectID = id
+	return c
+}
+
+// output defines the destination shared by the logger and its children.
+type output struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// Logger defines the structured logger.
+// The logger is safe for concurrent use.
+type Logger struct {
+	out *output
+	cfg Config
+	// attrs defines the JSON encoded key-value pairs attached to the logger, prefixed with commas
+	attrs []byte
+}
+
+// New initiates the logger writing to w.
+func New(w io.Writer, cfg *Config) *Logger {
+	return &Logger{out: &output{w: w}, cfg: *cfg}
+}
+
+var std atomic.Value
+
+func init() {
+	std.Store(New(os.Stderr, NewConfig()))
+}
+
+// Default returns the process-wide logger, it writes to stderr with the info level by default.
+func Default() *Logger {
+	return std.Load().(*Logger)
+}
+
+// SetDefault sets the process-wide logger.
+func SetDefault(l *Logger) {
+	std.Store(l)
+}
+
+type loggerKey struct{}
+
+// NewContext returns the context carrying the logger.
+func NewContext(ctx context.Context, l *Logger) context.Context {
+	return context.WithValue(ctx, loggerKey{}, l)
+}
+
+// FromContext returns the logger carried by the context, or the default logger.
+func FromContext(ctx context.Context) *Logger {
+	if l, ok := ctx.Value(loggerKey{}).(*Logger); ok {
+		return l
+	}
+	return Default()
+}
+
+// With returns the child logger with the key-value pairs attached to every line,
+// e.g. l.With("submission_id", id). The odd argument is attached with the key "!BADKEY".
+func (l *Logger) With(kv ...interface{}) *Logger {
+	var buf bytes.Buffer
+	buf.Write(l.attrs)
+	appendAttrs(&buf, kv)
+	return &Logger{out: l.out, cfg: l.cfg, attrs: buf.Bytes()}
+}
+
+// WithTrace returns the child logger with the lines correlated to the trace span.
+// The trace is identified by the Cloud Logging special fields if the project ID is configured.
+func (l *Logger) WithTrace(traceID, spanID string, sampled bool) *Logger {
+	if l.cfg.projectID == "" {
+		return l.With("trace_id", traceID, "span_id", spanID)
+	}
+	return l.With(
+		"logging.googleapis.com/trace", fmt.Sprintf("projects/%s/traces/%s", l.cfg.projectID, traceID),
+		"logging.googleapis.com/spanId", spanID,
+		"logging.googleapis.com/trace_sampled", sampled,
+	)
+}
+
+// Enabled checks if the lines of the level are written.
+func (l *Logger) Enabled(level Level) bool {
+	return level >= l.cfg.level
+}
+
+// Debug writes the line with the DEBUG severity.
+func (l *Logger) Debug(msg string, kv ...interface{}) {
+	l.log(LevelDebug, msg, kv)
+}
+
+// Info writes the line with the INFO severity.
+func (l *Logger) Info(msg string, kv ...interface{}) {
+	l.log(LevelInfo, msg, kv)
+}
+
+// Warning writes the line with the WARNING severity.
+func (l *Logger) Warning(msg string, kv ...interface{}) {
+	l.log(LevelWarning, msg, kv)
+}
+
+// Error writes the line with the ERROR severity.
+func (l *Logger) Error(msg string, kv ...interface{}) {
+	l.log(LevelError, msg, kv)
+}
+
+// Fatal writes the line with the CRITICAL severity and exits with the status code 1.
+func (l *Logger) Fatal(msg string, kv ...interface{}) {
+	l.log(LevelCritical, msg, kv)
+	os.Exit(1)
+}
+
+// callerDepth defines the number of frames between the caller and the method log.
+const callerDepth = 3
+
+func (l *Logger) log(level Level, msg string, kv []interface{}) {
+	if !l.Enabled(level) {
+		return
+	}
+	var buf bytes.Buffer
+	buf.WriteString(`{"severity":`)
+	appendValue(&buf, level.String())
+	buf.WriteString(`,"message":`)
+	appendValue(&buf, msg)
+	buf.WriteString(`,"time":`)
+	appendValue(&buf, time.Now().UTC().Format(time.RFC3339Nano))
+	if pc, file, line, ok := runtime.Caller(callerDepth - 1); ok {
+		buf.WriteString(`,"logging.googleapis.com/sourceLocation":{"file":`)
+		appendValue(&buf, file)
+		fmt.Fprintf(&buf, `,"line":"%d"`, line)
+		if fn := runtime.FuncForPC(pc); fn != nil {
+			buf.WriteString(`,"function":`)
+			appendValue(&buf, fn.Name())
+		}
+		buf.WriteByte('}')
+	}
+	buf.Write(l.attrs)
+	appendAttrs(&buf, kv)
+	buf.WriteString("}\n")
+
+	l.out.mu.Lock()
+	defer l.out.mu.Unlock()
+	l.out.w.Write(buf.Bytes())
+}
+
+// appendAttrs appends the key-value pairs prefixed with commas.
+func appendAttrs(buf *bytes.Buffer, kv []interface{}) {
+	for i := 0; i < len(kv); i += 2 {
+		var key string
+		var value interface{}
+		if i+1 < len(kv) {
+			key, value = fmt.Sprint(kv[i]), kv[i+1]
+		} else {
+			key, value = "!BADKEY", kv[i]
+		}
+		buf.WriteByte(',')
+		appendValue(buf, key)
+		buf.WriteByte(':')
+		appendValue(buf, value)
+	}
+}
+
+// appendValue appends the JSON encoded value.
+// The errors, durations and the values implementing fmt.Stringer are encoded as strings.
+func appendValue(buf *bytes.Buffer, v interface{}) {
+	switch t := v.(type) {
+	case time.Time:
+		// encoded as RFC3339
+	case error:
+		v = t.Error()
+	case time.Duration:
+		v = t.String()
+	case fmt.Stringer:
+		v = t.String()
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		b, _ = json.Marshal(fmt.Sprint(v))
+	}
+	buf.Write(b)
+}
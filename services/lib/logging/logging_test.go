@@ -0,0 +1,123 @@
+/* Copyright 2021 Dmitry Kisler dkisler.com
+
+Licensed under the Apache License,Version 2.0 (the "License");
+you may not use this file except in compliance with the License. You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED,
+INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE,
+AND NONINFRINGEMENT. IN NO EVENT WILL THE LICENSOR OR OTHER CONTRIBUTORS BE LIABLE FOR ANY CLAIM, DAMAGES,
+OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF,
+OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+See the License for the specific language governing permissions and limitations under the License.
+*/
+
+package logging_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"platform/lib/logging"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseLevel(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    logging.Level
+		wantErr bool
+	}{
+		{"", logging.LevelInfo, false},
+		{"debug", logging.LevelDebug, false},
+		{"WARN", logging.LevelWarning, false},
+		{"Warning", logging.LevelWarning, false},
+		{"error", logging.LevelError, false},
+		{"critical", logging.LevelCritical, false},
+		{"verbose", logging.LevelInfo, true},
+	}
+	for _, test := range tests {
+		got, err := logging.ParseLevel(test.in)
+		if got != test.want || (err != nil) != test.wantErr {
+			t.Fatalf("error for %q!\nwant: %v, error: %v\ngot: %v, %v", test.in, test.want, test.wantErr, got, err)
+		}
+	}
+}
+
+func decode(t *testing.T, buf *bytes.Buffer) []map[string]interface{} {
+	o := []map[string]interface{}{}
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		if line == "" {
+			continue
+		}
+		var v map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &v); err != nil {
+			t.Fatalf("error!\nwant: JSON line\ngot: %v", line)
+		}
+		o = append(o, v)
+	}
+	return o
+}
+
+func TestLogger(t *testing.T) {
+	var buf bytes.Buffer
+	l := logging.New(&buf, logging.NewConfig().WithLevel(logging.LevelInfo)).
+		With("request_id", "r1", "route", "/")
+	ctx := logging.NewContext(context.Background(), l.With("submission_id", "s1"))
+
+	l.Debug("skipped")
+	logging.FromContext(ctx).Error("failed", "error", errors.New("boom"), "took", time.Second, "odd")
+
+	lines := decode(t, &buf)
+	if len(lines) != 1 {
+		t.Fatalf("error!\nwant: 1 line\ngot: %v", buf.String())
+	}
+	got := lines[0]
+	loc, _ := got["logging.googleapis.com/sourceLocation"].(map[string]interface{})
+	if !strings.HasSuffix(loc["file"].(string), "logging_test.go") {
+		t.Fatalf("error!\nwant: %v\ngot: %v", "logging_test.go", loc)
+	}
+	delete(got, "logging.googleapis.com/sourceLocation")
+	if _, err := time.Parse(time.RFC3339Nano, got["time"].(string)); err != nil {
+		t.Fatal(err)
+	}
+	delete(got, "time")
+	want := map[string]interface{}{
+		"severity":      "ERROR",
+		"message":       "failed",
+		"request_id":    "r1",
+		"route":         "/",
+		"submission_id": "s1",
+		"error":         "boom",
+		"took":          "1s",
+		"!BADKEY":       "odd",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("error!\nwant: %v\ngot: %v", want, got)
+	}
+}
+
+func TestWithTrace(t *testing.T) {
+	var buf bytes.Buffer
+	logging.New(&buf, logging.NewConfig().WithProjectID("p")).WithTrace("t1", "s1", true).Info("msg")
+	logging.New(&buf, logging.NewConfig()).WithTrace("t1", "s1", true).Info("msg")
+
+	lines := decode(t, &buf)
+	if got := lines[0]["logging.googleapis.com/trace"]; got != "projects/p/traces/t1" {
+		t.Fatalf("error!\nwant: %v\ngot: %v", "projects/p/traces/t1", got)
+	}
+	if got := lines[1]["trace_id"]; got != "t1" {
+		t.Fatalf("error!\nwant: %v\ngot: %v", "t1", got)
+	}
+}
+
+func TestFromContextDefault(t *testing.T) {
+	if logging.FromContext(context.Background()) != logging.Default() {
+		t.Fatal("error!\nwant: default logger\ngot: another logger")
+	}
+}
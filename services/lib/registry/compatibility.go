@@ -0,0 +1,312 @@
+/* Copyright 2021 Dmitry Kisler dkisler.com
+
+Licensed under the Apache License,Version 2.0 (the "License");
+you may not use this file except in compliance with the License. You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED,
+INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE,
+AND NONINFRINGEMENT. IN NO EVENT WILL THE LICENSOR OR OTHER CONTRIBUTORS BE LIABLE FOR ANY CLAIM, DAMAGES,
+OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF,
+OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+See the License for the specific language governing permissions and limitations under the License.
+*/
+
+package registry
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/goccy/go-json"
+)
+
+// IncompatibilityError defines the differences making the schema versions incompatible.
+type IncompatibilityError struct {
+	// Issues defines the differences prefixed with the JSON Pointer to the schema element.
+	Issues []string
+}
+
+func (e *IncompatibilityError) Error() string {
+	return strings.Join(e.Issues, "; ")
+}
+
+// checkCompatibility checks the compatibility of the new schema version with the registered one.
+func checkCompatibility(c Compatibility, registered, next []byte) error {
+	var old, new map[string]interface{}
+	if err := json.Unmarshal(registered, &old); err != nil {
+		return err
+	}
+	if err := json.Unmarshal(next, &new); err != nil {
+		return err
+	}
+	ch := &checker{}
+	switch c {
+	case CompatibilityNone:
+	case CompatibilityBackward:
+		ch.check("", new, old)
+	case CompatibilityForward:
+		ch.check("", old, new)
+	case CompatibilityFull:
+		ch.check("", new, old)
+		ch.check("", old, new)
+	default:
+		return fmt.Errorf("unknown compatibility %q", c)
+	}
+	if len(ch.issues) > 0 {
+		return &IncompatibilityError{Issues: ch.issues}
+	}
+	return nil
+}
+
+// annotations defines the keywords which do not affect the validation.
+var annotations = map[string]bool{
+	"$schema": true, "$id": true, "$comment": true, "title": true, "description": true,
+	"default": true, "examples": true, "readOnly": true, "writeOnly": true,
+	// the keyword only applies to the items defined as an array of schemas, which is compared as a whole
+	"additionalItems": true,
+}
+
+// checked defines the keywords compared by the checker, the other keywords must be equal.
+var checked = map[string]bool{
+	"type": true, "enum": true, "const": true, "required": true, "properties": true,
+	"additionalProperties": true, "items": true,
+}
+
+// lowerBounds and upperBounds define the keywords limiting the values,
+// the reader's limit must not be stricter than the writer's one.
+var (
+	lowerBounds = []string{"minimum", "exclusiveMinimum", "minLength", "minItems", "minProperties"}
+	upperBounds = []string{"maximum", "exclusiveMaximum", "maxLength", "maxItems", "maxProperties"}
+)
+
+// checker checks that the reader schema accepts the documents valid against the writer schema.
+// The optional properties known to the reader only are assumed to be absent in the writer's documents,
+// so the optional properties can be added to and removed from the schema.
+type checker struct {
+	issues []string
+}
+
+func (c *checker) fail(pointer, format string, args ...interface{}) {
+	c.issues = append(c.issues, fmt.Sprintf("%s: %s", pointerOrRoot(pointer), fmt.Sprintf(format, args...)))
+}
+
+func pointerOrRoot(pointer string) string {
+	if pointer == "" {
+		return "(root)"
+	}
+	return pointer
+}
+
+// check compares the reader and the writer schemas found at the pointer.
+func (c *checker) check(pointer string, reader, writer interface{}) {
+	r, rOK := reader.(map[string]interface{})
+	w, wOK := writer.(map[string]interface{})
+	if rb, ok := reader.(bool); ok {
+		r, rOK = map[string]interface{}{}, rb
+		if !rb {
+			r["not"] = map[string]interface{}{}
+		}
+	}
+	if wb, ok := writer.(bool); ok {
+		w, wOK = map[string]interface{}{}, wb
+		if !wb {
+			// nothing is valid against the writer schema
+			return
+		}
+	}
+	if !rOK || !wOK {
+		c.fail(pointer, "invalid schema")
+		return
+	}
+
+	c.checkTypes(pointer, r, w)
+	c.checkValues(pointer, r, w)
+	c.checkBounds(pointer, r, w)
+	c.checkObject(pointer, r, w)
+	if ri, ok := r["items"]; ok {
+		wi, ok := w["items"]
+		if !ok {
+			wi = true
+		}
+		if _, tuple := ri.([]interface{}); tuple {
+			if !reflect.DeepEqual(ri, wi) || !reflect.DeepEqual(r["additionalItems"], w["additionalItems"]) {
+				c.fail(pointer, "items changed")
+			}
+		} else {
+			c.check(pointer+"/items", ri, wi)
+		}
+	}
+
+	// the keywords only restrict the valid documents, so the writer's keywords unknown to the reader are compatible
+	keys := []string{}
+	for k := range r {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		if annotations[k] || checked[k] || isBound(k) {
+			continue
+		}
+		if !reflect.DeepEqual(r[k], w[k]) {
+			c.fail(pointer, "keyword %s changed", k)
+		}
+	}
+}
+
+func isBound(k string) bool {
+	for _, b := range append(lowerBounds, upperBounds...) {
+		if b == k {
+			return true
+		}
+	}
+	return false
+}
+
+// types returns the set of the allowed types, nil if any type is allowed.
+func types(s map[string]interface{}) map[string]bool {
+	o := map[string]bool{}
+	switch t := s["type"].(type) {
+	case string:
+		o[t] = true
+	case []interface{}:
+		for _, i := range t {
+			if s, ok := i.(string); ok {
+				o[s] = true
+			}
+		}
+	default:
+		return nil
+	}
+	return o
+}
+
+func (c *checker) checkTypes(pointer string, r, w map[string]interface{}) {
+	rt, wt := types(r), types(w)
+	if rt == nil {
+		return
+	}
+	if wt == nil {
+		c.fail(pointer, "type restricted")
+		return
+	}
+	for t := range wt {
+		if !rt[t] && !(t == "integer" && rt["number"]) {
+			c.fail(pointer, "type %s is not allowed", t)
+		}
+	}
+}
+
+// values returns the allowed values, nil if not restricted.
+func values(s map[string]interface{}) []interface{} {
+	if v, ok := s["const"]; ok {
+		return []interface{}{v}
+	}
+	if v, ok := s["enum"].([]interface{}); ok {
+		return v
+	}
+	return nil
+}
+
+func (c *checker) checkValues(pointer string, r, w map[string]interface{}) {
+	rv, wv := values(r), values(w)
+	if rv == nil {
+		return
+	}
+	if wv == nil {
+		c.fail(pointer, "values restricted")
+		return
+	}
+	for _, v := range wv {
+		found := false
+		for _, i := range rv {
+			if reflect.DeepEqual(v, i) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			b, _ := json.Marshal(v)
+			c.fail(pointer, "value %s is not allowed", b)
+		}
+	}
+}
+
+func (c *checker) checkBounds(pointer string, r, w map[string]interface{}) {
+	check := func(keywords []string, stricter func(r, w float64) bool) {
+		for _, k := range keywords {
+			rb, ok := r[k].(float64)
+			if !ok {
+				continue
+			}
+			wb, ok := w[k].(float64)
+			if !ok || stricter(rb, wb) {
+				c.fail(pointer, "%s restricted", k)
+			}
+		}
+	}
+	check(lowerBounds, func(r, w float64) bool { return r > w })
+	check(upperBounds, func(r, w float64) bool { return r < w })
+}
+
+func (c *checker) checkObject(pointer string, r, w map[string]interface{}) {
+	wRequired := map[string]bool{}
+	if req, ok := w["required"].([]interface{}); ok {
+		for _, i := range req {
+			if s, ok := i.(string); ok {
+				wRequired[s] = true
+			}
+		}
+	}
+	if req, ok := r["required"].([]interface{}); ok {
+		for _, i := range req {
+			if s, ok := i.(string); ok && !wRequired[s] {
+				c.fail(pointer, "property %s is required", s)
+			}
+		}
+	}
+
+	rp, _ := r["properties"].(map[string]interface{})
+	wp, _ := w["properties"].(map[string]interface{})
+	rAdditional, ok := r["additionalProperties"]
+	if !ok {
+		rAdditional = true
+	}
+	wAdditional, ok := w["additionalProperties"]
+	if !ok {
+		wAdditional = true
+	}
+	names := []string{}
+	for k := range rp {
+		names = append(names, k)
+	}
+	for k := range wp {
+		if _, ok := rp[k]; !ok {
+			names = append(names, k)
+		}
+	}
+	sort.Strings(names)
+	for _, k := range names {
+		p := pointer + "/properties/" + escape(k)
+		rs, rOK := rp[k]
+		ws, wOK := wp[k]
+		switch {
+		case rOK && wOK:
+			c.check(p, rs, ws)
+		case wOK:
+			c.check(p, rAdditional, ws)
+		}
+	}
+	// the undeclared properties of the writer must be accepted by the reader
+	if !reflect.DeepEqual(rAdditional, wAdditional) && rAdditional != true && wAdditional != false {
+		c.fail(pointer, "additionalProperties restricted")
+	}
+}
+
+// escape escapes the JSON Pointer reference token.
+func escape(s string) string {
+	return strings.NewReplacer("~", "~0", "/", "~1").Replace(s)
+}
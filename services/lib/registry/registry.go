@@ -0,0 +1,196 @@
+/* Copyright 2021 Dmitry Kisler dkisler.com
+
+Licensed under the Apache License,Version 2.0 (the "License");
+you may not use this file except in compliance with the License. You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED,
+INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE,
+AND NONINFRINGEMENT. IN NO EVENT WILL THE LICENSOR OR OTHER CONTRIBUTORS BE LIABLE FOR ANY CLAIM, DAMAGES,
+OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF,
+OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+See the License for the specific language governing permissions and limitations under the License.
+
+Package defines the registry of the versioned JSON schemas.
+The new schema version is registered only if it's compatible with the registered versions.
+*/
+
+package registry
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"platform/lib/jsonschema"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/goccy/go-json"
+)
+
+// Compatibility defines the compatibility required between the schema versions.
+type Compatibility string
+
+const (
+	// CompatibilityNone defines that the versions are not checked.
+	CompatibilityNone Compatibility = "none"
+	// CompatibilityBackward defines that the new version accepts the documents of the registered versions,
+	// i.e. the consumers are upgraded first.
+	CompatibilityBackward Compatibility = "backward"
+	// CompatibilityForward defines that the registered versions accept the documents of the new version,
+	// i.e. the producers are upgraded first.
+	CompatibilityForward Compatibility = "forward"
+	// CompatibilityFull defines that the versions are backward and forward compatible.
+	CompatibilityFull Compatibility = "full"
+)
+
+const (
+	// VersionHeader defines the request header to declare the schema version of the payload.
+	VersionHeader = "Schema-Version"
+	// VersionField defines the document field to declare the schema version of the document.
+	VersionField = "schema_version"
+)
+
+// Version defines the registered schema version.
+type Version struct {
+	Version int
+	// JSON defines the schema definition.
+	JSON   []byte
+	Schema *jsonschema.Schema
+}
+
+// Registry defines the registry of the schema versions of a subject.
+type Registry struct {
+	Subject       string
+	Compatibility Compatibility
+	versions      []*Version
+}
+
+// New defines the empty Registry.
+func New(subject string, compatibility Compatibility) *Registry {
+	return &Registry{Subject: subject, Compatibility: compatibility}
+}
+
+// Register checks the compatibility of the schema with all registered versions, and registers it as the next version.
+func (r *Registry) Register(def []byte) (*Version, error) {
+	schema, err := jsonschema.NewSchema(def)
+	if err != nil {
+		return nil, fmt.Errorf("%s: invalid schema: %w", r.Subject, err)
+	}
+	next := len(r.versions) + 1
+	for _, v := range r.versions {
+		if err := checkCompatibility(r.Compatibility, v.JSON, def); err != nil {
+			return nil, fmt.Errorf("%s: version %d is incompatible with version %d: %w", r.Subject, next, v.Version, err)
+		}
+	}
+	v := &Version{Version: next, JSON: def, Schema: schema}
+	r.versions = append(r.versions, v)
+	return v, nil
+}
+
+// Versions returns the registered versions in ascending order.
+func (r *Registry) Versions() []*Version {
+	return append([]*Version{}, r.versions...)
+}
+
+// Version returns the registered version.
+func (r *Registry) Version(version int) (*Version, bool) {
+	if version < 1 || version > len(r.versions) {
+		return nil, false
+	}
+	return r.versions[version-1], true
+}
+
+// Latest returns the latest registered version.
+func (r *Registry) Latest() *Version {
+	if len(r.versions) == 0 {
+		return nil
+	}
+	return r.versions[len(r.versions)-1]
+}
+
+// Resolve identifies the schema version declared by the header value, or by the VersionField of the document.
+// The document which does not declare the version is defined by the first version,
+// i.e. by the schema the documents were produced with before the versioning was introduced.
+func (r *Registry) Resolve(header string, doc []byte) (*Version, error) {
+	version := 0
+	if header != "" {
+		v, err := strconv.Atoi(strings.TrimSpace(header))
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s header %q", VersionHeader, header)
+		}
+		version = v
+	}
+	// the malformed document is reported by its validation
+	var d map[string]json.RawMessage
+	if json.Unmarshal(doc, &d) == nil && d[VersionField] != nil {
+		var v int
+		if err := json.Unmarshal(d[VersionField], &v); err != nil {
+			return nil, fmt.Errorf("invalid %s field %s", VersionField, d[VersionField])
+		}
+		if version != 0 && v != version {
+			return nil, fmt.Errorf("%s header %d does not match the %s field %d", VersionHeader, version, VersionField, v)
+		}
+		version = v
+	}
+	if version == 0 {
+		version = 1
+	}
+	v, ok := r.Version(version)
+	if !ok {
+		return nil, fmt.Errorf("unknown %s schema version %d", r.Subject, version)
+	}
+	return v, nil
+}
+
+// Load registers the schema versions stored in the directory of the file system as v{version}.json files.
+// The versions must be numbered sequentially starting from 1.
+func Load(fsys fs.FS, dir, subject string, compatibility Compatibility) (*Registry, error) {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, err
+	}
+	files := map[int]string{}
+	versions := []int{}
+	for _, e := range entries {
+		var v int
+		if e.IsDir() {
+			continue
+		}
+		if _, err := fmt.Sscanf(e.Name(), "v%d.json", &v); err != nil || e.Name() != fmt.Sprintf("v%d.json", v) {
+			return nil, fmt.Errorf("%s: unexpected file %s", subject, e.Name())
+		}
+		files[v] = e.Name()
+		versions = append(versions, v)
+	}
+	sort.Ints(versions)
+	r := New(subject, compatibility)
+	for i, v := range versions {
+		if v != i+1 {
+			return nil, fmt.Errorf("%s: version %d is missing", subject, i+1)
+		}
+		def, err := fs.ReadFile(fsys, path.Join(dir, files[v]))
+		if err != nil {
+			return nil, err
+		}
+		if _, err := r.Register(def); err != nil {
+			return nil, err
+		}
+	}
+	if len(r.versions) == 0 {
+		return nil, errors.New(subject + ": no schema versions")
+	}
+	return r, nil
+}
+
+// MustLoad registers the schema versions like Load, and panics on error.
+func MustLoad(fsys fs.FS, dir, subject string, compatibility Compatibility) *Registry {
+	r, err := Load(fsys, dir, subject, compatibility)
+	if err != nil {
+		panic(err)
+	}
+	return r
+}
@@ -0,0 +1,180 @@
+/* Copyright 2021 Dmitry Kisler dkisler.com
+
+Licensed under the Apache License,Version 2.0 (the "License");
+you may not use this file except in compliance with the License. You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED,
+INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE,
+AND NONINFRINGEMENT. IN NO EVENT WILL THE LICENSOR OR OTHER CONTRIBUTORS BE LIABLE FOR ANY CLAIM, DAMAGES,
+OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF,
+OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+See the License for the specific language governing permissions and limitations under the License.
+*/
+
+package registry_test
+
+import (
+	"platform/lib/registry"
+	"platform/lib/registry/submission"
+	"testing"
+	"testing/fstest"
+)
+
+const base = `{
+	"type": "object",
+	"required": ["time_stamp", "data"],
+	"properties": {
+		"time_stamp": {"type": "string", "format": "date-time"},
+		"data": {"type": "array", "items": {"type": "number"}, "minItems": 1}
+	}
+}`
+
+func TestRegister(t *testing.T) {
+	tests := []struct {
+		name          string
+		next          string
+		compatibility registry.Compatibility
+		wantErr       bool
+	}{
+		{
+			name:          "optional property added",
+			next:          `{"type": "object", "required": ["time_stamp", "data"], "properties": {"data": {"type": "array", "minItems": 1, "items": {"type": "number"}}, "time_stamp": {"type": "string", "format": "date-time"}, "units": {"type": "string"}}}`,
+			compatibility: registry.CompatibilityFull,
+		},
+		{
+			name:          "required property added",
+			next:          `{"type": "object", "required": ["time_stamp", "data", "units"], "properties": {"data": {"type": "array", "minItems": 1, "items": {"type": "number"}}, "time_stamp": {"type": "string", "format": "date-time"}, "units": {"type": "string"}}}`,
+			compatibility: registry.CompatibilityBackward,
+			wantErr:       true,
+		},
+		{
+			name:          "required property added, forward",
+			next:          `{"type": "object", "required": ["time_stamp", "data", "units"], "properties": {"data": {"type": "array", "minItems": 1, "items": {"type": "number"}}, "time_stamp": {"type": "string", "format": "date-time"}, "units": {"type": "string"}}}`,
+			compatibility: registry.CompatibilityForward,
+		},
+		{
+			name:          "required property removed",
+			next:          `{"type": "object", "required": ["data"], "properties": {"data": {"type": "array", "minItems": 1, "items": {"type": "number"}}, "time_stamp": {"type": "string", "format": "date-time"}}}`,
+			compatibility: registry.CompatibilityBackward,
+		},
+		{
+			name:          "required property removed, full",
+			next:          `{"type": "object", "required": ["data"], "properties": {"data": {"type": "array", "minItems": 1, "items": {"type": "number"}}, "time_stamp": {"type": "string", "format": "date-time"}}}`,
+			compatibility: registry.CompatibilityFull,
+			wantErr:       true,
+		},
+		{
+			name:          "type narrowed",
+			next:          `{"type": "object", "required": ["time_stamp", "data"], "properties": {"data": {"type": "array", "minItems": 1, "items": {"type": "integer"}}, "time_stamp": {"type": "string", "format": "date-time"}}}`,
+			compatibility: registry.CompatibilityBackward,
+			wantErr:       true,
+		},
+		{
+			name:          "type narrowed, forward",
+			next:          `{"type": "object", "required": ["time_stamp", "data"], "properties": {"data": {"type": "array", "minItems": 1, "items": {"type": "integer"}}, "time_stamp": {"type": "string", "format": "date-time"}}}`,
+			compatibility: registry.CompatibilityForward,
+		},
+		{
+			name:          "limit relaxed",
+			next:          `{"type": "object", "required": ["time_stamp", "data"], "properties": {"data": {"type": "array", "items": {"type": "number"}}, "time_stamp": {"type": "string", "format": "date-time"}}}`,
+			compatibility: registry.CompatibilityBackward,
+		},
+		{
+			name:          "limit relaxed, forward",
+			next:          `{"type": "object", "required": ["time_stamp", "data"], "properties": {"data": {"type": "array", "items": {"type": "number"}}, "time_stamp": {"type": "string", "format": "date-time"}}}`,
+			compatibility: registry.CompatibilityForward,
+			wantErr:       true,
+		},
+		{
+			name:          "format changed",
+			next:          `{"type": "object", "required": ["time_stamp", "data"], "properties": {"data": {"type": "array", "minItems": 1, "items": {"type": "number"}}, "time_stamp": {"type": "string", "format": "date"}}}`,
+			compatibility: registry.CompatibilityBackward,
+			wantErr:       true,
+		},
+		{
+			name:          "additional properties forbidden",
+			next:          `{"type": "object", "required": ["time_stamp", "data"], "additionalProperties": false, "properties": {"data": {"type": "array", "minItems": 1, "items": {"type": "number"}}, "time_stamp": {"type": "string", "format": "date-time"}}}`,
+			compatibility: registry.CompatibilityBackward,
+			wantErr:       true,
+		},
+		{
+			name:          "incompatible, not checked",
+			next:          `{"type": "string"}`,
+			compatibility: registry.CompatibilityNone,
+		},
+	}
+
+	for _, test := range tests {
+		r := registry.New("test", test.compatibility)
+		if _, err := r.Register([]byte(base)); err != nil {
+			t.Fatal(err)
+		}
+		v, err := r.Register([]byte(test.next))
+		if (err != nil) != test.wantErr {
+			t.Fatalf("error for %s!\nwant: error %v\ngot: %v", test.name, test.wantErr, err)
+		}
+		if err == nil && (v.Version != 2 || r.Latest() != v) {
+			t.Fatalf("error for %s!\nwant: version 2\ngot: %d", test.name, v.Version)
+		}
+	}
+}
+
+func TestResolve(t *testing.T) {
+	r, err := registry.Load(fstest.MapFS{
+		"schemas/v1.json": {Data: []byte(base)},
+		"schemas/v2.json": {Data: []byte(`{"type": "object", "properties": {"units": {"type": "string"}}}`)},
+	}, "schemas", "test", registry.CompatibilityNone)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		header  string
+		doc     string
+		want    int
+		wantErr bool
+	}{
+		{doc: `{"data": [1]}`, want: 1},
+		{doc: `{`, want: 1},
+		{header: "2", doc: `{"data": [1]}`, want: 2},
+		{doc: `{"schema_version": 2}`, want: 2},
+		{header: "2", doc: `{"schema_version": 2}`, want: 2},
+		{header: "1", doc: `{"schema_version": 2}`, wantErr: true},
+		{header: "3", doc: `{}`, wantErr: true},
+		{header: "v2", doc: `{}`, wantErr: true},
+		{doc: `{"schema_version": "2"}`, wantErr: true},
+	}
+
+	for _, test := range tests {
+		v, err := r.Resolve(test.header, []byte(test.doc))
+		if (err != nil) != test.wantErr {
+			t.Fatalf("error for %q %s!\nwant: error %v\ngot: %v", test.header, test.doc, test.wantErr, err)
+		}
+		if err == nil && v.Version != test.want {
+			t.Fatalf("error for %q %s!\nwant: %d\ngot: %d", test.header, test.doc, test.want, v.Version)
+		}
+	}
+}
+
+func TestLoad(t *testing.T) {
+	tests := []struct {
+		name string
+		fs   fstest.MapFS
+	}{
+		{name: "missing version", fs: fstest.MapFS{"v1.json": {Data: []byte(base)}, "v3.json": {Data: []byte(base)}}},
+		{name: "unexpected file", fs: fstest.MapFS{"v1.json": {Data: []byte(base)}, "latest.json": {Data: []byte(base)}}},
+		{name: "invalid schema", fs: fstest.MapFS{"v1.json": {Data: []byte(`{"type": 1}`)}}},
+		{name: "no versions", fs: fstest.MapFS{"README.md": {Data: []byte(``)}}},
+	}
+
+	for _, test := range tests {
+		if _, err := registry.Load(test.fs, ".", "test", registry.CompatibilityFull); err == nil {
+			t.Fatalf("error for %s!\nwant: error\ngot: nil", test.name)
+		}
+	}
+	if v := submission.Registry.Latest(); v == nil || len(submission.Registry.Versions()) != v.Version {
+		t.Fatalf("error!\nwant: the submission schema versions\ngot: %v", submission.Registry.Versions())
+	}
+}
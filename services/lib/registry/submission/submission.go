@@ -11,22 +11,20 @@ OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING
 OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
 
 See the License for the specific language governing permissions and limitations under the License.
+
+Package defines the versions of the raw data submission schema.
+The versions are stored as v{version}.json, the new version must be fully compatible with the stored versions.
 */
 
-package models
+package submission
 
 import (
-	_ "embed"
-
-	"platform/lib/jsonschema"
+	"embed"
+	"platform/lib/registry"
 )
 
-//go:embed request.json
-var schema []byte
-
-var sh, _ = jsonschema.NewSchema(schema)
+//go:embed v*.json
+var versions embed.FS
 
-// ValidatePayload validates input payload.
-func ValidatePayload(data []byte) error {
-	return sh.ValidateBytes(data)
-}
+// Registry defines the registry of the submission schema versions.
+var Registry = registry.MustLoad(versions, ".", "submission", registry.CompatibilityFull)
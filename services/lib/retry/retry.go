@@ -0,0 +1,306 @@
+/* Copyright 2021 Dmitry Kisler dkisler.com
+
+Licensed under the Apache License,Version 2.0 (the "License");
+you may not use this file except in compliance with the License. You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED,
+INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE,
+AND NONINFRINGEMENT. IN NO EVENT WILL THE LICENSOR OR OTHER CONTRIBUTORS BE LIABLE FOR ANY CLAIM, DAMAGES,
+OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF,
+OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+See the License for the specific language governing permissions and limitations under the License.
+
+Package defines the retry policy with exponential backoff for IO operations.
+
+The operation is retried while it fails with a retryable error, until
+the max number of attempts is reached or the context is done.
+Every attempt is bound by its own deadline.
+The delay between attempts grows exponentially and is randomized with jitter
+to spread retries of concurrent clients.
+*/
+
+package retry
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"google.golang.org/api/googleapi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	maxAttemptsDefault    = 5
+	initialBackoffDefault = 100 * time.Millisecond
+	maxBackoffDefault     = 10 * time.Second
+	multiplierDefault     = 2.
+	jitterDefault         = 0.5
+	timeoutDefault        = 30 * time.Second
+)
+
+// Clock defines the source of time to wait between attempts.
+type Clock interface {
+	// After waits for the duration to elapse and then sends the current time on the returned channel.
+	After(d time.Duration) <-chan time.Time
+}
+
+type systemClock struct{}
+
+func (systemClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+// SystemClock defines the clock based on the system time.
+var SystemClock Clock = systemClock{}
+
+// Classifier defines the function to check if the error is retryable.
+type Classifier func(err error) bool
+
+// Policy defines the retry policy.
+type Policy struct {
+	maxAttempts    int
+	initialBackoff time.Duration
+	maxBackoff     time.Duration
+	multiplier     float64
+	jitter         float64
+	timeout        time.Duration
+	retryable      Classifier
+	clock          Clock
+
+	mu   sync.Mutex
+	rand *rand.Rand
+}
+
+// NewPolicy returns the default retry policy.
+// The operation is attempted up to 5 times with the backoff starting at 100ms,
+// doubling after every attempt and capped at 10s. Every attempt times out after 30s.
+func NewPolicy() *Policy {
+	return &Policy{
+		maxAttempts:    maxAttemptsDefault,
+		initialBackoff: initialBackoffDefault,
+		maxBackoff:     maxBackoffDefault,
+		multiplier:     multiplierDefault,
+		jitter:         jitterDefault,
+		timeout:        timeoutDefault,
+		retryable:      IsRetryable,
+		clock:          SystemClock,
+		rand:           rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+// NoRetry returns the policy to attempt the operation once.
+func NoRetry() *Policy {
+	return NewPolicy().WithMaxAttempts(1)
+}
+
+// WithMaxAttempts sets the max number of attempts, including the first one.
+func (p *Policy) WithMaxAttempts(n int) *Policy {
+	if n < 1 {
+		n = 1
+	}
+	p.maxAttempts = n
+	return p
+}
+
+// WithBackoff sets the initial and the max delay between attempts,
+// and the factor to multiply the delay by after every attempt.
+func (p *Policy) WithBackoff(initial, max time.Duration, multiplier float64) *Policy {
+	p.initialBackoff = initial
+	p.maxBackoff = max
+	if multiplier < 1 {
+		multiplier = 1
+	}
+	p.multiplier = multiplier
+	return p
+}
+
+// WithJitter sets the fraction of the delay to be randomized, it is capped to [0, 1].
+// The delay d is randomized within [d*(1-jitter), d].
+func (p *Policy) WithJitter(jitter float64) *Policy {
+	switch {
+	case jitter < 0:
+		jitter = 0
+	case jitter > 1:
+		jitter = 1
+	}
+	p.jitter = jitter
+	return p
+}
+
+// WithTimeout sets the deadline of every attempt, the non-positive value disables it.
+func (p *Policy) WithTimeout(t time.Duration) *Policy {
+	p.timeout = t
+	return p
+}
+
+// WithClassifier sets the function to check if the error is retryable.
+func (p *Policy) WithClassifier(f Classifier) *Policy {
+	p.retryable = f
+	return p
+}
+
+// WithClock sets the clock to wait between attempts.
+func (p *Policy) WithClock(c Clock) *Policy {
+	p.clock = c
+	return p
+}
+
+// WithSeed sets the seed of the jitter random numbers generator.
+func (p *Policy) WithSeed(seed int64) *Policy {
+	p.rand = rand.New(rand.NewSource(seed))
+	return p
+}
+
+// Backoff returns the delay before the attempt following the given attempt number, starting from 1.
+func (p *Policy) Backoff(attempt int) time.Duration {
+	d := float64(p.initialBackoff)
+	for i := 1; i < attempt && d < float64(p.maxBackoff); i++ {
+		d *= p.multiplier
+	}
+	if d > float64(p.maxBackoff) {
+		d = float64(p.maxBackoff)
+	}
+	if p.jitter > 0 {
+		p.mu.Lock()
+		r := p.rand.Float64()
+		p.mu.Unlock()
+		d -= d * p.jitter * r
+	}
+	return time.Duration(d)
+}
+
+// Do runs the operation until it succeeds, fails with a non-retryable error,
+// the max number of attempts is reached, or the context is done.
+// The error of the last attempt is returned.
+func (p *Policy) Do(ctx context.Context, op func(ctx context.Context) error) error {
+	var err error
+	for attempt := 1; ; attempt++ {
+		err = p.attempt(ctx, op)
+		if err == nil {
+			return nil
+		}
+		if attempt >= p.maxAttempts || ctx.Err() != nil || !p.retryable(err) {
+			return unwrapPermanent(err)
+		}
+		select {
+		case <-ctx.Done():
+			return unwrapPermanent(err)
+		case <-p.clock.After(p.Backoff(attempt)):
+		}
+	}
+}
+
+func (p *Policy) attempt(ctx context.Context, op func(ctx context.Context) error) error {
+	if p.timeout <= 0 {
+		return op(ctx)
+	}
+	ctx, cancel := context.WithTimeout(ctx, p.timeout)
+	defer cancel()
+	return op(ctx)
+}
+
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string { return e.err.Error() }
+func (e *permanentError) Unwrap() error { return e.err }
+
+// Permanent marks the error as non-retryable.
+// Do returns the original error.
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &permanentError{err}
+}
+
+func unwrapPermanent(err error) error {
+	if e, ok := err.(*permanentError); ok {
+		return e.err
+	}
+	return err
+}
+
+type transientError struct {
+	err error
+}
+
+func (e *transientError) Error() string { return e.err.Error() }
+func (e *transientError) Unwrap() error { return e.err }
+
+// Transient marks the error as retryable.
+func Transient(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &transientError{err}
+}
+
+// retryableHTTPCodes defines the HTTP status codes of transient failures.
+var retryableHTTPCodes = map[int]bool{
+	http.StatusRequestTimeout:      true,
+	http.StatusTooManyRequests:     true,
+	http.StatusInternalServerError: true,
+	http.StatusBadGateway:          true,
+	http.StatusServiceUnavailable:  true,
+	http.StatusGatewayTimeout:      true,
+}
+
+// retryableGRPCCodes defines the gRPC status codes of transient failures.
+var retryableGRPCCodes = map[codes.Code]bool{
+	codes.DeadlineExceeded:  true,
+	codes.ResourceExhausted: true,
+	codes.Aborted:           true,
+	codes.Internal:          true,
+	codes.Unavailable:       true,
+	codes.Unknown:           true,
+}
+
+// IsRetryable checks if the error is transient, the default classifier.
+// The errors are classified as follows:
+//   - errors marked with Permanent or Transient
+//   - deadline exceeded, e.g. the attempt timeout: retryable
+//   - context cancellation: not retryable
+//   - Google API errors with the HTTP codes 408, 429, 500, 502, 503, 504: retryable
+//   - gRPC errors with the codes DEADLINE_EXCEEDED, RESOURCE_EXHAUSTED, ABORTED, INTERNAL, UNAVAILABLE, UNKNOWN: retryable
+//   - network errors and unexpected connection termination: retryable
+func IsRetryable(err error) bool {
+	if err == nil {
+		return false
+	}
+	var permanent *permanentError
+	if errors.As(err, &permanent) {
+		return false
+	}
+	var transient *transientError
+	if errors.As(err, &transient) {
+		return true
+	}
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		return true
+	case errors.Is(err, context.Canceled):
+		return false
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		return true
+	}
+	var apiErr *googleapi.Error
+	if errors.As(err, &apiErr) {
+		return retryableHTTPCodes[apiErr.Code]
+	}
+	if s, ok := status.FromError(err); ok && s.Code() != codes.OK {
+		return retryableGRPCCodes[s.Code()]
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
@@ -0,0 +1,183 @@
+/* Copyright 2021 Dmitry Kisler dkisler.com
+
+Licensed under the Apache License,Version 2.0 (the "License");
+you may not use this file except in compliance with the License. You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED,
+INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE,
+AND NONINFRINGEMENT. IN NO EVENT WILL THE LICENSOR OR OTHER CONTRIBUTORS BE LIABLE FOR ANY CLAIM, DAMAGES,
+OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF,
+OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+See the License for the specific language governing permissions and limitations under the License.
+*/
+
+package retry_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"platform/lib/retry"
+	"reflect"
+	"testing"
+	"time"
+
+	"google.golang.org/api/googleapi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// fakeClock records the waits and returns immediately.
+type fakeClock struct {
+	waits []time.Duration
+}
+
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+	c.waits = append(c.waits, d)
+	ch := make(chan time.Time, 1)
+	ch <- time.Time{}
+	return ch
+}
+
+// fakeBackend fails with the errors in the given order, then succeeds.
+type fakeBackend struct {
+	errs  []error
+	calls int
+}
+
+func (b *fakeBackend) op(ctx context.Context) error {
+	b.calls++
+	if b.calls <= len(b.errs) {
+		return b.errs[b.calls-1]
+	}
+	return nil
+}
+
+var errTransient = status.Error(codes.Unavailable, "unavailable")
+
+func TestDo(t *testing.T) {
+	errPermanent := errors.New("not found")
+	tests := []struct {
+		name      string
+		errs      []error
+		wantErr   error
+		wantCalls int
+		wantWaits []time.Duration
+	}{
+		{
+			name:      "success",
+			errs:      nil,
+			wantCalls: 1,
+			wantWaits: nil,
+		},
+		{
+			name:      "transient then success",
+			errs:      []error{errTransient, errTransient},
+			wantCalls: 3,
+			wantWaits: []time.Duration{100 * time.Millisecond, 200 * time.Millisecond},
+		},
+		{
+			name:      "permanent",
+			errs:      []error{errTransient, errPermanent},
+			wantErr:   errPermanent,
+			wantCalls: 2,
+			wantWaits: []time.Duration{100 * time.Millisecond},
+		},
+		{
+			name:      "marked permanent",
+			errs:      []error{retry.Permanent(io.ErrUnexpectedEOF)},
+			wantErr:   io.ErrUnexpectedEOF,
+			wantCalls: 1,
+			wantWaits: nil,
+		},
+		{
+			name:      "max attempts",
+			errs:      []error{errTransient, errTransient, errTransient, errTransient, errTransient},
+			wantErr:   errTransient,
+			wantCalls: 4,
+			wantWaits: []time.Duration{100 * time.Millisecond, 200 * time.Millisecond, 300 * time.Millisecond},
+		},
+	}
+	for _, test := range tests {
+		clock := &fakeClock{}
+		p := retry.NewPolicy().
+			WithMaxAttempts(4).
+			WithBackoff(100*time.Millisecond, 300*time.Millisecond, 2).
+			WithJitter(0).
+			WithClock(clock)
+		b := &fakeBackend{errs: test.errs}
+		err := p.Do(context.Background(), b.op)
+		if err != test.wantErr {
+			t.Fatalf("error for %s!\nwant: %v\ngot: %v", test.name, test.wantErr, err)
+		}
+		if b.calls != test.wantCalls {
+			t.Fatalf("error for %s!\nwant calls: %d\ngot: %d", test.name, test.wantCalls, b.calls)
+		}
+		if !reflect.DeepEqual(clock.waits, test.wantWaits) {
+			t.Fatalf("error for %s!\nwant waits: %v\ngot: %v", test.name, test.wantWaits, clock.waits)
+		}
+	}
+}
+
+func TestDoAttemptTimeout(t *testing.T) {
+	calls := 0
+	p := retry.NewPolicy().WithMaxAttempts(2).WithTimeout(time.Millisecond).WithClock(&fakeClock{})
+	err := p.Do(context.Background(), func(ctx context.Context) error {
+		calls++
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	if err != context.DeadlineExceeded || calls != 2 {
+		t.Fatalf("error!\nwant: %v after 2 calls\ngot: %v after %d calls", context.DeadlineExceeded, err, calls)
+	}
+}
+
+func TestDoCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	b := &fakeBackend{errs: []error{errTransient, errTransient}}
+	err := retry.NewPolicy().WithClock(&fakeClock{}).Do(ctx, func(ctx context.Context) error {
+		cancel()
+		return b.op(ctx)
+	})
+	if err != errTransient || b.calls != 1 {
+		t.Fatalf("error!\nwant: %v after 1 call\ngot: %v after %d calls", errTransient, err, b.calls)
+	}
+}
+
+func TestBackoffJitter(t *testing.T) {
+	p := retry.NewPolicy().WithBackoff(time.Second, 10*time.Second, 2).WithJitter(0.5).WithSeed(1)
+	for attempt, d := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second, 10 * time.Second, 10 * time.Second} {
+		got := p.Backoff(attempt + 1)
+		if got < d/2 || got > d {
+			t.Fatalf("error for attempt %d!\nwant: [%v, %v]\ngot: %v", attempt+1, d/2, d, got)
+		}
+	}
+}
+
+func TestIsRetryable(t *testing.T) {
+	tests := []struct {
+		in   error
+		want bool
+	}{
+		{in: nil, want: false},
+		{in: errors.New("foo"), want: false},
+		{in: retry.Transient(errors.New("foo")), want: true},
+		{in: retry.Permanent(errTransient), want: false},
+		{in: context.DeadlineExceeded, want: true},
+		{in: context.Canceled, want: false},
+		{in: fmt.Errorf("read: %w", io.ErrUnexpectedEOF), want: true},
+		{in: &googleapi.Error{Code: 503}, want: true},
+		{in: &googleapi.Error{Code: 429}, want: true},
+		{in: &googleapi.Error{Code: 404}, want: false},
+		{in: status.Error(codes.Unavailable, ""), want: true},
+		{in: status.Error(codes.InvalidArgument, ""), want: false},
+	}
+	for _, test := range tests {
+		if got := retry.IsRetryable(test.in); got != test.want {
+			t.Fatalf("error for %v!\nwant: %v\ngot: %v", test.in, test.want, got)
+		}
+	}
+}
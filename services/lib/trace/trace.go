@@ -0,0 +1,63 @@
+/* Copyright 2021 Dmitry Kisler dkisler.com
+
+Licensed under the Apache License,Version 2.0 (the "License");
+you may not use this file except in compliance with the License. You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED,
+INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE,
+AND NONINFRINGEMENT. IN NO EVENT WILL THE LICENSOR OR OTHER CONTRIBUTORS BE LIABLE FOR ANY CLAIM, DAMAGES,
+OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF,
+OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+See the License for the specific language governing permissions and limitations under the License.
+*/
+
+/*
+Package defines the adapter of the string maps carrying the trace context, e.g. the Pub/Sub message attributes,
+to the OpenTelemetry propagators.
+
+The services trace with the OpenTelemetry SDK, and propagate the context in the W3C Trace Context format,
+see: https://www.w3.org/TR/trace-context/
+*/
+
+package trace
+
+import (
+	"strings"
+
+	"go.opentelemetry.io/otel/propagation"
+)
+
+// Carrier adapts the message attributes, or the object metadata, to the propagators.
+// The keys are matched case-insensitively on read, e.g. the metadata keys may be canonicalized by the storage.
+type Carrier map[string]string
+
+var _ propagation.TextMapCarrier = Carrier{}
+
+// Get returns the value of the key, the empty string is returned if it's not set.
+func (c Carrier) Get(key string) string {
+	if v, ok := c[key]; ok {
+		return v
+	}
+	for k, v := range c {
+		if strings.EqualFold(k, key) {
+			return v
+		}
+	}
+	return ""
+}
+
+// Set sets the value of the key.
+func (c Carrier) Set(key, value string) {
+	c[key] = value
+}
+
+// Keys lists the keys of the carrier.
+func (c Carrier) Keys() []string {
+	o := make([]string, 0, len(c))
+	for k := range c {
+		o = append(o, k)
+	}
+	return o
+}
@@ -0,0 +1,46 @@
+/* Copyright 2021 Dmitry Kisler dkisler.com
+
+Licensed under the Apache License,Version 2.0 (the "License");
+you may not use this file except in compliance with the License. You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED,
+INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE,
+AND NONINFRINGEMENT. IN NO EVENT WILL THE LICENSOR OR OTHER CONTRIBUTORS BE LIABLE FOR ANY CLAIM, DAMAGES,
+OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF,
+OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+See the License for the specific language governing permissions and limitations under the License.
+*/
+
+package trace_test
+
+import (
+	"context"
+	"platform/lib/trace"
+	"testing"
+
+	"go.opentelemetry.io/otel/propagation"
+)
+
+func TestCarrier(t *testing.T) {
+	const traceparent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	propagator := propagation.TraceContext{}
+	tests := []struct {
+		in   trace.Carrier
+		want string
+	}{
+		{in: trace.Carrier{"traceparent": traceparent}, want: traceparent},
+		// the storage may canonicalize the metadata keys
+		{in: trace.Carrier{"Traceparent": traceparent, "Foo": "bar"}, want: traceparent},
+		{in: trace.Carrier{"traceparent": "00-invalid"}, want: ""},
+		{in: trace.Carrier{}, want: ""},
+	}
+	for _, test := range tests {
+		got := trace.Carrier{}
+		propagator.Inject(propagator.Extract(context.Background(), test.in), got)
+		if got.Get("traceparent") != test.want {
+			t.Fatalf("error for %v!\nwant: %v\ngot: %v", test.in, test.want, got)
+		}
+	}
+}
@@ -16,18 +16,47 @@ See the License for the specific language governing permissions and limitations
 package main
 
 import (
+	"context"
 	"fmt"
-	"log"
+	"io"
 	httpStatus "net/http"
 	"platform/lib/api/http"
 	"platform/lib/io/bus/pubsub"
+	"platform/lib/logging"
+	"platform/lib/metrics"
 	"platform/lib/utils"
+	"platform/process/export"
+	"platform/process/hub"
 	"platform/process/models"
+	"platform/process/store"
 	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
 const (
 	hotStorageCollection = "processed"
+	deadLetterCollection = "deadletter"
+)
+
+var (
+	processingDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "processing_duration_seconds",
+		Help:    "Latency to process the submissions in seconds.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"status"})
+	sampleSize = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "processing_sample_size",
+		Help:    "Number of data points in the processed submissions.",
+		Buckets: prometheus.ExponentialBuckets(1, 4, 10),
+	})
+	// tracer starts the spans of the processing
+	tracer = otel.Tracer("platform/process")
 )
 
 func defaultReturn() (*http.Response, error) {
@@ -36,91 +65,361 @@ func defaultReturn() (*http.Response, error) {
 
 func process(runner *runner) http.Action {
 	return func(r *http.Request) (*http.Response, error) {
-		triggerPayload, err := pubsub.ExtractMessage(r.Body)
+		ctx, triggerPayload, err := pubsub.ExtractMessage(r.Context(), r.Body)
 		if err != nil {
-			runner.Fail.Push([]byte(fmt.Sprintf(`{"error": "%s"}`, err.Error())))
+			runner.Fail.Push(ctx, []byte(fmt.Sprintf(`{"error": "%s"}`, err.Error())))
 			return defaultReturn()
 		}
+		// the message is processed in the trace of the submission,
+		// the push request is traced separately and linked
+		ctx, span := tracer.Start(ctx, "pubsub.receive",
+			trace.WithSpanKind(trace.SpanKindConsumer),
+			trace.WithLinks(trace.LinkFromContext(r.Context())),
+		)
+		if err := processPayload(ctx, runner, triggerPayload); err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		span.End()
+		return defaultReturn()
+	}
+}
 
-		locationDataRaw, err := models.DeserializePayloadLocation(triggerPayload)
+// processPayload processes the raw data from the location defined by the trigger payload.
+// The submission is dead-lettered if it fails to be processed.
+func processPayload(ctx context.Context, runner *runner, triggerPayload []byte) (err error) {
+	start := time.Now()
+	ctx, span := tracer.Start(ctx, "process.payload")
+	defer func() {
+		processingDuration.WithLabelValues(metrics.Status(err)).Observe(time.Since(start).Seconds())
 		if err != nil {
-			runner.Fail.Push([]byte(fmt.Sprintf(`{"error": "%s"}`, err.Error())))
-			return defaultReturn()
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
 		}
+		span.End()
+	}()
 
-		n := models.Notification{
-			SubmitterID:  locationDataRaw.SubmitterID,
-			SubmissionID: locationDataRaw.SubmissionID,
-			Error:        "",
-		}
-		sendFail := func(err error) {
-			n.Error = err.Error()
-			runner.Fail.Push(n.MustSerialize())
+	locationDataRaw, err := models.DeserializePayloadLocation(triggerPayload)
+	if err != nil {
+		logging.FromContext(ctx).Error("invalid trigger payload", "error", err)
+		runner.Fail.Push(ctx, []byte(fmt.Sprintf(`{"error": "%s"}`, err.Error())))
+		return err
+	}
+
+	span.SetAttributes(
+		attribute.String("submitter_id", locationDataRaw.SubmitterID),
+		attribute.String("submission_id", locationDataRaw.SubmissionID),
+		attribute.Int("schema_version", locationDataRaw.SchemaVersion),
+	)
+	logger := logging.FromContext(ctx).With(
+		"submitter_id", locationDataRaw.SubmitterID,
+		"submission_id", locationDataRaw.SubmissionID,
+	)
+	ctx = logging.NewContext(ctx, logger)
+
+	n := models.Notification{
+		SubmitterID:  locationDataRaw.SubmitterID,
+		SubmissionID: locationDataRaw.SubmissionID,
+		Error:        "",
+	}
+	sendFail := func(err error) error {
+		logger.Error("failed to process the submission", "error", err)
+		n.Error = err.Error()
+		runner.Fail.Push(ctx, n.MustSerialize())
+		dl := &models.DeadLetter{}
+		if errDL := runner.HotStorage.Upsert(ctx, deadLetterCollection, n.SubmissionID, dl, func() {
+			dl.Record(locationDataRaw, err, time.Now().UTC())
+		}); errDL != nil {
+			logger.Error("failed to dead-letter the submission", "error", errDL)
 		}
+		return err
+	}
+
+	data, err := runner.ColdStorage.Read(ctx, locationDataRaw.Bucket, locationDataRaw.Obj)
+	if err != nil {
+		return sendFail(err)
+	}
+	inpt, err := models.DeserializeInput(data, locationDataRaw.SchemaVersion)
+	if err != nil {
+		return sendFail(err)
+	}
+	sampleSize.Observe(float64(inpt.Size()))
+	o := inpt.Transform()
+	o.SubmitterID = locationDataRaw.SubmitterID
+	o.SubmissionID = locationDataRaw.SubmissionID
+	o.TransformationEpoch = time.Now().Unix()
+
+	if err := runner.HotStorage.Write(ctx, hotStorageCollection, o); err != nil {
+		return sendFail(err)
+	}
+
+	// the subscribers are sent the result once it's received from the message bus, see publishResult
+	if _, err := runner.Success.Push(ctx, o.MustSerialize()); err != nil {
+		logger.Error("failed to publish the notification", "error", err)
+	}
+	return nil
+}
+
+// publishResult broadcasts the processed data point received from the message bus to the subscribers.
+// Every service instance receives the data points processed by all instances from its own subscription,
+// hence the subscribers are sent the results regardless of the instance which processed them.
+func publishResult(h *hub.Hub) func(ctx context.Context, data []byte) {
+	return func(ctx context.Context, data []byte) {
+		o, err := models.DeserializeOutput(data)
 		if err != nil {
-			sendFail(err)
-			return defaultReturn()
+			logging.FromContext(ctx).Error("failed to deserialize the processed result", "error", err)
+			return
 		}
+		h.Publish(o, o.MustSerializeElement())
+	}
+}
 
-		data, err := runner.ColdStorage.Read(locationDataRaw.Bucket, locationDataRaw.Obj)
-		if err != nil {
-			sendFail(err)
-			return defaultReturn()
+// negotiateFormat identifies the output format requested by the client.
+func negotiateFormat(r *http.Request) (*export.Format, *http.Response) {
+	f, err := export.Negotiate(r.Query["format"], r.Headers["Accept"])
+	if err != nil {
+		return nil, http.NewErrorResponse(err.Error(), httpStatus.StatusNotAcceptable)
+	}
+	return f, nil
+}
+
+// parseExpression parses the query expression submitted as the "q" query parameter.
+func parseExpression(r *http.Request) (models.Filter, error) {
+	f, err := models.ParseFilter(r.Query["q"])
+	if err != nil {
+		return nil, http.WrapError(http.KindValidation, err)
+	}
+	return f, nil
+}
+
+// streamResults streams the query results in the requested format.
+func streamResults(f *export.Format, it *store.Iterator) (*http.Response, error) {
+	stream, err := models.NewResultStream(it)
+	if err != nil {
+		it.Close()
+		return nil, err
+	}
+	resp := http.NewStreamResponse(func(w io.Writer) error {
+		defer it.Close()
+		return stream.Export(f.NewWriter(w))
+	}, httpStatus.StatusOK)
+	resp.SetContentType(f.ContentType)
+	return resp, nil
+}
+
+func query(runner *runner) http.Action {
+	return func(r *http.Request) (*http.Response, error) {
+		f, resp := negotiateFormat(r)
+		if resp != nil {
+			return resp, nil
 		}
-		inpt, err := models.DeserializeInput(data)
+		q, err := models.DeserializeQuery(r.Body)
 		if err != nil {
-			sendFail(err)
-			return defaultReturn()
+			return nil, http.WrapError(http.KindValidation, err)
 		}
-		o := inpt.Transform()
-		o.SubmitterID = locationDataRaw.SubmitterID
-		o.SubmissionID = locationDataRaw.SubmissionID
-		o.TransformationEpoch = time.Now().Unix()
+		l := utils.MustAtoi(r.Query["limit"])
+		offset := utils.MustAtoi(r.Query["offset"])
+		return streamResults(f, runner.HotStorage.Iterate(r.Context(), hotStorageCollection, q.Filter(), l, offset))
+	}
+}
 
-		if err := runner.HotStorage.Write(hotStorageCollection, o); err != nil {
-			log.Println(err)
-			sendFail(err)
-			return defaultReturn()
+func fetch(runner *runner) http.Action {
+	return func(r *http.Request) (*http.Response, error) {
+		f, resp := negotiateFormat(r)
+		if resp != nil {
+			return resp, nil
+		}
+		filter := models.Filter{}
+		if _, ok := r.Query["q"]; ok {
+			var err error
+			if filter, err = parseExpression(r); err != nil {
+				return nil, err
+			}
 		}
+		l := utils.MustAtoi(r.Query["limit"])
+		offset := utils.MustAtoi(r.Query["offset"])
+		return streamResults(f, runner.HotStorage.Iterate(r.Context(), hotStorageCollection, filter, l, offset))
+	}
+}
 
-		if _, err := runner.Success.Push(o.MustSerialize()); err != nil {
-			log.Println(err)
+// requestFilter defines the filter from the "q" query parameter, or from the query submitted as the request body.
+// The empty filter is returned if neither is submitted.
+func requestFilter(r *http.Request) (models.Filter, error) {
+	if _, ok := r.Query["q"]; ok {
+		return parseExpression(r)
+	}
+	if len(r.Body) > 0 {
+		q, err := models.DeserializeQuery(r.Body)
+		if err != nil {
+			return nil, http.WrapError(http.KindValidation, err)
 		}
-		return defaultReturn()
+		return q.Filter(), nil
 	}
+	return models.Filter{}, nil
 }
 
-func query(runner *runner) http.Action {
+func summary(runner *runner) http.Action {
+	return func(r *http.Request) (*http.Response, error) {
+		filter, err := requestFilter(r)
+		if err != nil {
+			return nil, err
+		}
+		s, err := runner.HotStorage.Summary(r.Context(), hotStorageCollection, filter)
+		if err != nil {
+			return nil, err
+		}
+		return http.NewResponse(s.MustSerialize(), httpStatus.StatusOK), nil
+	}
+}
+
+// keepAliveInterval defines how often the comment line is sent to idle subscribers.
+// It keeps the connection open behind proxies and detects disconnected clients.
+const keepAliveInterval = 15 * time.Second
+
+// subscribeWriteTimeout defines the deadline of every write to the subscriber,
+// the subscriber not reading the events is disconnected once it's exceeded.
+const subscribeWriteTimeout = 2 * keepAliveInterval
+
+// flusher defines the writer with buffered output.
+type flusher interface {
+	Flush() error
+}
+
+// subscribe streams the newly processed results as Server-Sent Events until the client disconnects,
+// or the request times out. The subscription is resumed after the event id submitted as the "Last-Event-ID" header,
+// or the "last_event_id" query parameter, when the client reconnects.
+func subscribe(runner *runner) http.Action {
 	return func(r *http.Request) (*http.Response, error) {
-		var q *models.Query
-		err := models.ValidateQuery(r.Body)
+		filter, err := requestFilter(r)
 		if err != nil {
-			return http.NewResponse(
-				[]byte(fmt.Sprintf(`{"error": "%s"}`, err.Error())),
-				httpStatus.StatusBadRequest,
-			), err
+			return nil, err
+		}
+		lastEventID, ok := r.Headers["Last-Event-Id"]
+		if !ok {
+			lastEventID = r.Query["last_event_id"]
 		}
-		q = models.DeserializeQuery(r.Body)
+		sub := runner.Results.Subscribe(filter, lastEventID)
+
+		resp := http.NewStreamResponse(func(w io.Writer) error {
+			defer sub.Close()
+			send := func(format string, args ...interface{}) error {
+				if _, err := fmt.Fprintf(w, format, args...); err != nil {
+					return err
+				}
+				if f, ok := w.(flusher); ok {
+					return f.Flush()
+				}
+				return nil
+			}
+			if err := send("retry: %d\n\n", time.Second.Milliseconds()); err != nil {
+				return err
+			}
+			keepAlive := time.NewTicker(keepAliveInterval)
+			defer keepAlive.Stop()
+			for {
+				var err error
+				select {
+				case e := <-sub.Events():
+					err = send("id: %s\ndata: %s\n\n", e.ID, e.Data)
+				case <-keepAlive.C:
+					err = send(": keep-alive\n\n")
+				case <-r.Context().Done():
+					return r.Context().Err()
+				case <-sub.Done():
+					if err = sub.Err(); err == nil {
+						return nil
+					}
+					_ = send("event: error\ndata: %s\n\n", http.NewErrorResponse(err.Error(), httpStatus.StatusInternalServerError).Body)
+				}
+				if err != nil {
+					return err
+				}
+			}
+		}, httpStatus.StatusOK)
+		resp.SetContentType("text/event-stream")
+		resp.AddHeaders(map[string]string{
+			"Cache-Control":     "no-cache",
+			"X-Accel-Buffering": "no",
+		})
+		return resp, nil
+	}
+}
+
+func listDeadLetters(runner *runner) http.Action {
+	return func(r *http.Request) (*http.Response, error) {
 		l := utils.MustAtoi(r.Query["limit"])
 		offset := utils.MustAtoi(r.Query["offset"])
-		var qRes models.QueryResults
-		if err := runner.HotStorage.Read(hotStorageCollection, q, l, offset, &qRes); err != nil {
+		var o models.DeadLetters
+		if err := runner.HotStorage.Read(r.Context(), deadLetterCollection, nil, l, offset, &o); err != nil {
 			return nil, err
 		}
-		out := qRes.Transform()
-		return http.NewResponse(out.MustSerialize(), httpStatus.StatusOK), nil
+		return http.NewResponse(o.MustSerialize(), httpStatus.StatusOK), nil
 	}
 }
 
-func fetch(runner *runner) http.Action {
+var errDeadLetterNotFound = http.NewError(http.KindNotFound, "dead letter not found")
+
+func inspectDeadLetter(runner *runner) http.Action {
+	return func(r *http.Request) (*http.Response, error) {
+		var o models.DeadLetter
+		if err := runner.HotStorage.Get(r.Context(), deadLetterCollection, r.RouteParameters["submission_id"], &o); err != nil {
+			if err == store.ErrNotFound {
+				return nil, errDeadLetterNotFound
+			}
+			return nil, err
+		}
+		return http.NewResponse(o.MustSerialize(), httpStatus.StatusOK), nil
+	}
+}
+
+// replay processes the dead-lettered submission again.
+// The dead letter is deleted if the submission is processed successfully,
+// otherwise its attempts count is incremented.
+func replay(ctx context.Context, runner *runner, d *models.DeadLetter) *models.ReplayResult {
+	o := &models.ReplayResult{SubmissionID: d.SubmissionID}
+	if err := processPayload(ctx, runner, d.MustSerializeLocation()); err != nil {
+		o.Error = err.Error()
+		return o
+	}
+	if err := runner.HotStorage.Delete(ctx, deadLetterCollection, d.SubmissionID); err != nil {
+		logging.FromContext(ctx).Error(
+			"failed to delete the dead letter", "submission_id", d.SubmissionID, "error", err,
+		)
+	}
+	return o
+}
+
+func replayDeadLetter(runner *runner) http.Action {
+	return func(r *http.Request) (*http.Response, error) {
+		var d models.DeadLetter
+		if err := runner.HotStorage.Get(r.Context(), deadLetterCollection, r.RouteParameters["submission_id"], &d); err != nil {
+			if err == store.ErrNotFound {
+				return nil, errDeadLetterNotFound
+			}
+			return nil, err
+		}
+		o := replay(r.Context(), runner, &d)
+		status := httpStatus.StatusOK
+		if o.Error != "" {
+			status = httpStatus.StatusUnprocessableEntity
+		}
+		return http.NewResponse(models.ReplayResults{o}.MustSerialize(), status), nil
+	}
+}
+
+// replayDeadLetters replays the page of dead-lettered submissions defined by the limit and offset.
+func replayDeadLetters(runner *runner) http.Action {
 	return func(r *http.Request) (*http.Response, error) {
 		l := utils.MustAtoi(r.Query["limit"])
 		offset := utils.MustAtoi(r.Query["offset"])
-		var qRes models.QueryResults
-		if err := runner.HotStorage.Read(hotStorageCollection, nil, l, offset, &qRes); err != nil {
+		var dls models.DeadLetters
+		if err := runner.HotStorage.Read(r.Context(), deadLetterCollection, nil, l, offset, &dls); err != nil {
 			return nil, err
 		}
-		out := qRes.Transform()
-		return http.NewResponse(out.MustSerialize(), httpStatus.StatusOK), nil
+		o := models.ReplayResults{}
+		for i := range dls {
+			o = append(o, replay(r.Context(), runner, &dls[i]))
+		}
+		return http.NewResponse(o.MustSerialize(), httpStatus.StatusOK), nil
 	}
 }
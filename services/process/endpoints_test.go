@@ -0,0 +1,54 @@
+/* Copyright 2021 Dmitry Kisler dkisler.com
+
+Licensed under the Apache License,Version 2.0 (the "License");
+you may not use this file except in compliance with the License. You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED,
+INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE,
+AND NONINFRINGEMENT. IN NO EVENT WILL THE LICENSOR OR OTHER CONTRIBUTORS BE LIABLE FOR ANY CLAIM, DAMAGES,
+OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF,
+OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+See the License for the specific language governing permissions and limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"platform/process/hub"
+	"platform/process/models"
+	"testing"
+)
+
+func TestPublishResult(t *testing.T) {
+	h := hub.NewHub()
+	filter, err := models.ParseFilter(`submitter = "acme"`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sub := h.Subscribe(filter, "")
+	defer sub.Close()
+
+	publish := publishResult(h)
+	for _, data := range []string{
+		`{"submitter_id":"acme","submission_id":"a","transformation_epoch":1,` +
+			`"payload":{"timestamp":"2021-07-08T10:00:00Z","mean":1.5,"standard_deviation":0.5}}`,
+		`{"submitter_id":"other","submission_id":"b","transformation_epoch":1,` +
+			`"payload":{"timestamp":"2021-07-08T10:00:00Z","mean":1.5,"standard_deviation":0.5}}`,
+		// the malformed message is dropped
+		`{`,
+	} {
+		publish(context.Background(), []byte(data))
+	}
+
+	want := `{"submission_id":"a","payload":{"timestamp":"2021-07-08T10:00:00Z","mean":1.5,"standard_deviation":0.5}}`
+	got := []string{}
+	for len(sub.Events()) > 0 {
+		got = append(got, string((<-sub.Events()).Data))
+	}
+	if len(got) != 1 || got[0] != want {
+		t.Fatalf("error!\nwant: [%s]\ngot: %v", want, got)
+	}
+}
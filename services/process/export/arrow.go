@@ -0,0 +1,106 @@
+/* Copyright 2021 Dmitry Kisler dkisler.com
+
+Licensed under the Apache License,Version 2.0 (the "License");
+you may not use this file except in compliance with the License. You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED,
+INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE,
+AND NONINFRINGEMENT. IN NO EVENT WILL THE LICENSOR OR OTHER CONTRIBUTORS BE LIABLE FOR ANY CLAIM, DAMAGES,
+OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF,
+OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+See the License for the specific language governing permissions and limitations under the License.
+*/
+
+package export
+
+import (
+	"io"
+	"time"
+
+	"github.com/apache/arrow/go/v7/arrow"
+	"github.com/apache/arrow/go/v7/arrow/array"
+	"github.com/apache/arrow/go/v7/arrow/ipc"
+	"github.com/apache/arrow/go/v7/arrow/memory"
+)
+
+// recordBatchSize defines the max number of rows buffered in memory.
+const recordBatchSize = 1 << 14
+
+// arrowSchema defines the schema of the record batches, the payload fields are null if the payload is not set.
+var arrowSchema = arrow.NewSchema([]arrow.Field{
+	{Name: "submission_id", Type: arrow.BinaryTypes.String},
+	{Name: "timestamp", Type: arrow.FixedWidthTypes.Timestamp_us, Nullable: true},
+	{Name: "mean", Type: arrow.PrimitiveTypes.Float64, Nullable: true},
+	{Name: "standard_deviation", Type: arrow.PrimitiveTypes.Float64, Nullable: true},
+}, nil)
+
+// unixMicro returns the number of microseconds elapsed since the Unix epoch.
+func unixMicro(t time.Time) int64 {
+	return t.Unix()*1e6 + int64(t.Nanosecond())/1e3
+}
+
+// batchWriter defines the encoder of the record batches, e.g. the Arrow IPC stream or the Parquet file writer.
+type batchWriter interface {
+	Write(rec arrow.Record) error
+	Close() error
+}
+
+// recordWriter buffers the rows into the record batches of the Arrow schema, and writes them with the batch writer.
+type recordWriter struct {
+	w    batchWriter
+	b    *array.RecordBuilder
+	rows int
+}
+
+func newRecordWriter(w batchWriter) *recordWriter {
+	return &recordWriter{w: w, b: array.NewRecordBuilder(memory.DefaultAllocator, arrowSchema)}
+}
+
+// newArrowWriter writes the rows as Arrow IPC stream of record batches.
+func newArrowWriter(w io.Writer) Writer {
+	return newRecordWriter(ipc.NewWriter(w, ipc.WithSchema(arrowSchema)))
+}
+
+func (rw *recordWriter) Write(r *Row) error {
+	rw.b.Field(0).(*array.StringBuilder).Append(r.SubmissionID)
+	timestamp := rw.b.Field(1).(*array.TimestampBuilder)
+	mean := rw.b.Field(2).(*array.Float64Builder)
+	stddev := rw.b.Field(3).(*array.Float64Builder)
+	if p := r.Payload; p != nil {
+		timestamp.Append(arrow.Timestamp(unixMicro(p.Timestamp)))
+		mean.Append(p.Mean)
+		stddev.Append(p.Stddev)
+	} else {
+		timestamp.AppendNull()
+		mean.AppendNull()
+		stddev.AppendNull()
+	}
+	rw.rows++
+	if rw.rows >= recordBatchSize {
+		return rw.flush()
+	}
+	return nil
+}
+
+// flush writes the buffered rows as a record batch.
+func (rw *recordWriter) flush() error {
+	if rw.rows == 0 {
+		return nil
+	}
+	rec := rw.b.NewRecord()
+	defer rec.Release()
+	rw.rows = 0
+	return rw.w.Write(rec)
+}
+
+// Close writes the buffered rows and finalizes the output,
+// the output of no rows consists of the schema only.
+func (rw *recordWriter) Close() error {
+	defer rw.b.Release()
+	if err := rw.flush(); err != nil {
+		return err
+	}
+	return rw.w.Close()
+}
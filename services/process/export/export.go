@@ -0,0 +1,159 @@
+/* Copyright 2021 Dmitry Kisler dkisler.com
+
+Licensed under the Apache License,Version 2.0 (the "License");
+you may not use this file except in compliance with the License. You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED,
+INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE,
+AND NONINFRINGEMENT. IN NO EVENT WILL THE LICENSOR OR OTHER CONTRIBUTORS BE LIABLE FOR ANY CLAIM, DAMAGES,
+OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF,
+OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+See the License for the specific language governing permissions and limitations under the License.
+
+Package defines the processed data export formats.
+
+Every format is written row by row, so the results can be streamed
+without being materialized in memory as a whole:
+	- json: JSON array (default)
+	- ndjson: newline delimited JSON
+	- csv: comma separated values with the header line
+	- parquet: Apache Parquet file
+	- arrow: Apache Arrow IPC stream
+*/
+
+package export
+
+import (
+	"errors"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Row defines the processed data point to export.
+type Row struct {
+	SubmissionID string
+	// Payload defines the processed payload, its fields are exported as nulls if it's not set.
+	Payload *Payload
+}
+
+// Payload defines the processed payload of the data point.
+type Payload struct {
+	Timestamp time.Time
+	Mean      float64
+	Stddev    float64
+}
+
+// Writer defines the encoder of the rows stream.
+type Writer interface {
+	// Write encodes the row.
+	Write(r *Row) error
+	// Close flushes buffered rows and finalizes the output.
+	// It does not close the underlying io.Writer.
+	Close() error
+}
+
+// Format defines the export format.
+type Format struct {
+	// Name defines the format name used in the "format" query parameter.
+	Name string
+	// ContentType defines the MIME content type of the output.
+	ContentType string
+	// NewWriter initiates the format writer.
+	NewWriter func(w io.Writer) Writer
+}
+
+var (
+	// JSON defines the JSON array format.
+	JSON = &Format{Name: "json", ContentType: "application/json", NewWriter: newJSONWriter}
+	// NDJSON defines the newline delimited JSON format.
+	NDJSON = &Format{Name: "ndjson", ContentType: "application/x-ndjson", NewWriter: newNDJSONWriter}
+	// CSV defines the comma separated values format.
+	CSV = &Format{Name: "csv", ContentType: "text/csv", NewWriter: newCSVWriter}
+	// Parquet defines the Apache Parquet format.
+	Parquet = &Format{Name: "parquet", ContentType: "application/vnd.apache.parquet", NewWriter: newParquetWriter}
+	// Arrow defines the Apache Arrow IPC streaming format.
+	Arrow = &Format{Name: "arrow", ContentType: "application/vnd.apache.arrow.stream", NewWriter: newArrowWriter}
+)
+
+var formats = map[string]*Format{
+	JSON.Name:    JSON,
+	NDJSON.Name:  NDJSON,
+	CSV.Name:     CSV,
+	Parquet.Name: Parquet,
+	Arrow.Name:   Arrow,
+}
+
+// mediaTypes maps the accepted MIME types to formats.
+var mediaTypes = map[string]*Format{
+	"application/json":                    JSON,
+	"application/x-ndjson":                NDJSON,
+	"application/ndjson":                  NDJSON,
+	"application/jsonl":                   NDJSON,
+	"text/csv":                            CSV,
+	"application/vnd.apache.parquet":      Parquet,
+	"application/x-parquet":               Parquet,
+	"application/vnd.apache.arrow.stream": Arrow,
+}
+
+// ErrUnsupportedFormat is returned when none of the requested formats is supported.
+var ErrUnsupportedFormat = errors.New("unsupported format, supported formats: json, ndjson, csv, parquet, arrow")
+
+// Negotiate identifies the export format.
+// The format query parameter takes precedence over the Accept header.
+// JSON is returned if neither is specified.
+func Negotiate(format, accept string) (*Format, error) {
+	if format != "" {
+		if f, ok := formats[strings.ToLower(format)]; ok {
+			return f, nil
+		}
+		return nil, ErrUnsupportedFormat
+	}
+	if strings.TrimSpace(accept) == "" {
+		return JSON, nil
+	}
+	for _, m := range parseAccept(accept) {
+		switch {
+		case m.typ == "*/*", m.typ == "application/*":
+			return JSON, nil
+		case m.typ == "text/*":
+			return CSV, nil
+		}
+		if f, ok := mediaTypes[m.typ]; ok {
+			return f, nil
+		}
+	}
+	return nil, ErrUnsupportedFormat
+}
+
+type mediaRange struct {
+	typ string
+	q   float64
+}
+
+// parseAccept parses the Accept header value into media ranges sorted by preference.
+// Media ranges with q=0 are dropped.
+func parseAccept(s string) []mediaRange {
+	o := []mediaRange{}
+	for _, el := range strings.Split(s, ",") {
+		params := strings.Split(el, ";")
+		m := mediaRange{typ: strings.ToLower(strings.TrimSpace(params[0])), q: 1}
+		for _, p := range params[1:] {
+			kv := strings.SplitN(strings.TrimSpace(p), "=", 2)
+			if len(kv) == 2 && kv[0] == "q" {
+				if q, err := strconv.ParseFloat(kv[1], 64); err == nil {
+					m.q = q
+				}
+			}
+		}
+		if m.typ != "" && m.q > 0 {
+			o = append(o, m)
+		}
+	}
+	sort.SliceStable(o, func(i, j int) bool { return o[i].q > o[j].q })
+	return o
+}
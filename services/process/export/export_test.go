@@ -0,0 +1,244 @@
+/* Copyright 2021 Dmitry Kisler dkisler.com
+
+Licensed under the Apache License,Version 2.0 (the "License");
+you may not use this file except in compliance with the License. You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED,
+INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE,
+AND NONINFRINGEMENT. IN NO EVENT WILL THE LICENSOR OR OTHER CONTRIBUTORS BE LIABLE FOR ANY CLAIM, DAMAGES,
+OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF,
+OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+See the License for the specific language governing permissions and limitations under the License.
+*/
+
+package export_test
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"platform/process/export"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/apache/arrow/go/v7/arrow"
+	"github.com/apache/arrow/go/v7/arrow/array"
+	"github.com/apache/arrow/go/v7/arrow/ipc"
+	"github.com/apache/arrow/go/v7/arrow/memory"
+	"github.com/apache/arrow/go/v7/parquet/file"
+	"github.com/apache/arrow/go/v7/parquet/pqarrow"
+)
+
+func TestNegotiate(t *testing.T) {
+	tests := []struct {
+		format string
+		accept string
+		want   *export.Format
+	}{
+		{format: "", accept: "", want: export.JSON},
+		{format: "CSV", accept: "application/json", want: export.CSV},
+		{format: "", accept: "*/*", want: export.JSON},
+		{format: "", accept: "application/x-ndjson", want: export.NDJSON},
+		{format: "", accept: "text/csv;q=0.5, application/vnd.apache.parquet", want: export.Parquet},
+		{format: "", accept: "application/xml, application/vnd.apache.arrow.stream;q=0.1", want: export.Arrow},
+		{format: "", accept: "text/csv;q=0, application/json", want: export.JSON},
+	}
+	for _, test := range tests {
+		got, err := export.Negotiate(test.format, test.accept)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if got != test.want {
+			t.Fatalf("error!\nwant: %v\ngot: %v", test.want.Name, got.Name)
+		}
+	}
+
+	for _, test := range []struct{ format, accept string }{
+		{format: "xml", accept: ""},
+		{format: "", accept: "application/xml"},
+		{format: "", accept: "text/csv;q=0"},
+	} {
+		if _, err := export.Negotiate(test.format, test.accept); err != export.ErrUnsupportedFormat {
+			t.Fatalf("error!\nwant: %v\ngot: %v", export.ErrUnsupportedFormat, err)
+		}
+	}
+}
+
+var rows = []*export.Row{
+	{
+		SubmissionID: "a",
+		Payload: &export.Payload{
+			Timestamp: time.Date(2021, 7, 8, 10, 0, 0, 0, time.UTC),
+			Mean:      1.5,
+			Stddev:    0.5,
+		},
+	},
+	// the payload fields are exported as nulls
+	{SubmissionID: "c"},
+	{
+		SubmissionID: "b",
+		Payload: &export.Payload{
+			Timestamp: time.Date(2021, 7, 8, 11, 0, 0, 0, time.UTC),
+			Mean:      2,
+			Stddev:    0,
+		},
+	},
+}
+
+// manyRows returns the rows written in more than one row group and record batch.
+func manyRows() []*export.Row {
+	o := []*export.Row{}
+	for i := 0; i < 1<<15+1; i++ {
+		o = append(o, rows[i%len(rows)])
+	}
+	return o
+}
+
+func write(f *export.Format, rows []*export.Row) []byte {
+	var buf bytes.Buffer
+	w := f.NewWriter(&buf)
+	for _, r := range rows {
+		w.Write(r)
+	}
+	w.Close()
+	return buf.Bytes()
+}
+
+func TestWriterText(t *testing.T) {
+	tests := []struct {
+		format *export.Format
+		in     []*export.Row
+		want   string
+	}{
+		{
+			format: export.JSON,
+			in:     rows,
+			want: `[{"submission_id":"a","payload":{"timestamp":"2021-07-08T10:00:00Z","mean":1.5,"standard_deviation":0.5}},` +
+				`{"submission_id":"c","payload":null},` +
+				`{"submission_id":"b","payload":{"timestamp":"2021-07-08T11:00:00Z","mean":2,"standard_deviation":0}}]`,
+		},
+		{
+			format: export.JSON,
+			in:     nil,
+			want:   `[]`,
+		},
+		{
+			format: export.NDJSON,
+			in:     rows,
+			want: `{"submission_id":"a","payload":{"timestamp":"2021-07-08T10:00:00Z","mean":1.5,"standard_deviation":0.5}}` + "\n" +
+				`{"submission_id":"c","payload":null}` + "\n" +
+				`{"submission_id":"b","payload":{"timestamp":"2021-07-08T11:00:00Z","mean":2,"standard_deviation":0}}` + "\n",
+		},
+		{
+			format: export.CSV,
+			in:     rows,
+			want: "submission_id,timestamp,mean,standard_deviation\n" +
+				"a,2021-07-08T10:00:00Z,1.5,0.5\n" +
+				"c,,,\n" +
+				"b,2021-07-08T11:00:00Z,2,0\n",
+		},
+		{
+			format: export.CSV,
+			in:     nil,
+			want:   "submission_id,timestamp,mean,standard_deviation\n",
+		},
+	}
+	for _, test := range tests {
+		got := string(write(test.format, test.in))
+		if got != test.want {
+			t.Fatalf("%s writer fail!\nwant: %s\ngot: %s", test.format.Name, test.want, got)
+		}
+	}
+}
+
+// recordRows decodes the rows from the record batch.
+func recordRows(rec arrow.Record) []*export.Row {
+	submissionID := rec.Column(0).(*array.String)
+	timestamp := rec.Column(1).(*array.Timestamp)
+	mean := rec.Column(2).(*array.Float64)
+	stddev := rec.Column(3).(*array.Float64)
+	var o []*export.Row
+	for i := 0; i < int(rec.NumRows()); i++ {
+		row := &export.Row{SubmissionID: submissionID.Value(i)}
+		if timestamp.IsValid(i) {
+			row.Payload = &export.Payload{
+				Timestamp: time.Unix(0, int64(timestamp.Value(i))*1e3).UTC(),
+				Mean:      mean.Value(i),
+				Stddev:    stddev.Value(i),
+			}
+		}
+		o = append(o, row)
+	}
+	return o
+}
+
+// readParquet decodes the rows from the Parquet file, and checks that every row group holds one record batch at most.
+func readParquet(data []byte) ([]*export.Row, error) {
+	pf, err := file.NewParquetReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer pf.Close()
+	for i := 0; i < pf.NumRowGroups(); i++ {
+		if n := pf.RowGroup(i).NumRows(); n > 1<<14 {
+			return nil, fmt.Errorf("row group %d of %d rows", i, n)
+		}
+	}
+	r, err := pqarrow.NewFileReader(pf, pqarrow.ArrowReadProperties{}, memory.DefaultAllocator)
+	if err != nil {
+		return nil, err
+	}
+	tbl, err := r.ReadTable(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	defer tbl.Release()
+	tr := array.NewTableReader(tbl, tbl.NumRows())
+	defer tr.Release()
+	var o []*export.Row
+	for tr.Next() {
+		o = append(o, recordRows(tr.Record())...)
+	}
+	return o, nil
+}
+
+func TestWriterParquet(t *testing.T) {
+	for _, in := range [][]*export.Row{nil, rows, manyRows()} {
+		got, err := readParquet(write(export.Parquet, in))
+		if err != nil {
+			t.Fatalf("parquet writer fail!\nunexpected error: %v", err)
+		}
+		if !reflect.DeepEqual(got, in) {
+			t.Fatalf("parquet writer fail!\nwant: %v\ngot: %v", in, got)
+		}
+	}
+}
+
+// readArrow decodes the rows from the Arrow IPC stream.
+func readArrow(data []byte) ([]*export.Row, error) {
+	r, err := ipc.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Release()
+	var o []*export.Row
+	for r.Next() {
+		o = append(o, recordRows(r.Record())...)
+	}
+	return o, r.Err()
+}
+
+func TestWriterArrow(t *testing.T) {
+	for _, in := range [][]*export.Row{nil, rows, manyRows()} {
+		got, err := readArrow(write(export.Arrow, in))
+		if err != nil {
+			t.Fatalf("arrow writer fail!\nunexpected error: %v", err)
+		}
+		if !reflect.DeepEqual(got, in) {
+			t.Fatalf("arrow writer fail!\nwant: %v\ngot: %v", in, got)
+		}
+	}
+}
@@ -0,0 +1,103 @@
+/* Copyright 2021 Dmitry Kisler dkisler.com
+
+Licensed under the Apache License,Version 2.0 (the "License");
+you may not use this file except in compliance with the License. You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED,
+INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE,
+AND NONINFRINGEMENT. IN NO EVENT WILL THE LICENSOR OR OTHER CONTRIBUTORS BE LIABLE FOR ANY CLAIM, DAMAGES,
+OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF,
+OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+See the License for the specific language governing permissions and limitations under the License.
+*/
+
+package export
+
+import (
+	"bufio"
+	"io"
+	"time"
+
+	"github.com/goccy/go-json"
+)
+
+type payload struct {
+	Time   time.Time `json:"timestamp"`
+	Mean   float64   `json:"mean"`
+	Stddev float64   `json:"standard_deviation"`
+}
+
+// element defines the JSON representation of the row.
+type element struct {
+	SubmissionID string   `json:"submission_id"`
+	Payload      *payload `json:"payload"`
+}
+
+func newElement(r *Row) *element {
+	e := &element{SubmissionID: r.SubmissionID}
+	if r.Payload != nil {
+		e.Payload = &payload{
+			Time:   r.Payload.Timestamp,
+			Mean:   r.Payload.Mean,
+			Stddev: r.Payload.Stddev,
+		}
+	}
+	return e
+}
+
+type jsonWriter struct {
+	w     *bufio.Writer
+	delim []byte
+	n     int
+	// array defines if rows are wrapped into the JSON array.
+	array bool
+}
+
+func newJSONWriter(w io.Writer) Writer {
+	return &jsonWriter{w: bufio.NewWriter(w), delim: []byte(","), array: true}
+}
+
+func newNDJSONWriter(w io.Writer) Writer {
+	return &jsonWriter{w: bufio.NewWriter(w), delim: []byte("\n")}
+}
+
+func (j *jsonWriter) Write(r *Row) error {
+	b, err := json.Marshal(newElement(r))
+	if err != nil {
+		return err
+	}
+	switch {
+	case j.n == 0 && j.array:
+		_, err = j.w.WriteString("[")
+	case j.n > 0 && j.array:
+		_, err = j.w.Write(j.delim)
+	}
+	if err != nil {
+		return err
+	}
+	if _, err := j.w.Write(b); err != nil {
+		return err
+	}
+	if !j.array {
+		if _, err := j.w.Write(j.delim); err != nil {
+			return err
+		}
+	}
+	j.n++
+	return nil
+}
+
+func (j *jsonWriter) Close() error {
+	if j.array {
+		s := "]"
+		if j.n == 0 {
+			s = "[]"
+		}
+		if _, err := j.w.WriteString(s); err != nil {
+			return err
+		}
+	}
+	return j.w.Flush()
+}
@@ -0,0 +1,41 @@
+/* Copyright 2021 Dmitry Kisler dkisler.com
+
+Licensed under the Apache License,Version 2.0 (the "License");
+you may not use this file except in compliance with the License. You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED,
+INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE,
+AND NONINFRINGEMENT. IN NO EVENT WILL THE LICENSOR OR OTHER CONTRIBUTORS BE LIABLE FOR ANY CLAIM, DAMAGES,
+OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF,
+OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+See the License for the specific language governing permissions and limitations under the License.
+*/
+
+package export
+
+import (
+	"io"
+
+	"github.com/apache/arrow/go/v7/parquet"
+	"github.com/apache/arrow/go/v7/parquet/compress"
+	"github.com/apache/arrow/go/v7/parquet/pqarrow"
+)
+
+// parquetProperties defines the Parquet file properties, every record batch is written as a row group.
+var parquetProperties = parquet.NewWriterProperties(
+	parquet.WithCompression(compress.Codecs.Snappy),
+	parquet.WithMaxRowGroupLength(recordBatchSize),
+)
+
+// newParquetWriter writes the rows as Parquet file of the Arrow schema.
+func newParquetWriter(w io.Writer) Writer {
+	// the file writer closes the underlying writer if it's a closer, hence it's given the writer only
+	fw, err := pqarrow.NewFileWriter(arrowSchema, struct{ io.Writer }{w}, parquetProperties, pqarrow.DefaultWriterProps())
+	if err != nil {
+		// the schema is static, hence it's the programming error
+		panic(err)
+	}
+	return newRecordWriter(fw)
+}
@@ -20,7 +20,15 @@ replace platform/lib => ../lib
 
 require (
 	cloud.google.com/go/datastore v1.5.0
-	github.com/goccy/go-json v0.7.4
-	google.golang.org/genproto v0.0.0-20210713002101-d411969a0d9a // indirect
+	github.com/apache/arrow/go/v7 v7.0.0
+	github.com/goccy/go-json v0.10.5
+	github.com/prometheus/client_golang v1.12.2
+	go.opentelemetry.io/otel v1.7.0
+	go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp v1.7.0
+	go.opentelemetry.io/otel/exporters/stdout/stdouttrace v1.7.0
+	go.opentelemetry.io/otel/sdk v1.7.0
+	go.opentelemetry.io/otel/trace v1.7.0
+	google.golang.org/api v0.50.0
+	google.golang.org/grpc v1.46.0
 	platform/lib v0.0.0-00010101000000-000000000000
 )
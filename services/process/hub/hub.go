@@ -0,0 +1,249 @@
+/* Copyright 2021 Dmitry Kisler dkisler.com
+
+Licensed under the Apache License,Version 2.0 (the "License");
+you may not use this file except in compliance with the License. You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED,
+INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE,
+AND NONINFRINGEMENT. IN NO EVENT WILL THE LICENSOR OR OTHER CONTRIBUTORS BE LIABLE FOR ANY CLAIM, DAMAGES,
+OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF,
+OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+See the License for the specific language governing permissions and limitations under the License.
+
+Package defines the in-process hub broadcasting processed results to the subscribers.
+
+The hub keeps the ring buffer of recently published events, so subscribers
+can resume from the last received event id after reconnecting.
+Every subscriber is given a bounded buffer, the subscriber is disconnected
+as soon as its buffer overflows, i.e. when it does not keep up with the publisher.
+The hub is bound to the service instance, hence event ids issued by one instance
+are not recognized by another, or by the same instance after restart.
+The results are published by every instance as they are received from the message bus,
+so the hub of every instance broadcasts the results processed by all instances.
+*/
+
+package hub
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	historySizeDefault = 1000
+	bufferSizeDefault  = 100
+)
+
+// Config defines the hub configuration.
+type Config struct {
+	historySize int
+	bufferSize  int
+}
+
+// NewConfig returns the default hub configuration.
+func NewConfig() *Config {
+	return &Config{historySize: historySizeDefault, bufferSize: bufferSizeDefault}
+}
+
+// WithHistorySize sets the number of recent events kept to resume subscriptions.
+func (c *Config) WithHistorySize(n int) *Config {
+	if n > 0 {
+		c.historySize = n
+	}
+	return c
+}
+
+// WithBufferSize sets the number of events buffered per subscriber.
+func (c *Config) WithBufferSize(n int) *Config {
+	if n > 0 {
+		c.bufferSize = n
+	}
+	return c
+}
+
+// Matcher defines the subscriber's filter.
+type Matcher interface {
+	// Match checks if the published result shall be sent to the subscriber.
+	Match(result interface{}) bool
+}
+
+// Event defines the published event.
+type Event struct {
+	// ID defines the event id, it is unique per hub.
+	ID string
+	// Data defines the event payload.
+	Data []byte
+
+	seq    uint64
+	result interface{}
+}
+
+// ErrSlowConsumer is reported when the subscriber's buffer overflows.
+var ErrSlowConsumer = errors.New("subscriber is too slow, the events buffer overflowed")
+
+// Hub defines the events broadcaster.
+type Hub struct {
+	cfg *Config
+	// epoch distinguishes event ids issued by different hubs.
+	epoch string
+
+	mu          sync.Mutex
+	seq         uint64
+	history     []*Event
+	subscribers map[*Subscriber]struct{}
+}
+
+// NewHub initiates the hub.
+func NewHub() *Hub {
+	return &Hub{
+		cfg:         NewConfig(),
+		epoch:       strconv.FormatInt(time.Now().UnixNano(), 36),
+		subscribers: map[*Subscriber]struct{}{},
+	}
+}
+
+// WithCfg configures the hub.
+func (h *Hub) WithCfg(cfg *Config) *Hub {
+	h.cfg = cfg
+	return h
+}
+
+// Publish broadcasts the result to the subscribers with matching filters.
+// The data is the result's serialized representation to be sent to the subscribers.
+func (h *Hub) Publish(result interface{}, data []byte) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.seq++
+	e := &Event{
+		ID:     fmt.Sprintf("%s-%d", h.epoch, h.seq),
+		Data:   data,
+		seq:    h.seq,
+		result: result,
+	}
+	if len(h.history) < h.cfg.historySize {
+		h.history = append(h.history, e)
+	} else {
+		h.history[int((e.seq-1)%uint64(len(h.history)))] = e
+	}
+	for s := range h.subscribers {
+		if !s.send(e) {
+			h.unsubscribe(s, ErrSlowConsumer)
+		}
+	}
+}
+
+// Subscribe subscribes to the events matching the filter.
+// If lastEventID is set, the subscription is resumed with the retained events published after it.
+// The subscriber must be closed after use.
+func (h *Hub) Subscribe(filter Matcher, lastEventID string) *Subscriber {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	replay := h.since(lastEventID, filter)
+	s := &Subscriber{
+		hub:    h,
+		filter: filter,
+		events: make(chan *Event, h.cfg.bufferSize+len(replay)),
+		done:   make(chan struct{}),
+	}
+	for _, e := range replay {
+		s.events <- e
+	}
+	h.subscribers[s] = struct{}{}
+	return s
+}
+
+// since returns the retained events matching the filter which were published after the event id.
+func (h *Hub) since(id string, filter Matcher) []*Event {
+	o := []*Event{}
+	els := strings.SplitN(id, "-", 2)
+	if len(els) != 2 || els[0] != h.epoch {
+		return o
+	}
+	seq, err := strconv.ParseUint(els[1], 10, 64)
+	if err != nil {
+		return o
+	}
+	n := uint64(len(h.history))
+	for s := seq + 1; s <= h.seq; s++ {
+		if h.seq-s >= n {
+			// the event was evicted
+			continue
+		}
+		e := h.history[int((s-1)%n)]
+		if filter.Match(e.result) {
+			o = append(o, e)
+		}
+	}
+	return o
+}
+
+// Subscribers returns the number of active subscribers.
+func (h *Hub) Subscribers() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return len(h.subscribers)
+}
+
+func (h *Hub) unsubscribe(s *Subscriber, err error) {
+	if _, ok := h.subscribers[s]; !ok {
+		return
+	}
+	delete(h.subscribers, s)
+	s.err = err
+	close(s.done)
+}
+
+// Subscriber defines the hub subscription.
+type Subscriber struct {
+	hub    *Hub
+	filter Matcher
+	events chan *Event
+	done   chan struct{}
+	err    error
+}
+
+// send sends the event to the subscriber without blocking.
+// It returns false if the subscriber's buffer is full.
+func (s *Subscriber) send(e *Event) bool {
+	if !s.filter.Match(e.result) {
+		return true
+	}
+	select {
+	case s.events <- e:
+		return true
+	default:
+		return false
+	}
+}
+
+// Events returns the channel of the events.
+func (s *Subscriber) Events() <-chan *Event {
+	return s.events
+}
+
+// Done returns the channel closed when the subscription is terminated.
+// The buffered events shall be discarded once the channel is closed.
+func (s *Subscriber) Done() <-chan struct{} {
+	return s.done
+}
+
+// Err returns the reason for the subscription termination.
+// It returns nil if the subscription was closed by the subscriber.
+func (s *Subscriber) Err() error {
+	s.hub.mu.Lock()
+	defer s.hub.mu.Unlock()
+	return s.err
+}
+
+// Close terminates the subscription.
+func (s *Subscriber) Close() {
+	s.hub.mu.Lock()
+	defer s.hub.mu.Unlock()
+	s.hub.unsubscribe(s, nil)
+}
@@ -0,0 +1,127 @@
+/* Copyright 2021 Dmitry Kisler dkisler.com
+
+Licensed under the Apache License,Version 2.0 (the "License");
+you may not use this file except in compliance with the License. You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED,
+INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE,
+AND NONINFRINGEMENT. IN NO EVENT WILL THE LICENSOR OR OTHER CONTRIBUTORS BE LIABLE FOR ANY CLAIM, DAMAGES,
+OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF,
+OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+See the License for the specific language governing permissions and limitations under the License.
+*/
+
+package hub_test
+
+import (
+	"platform/process/hub"
+	"reflect"
+	"testing"
+)
+
+// even matches even integer results.
+type even struct{}
+
+func (even) Match(result interface{}) bool {
+	return result.(int)%2 == 0
+}
+
+// all matches all results.
+type all struct{}
+
+func (all) Match(result interface{}) bool {
+	return true
+}
+
+func drain(s *hub.Subscriber) []string {
+	o := []string{}
+	for {
+		select {
+		case e := <-s.Events():
+			o = append(o, string(e.Data))
+		default:
+			return o
+		}
+	}
+}
+
+func TestPublish(t *testing.T) {
+	h := hub.NewHub()
+	sEven := h.Subscribe(even{}, "")
+	defer sEven.Close()
+	sAll := h.Subscribe(all{}, "")
+	defer sAll.Close()
+
+	for _, v := range []int{1, 2, 3, 4} {
+		h.Publish(v, []byte{byte('0' + v)})
+	}
+	if got, want := drain(sEven), []string{"2", "4"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("error!\nwant: %v\ngot: %v", want, got)
+	}
+	if got, want := drain(sAll), []string{"1", "2", "3", "4"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("error!\nwant: %v\ngot: %v", want, got)
+	}
+}
+
+func TestResume(t *testing.T) {
+	h := hub.NewHub().WithCfg(hub.NewConfig().WithHistorySize(3))
+	s := h.Subscribe(all{}, "")
+	ids := []string{}
+	for _, v := range []int{1, 2, 3, 4, 5} {
+		h.Publish(v, []byte{byte('0' + v)})
+		ids = append(ids, (<-s.Events()).ID)
+	}
+	s.Close()
+
+	tests := []struct {
+		lastEventID string
+		filter      hub.Matcher
+		want        []string
+	}{
+		{lastEventID: ids[3], filter: all{}, want: []string{"5"}},
+		{lastEventID: ids[1], filter: all{}, want: []string{"3", "4", "5"}},
+		{lastEventID: ids[1], filter: even{}, want: []string{"4"}},
+		// the events 2 and 3 were evicted from the history
+		{lastEventID: ids[0], filter: all{}, want: []string{"3", "4", "5"}},
+		{lastEventID: ids[4], filter: all{}, want: []string{}},
+		{lastEventID: "", filter: all{}, want: []string{}},
+		{lastEventID: "foo-1", filter: all{}, want: []string{}},
+	}
+	for _, test := range tests {
+		s := h.Subscribe(test.filter, test.lastEventID)
+		got := drain(s)
+		s.Close()
+		if !reflect.DeepEqual(got, test.want) {
+			t.Fatalf("error for %s!\nwant: %v\ngot: %v", test.lastEventID, test.want, got)
+		}
+	}
+}
+
+func TestSlowConsumer(t *testing.T) {
+	h := hub.NewHub().WithCfg(hub.NewConfig().WithBufferSize(2))
+	slow := h.Subscribe(all{}, "")
+	fast := h.Subscribe(all{}, "")
+	defer fast.Close()
+
+	for _, v := range []int{1, 2, 3} {
+		h.Publish(v, []byte{byte('0' + v)})
+		<-fast.Events()
+	}
+	select {
+	case <-slow.Done():
+	default:
+		t.Fatal("error!\nwant: slow subscriber disconnected\ngot: subscribed")
+	}
+	if slow.Err() != hub.ErrSlowConsumer {
+		t.Fatalf("error!\nwant: %v\ngot: %v", hub.ErrSlowConsumer, slow.Err())
+	}
+	if h.Subscribers() != 1 {
+		t.Fatalf("error!\nwant: 1 subscriber\ngot: %d", h.Subscribers())
+	}
+	slow.Close()
+	if fast.Err() != nil {
+		t.Fatalf("error!\nwant: %v\ngot: %v", nil, fast.Err())
+	}
+}
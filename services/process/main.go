@@ -21,18 +21,36 @@ Modus operandi:
 	- Calculates Mean and Std dev of submitted data distribution.
 2. Stores data to the service store (GCP Datastore).
 3. Pushes notification message to the message bus (GCP PubSub).
+   The submissions failed to be processed are dead-lettered to be replayed.
+4. Broadcasts processed data received from the message bus to the clients subscribed to the "/subscribe" endpoint,
+   every instance receives the data processed by all instances from its own subscription.
 */
 
 package main
 
 import (
-	"log"
+	"context"
+	"fmt"
+	"io"
+	"os"
 	"platform/lib/api/http"
+	"platform/lib/health"
 	"platform/lib/io/bus/pubsub"
 	"platform/lib/io/meta"
 	"platform/lib/io/store/gcs"
+	"platform/lib/logging"
 	"platform/lib/utils"
+	"platform/process/hub"
 	"platform/process/store"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.10.0"
 )
 
 type runner struct {
@@ -40,65 +58,173 @@ type runner struct {
 	Fail        *pubsub.Publisher
 	ColdStorage *gcs.Client
 	HotStorage  *store.Client
+	Results     *hub.Hub
 }
 
+// processTimeout defines the deadline to process the submission,
+// it shall not exceed the acknowledgement deadline of the push subscription.
+const processTimeout = 60 * time.Second
+
 var (
 	r *runner = &runner{}
 	s *http.Server
+	// closers defines the runner's clients to be closed on shutdown,
+	// the publishers are closed first to flush pending messages
+	closers []io.Closer
 )
 
-func setServer() {
+// newHandlers defines the service endpoints, they are documented in the OpenAPI document.
+func newHandlers(readiness *health.Checker) *http.Handlers {
 	endpoints := map[string]*http.HandlerEndpoint{
-		"/":      http.NewHandlerEndpoint(process(r), []string{"POST"}),
-		"/query": http.NewHandlerEndpoint(query(r), []string{"POST"}),
-		"/fetch": http.NewHandlerEndpoint(fetch(r), []string{"GET"}),
+		"POST /":      http.NewHandlerEndpoint(process(r), nil).WithTimeout(processTimeout).WithDoc(processDoc),
+		"POST /query": http.NewHandlerEndpoint(query(r), nil).WithDoc(queryDoc),
+		"GET /fetch":  http.NewHandlerEndpoint(fetch(r), nil).WithDoc(fetchDoc),
+		// the summary of all processed data is returned if no query is submitted
+		"GET /summary":  http.NewHandlerEndpoint(summary(r), nil).WithDoc(summaryDoc),
+		"POST /summary": http.NewHandlerEndpoint(summary(r), nil).WithDoc(summaryQueryDoc),
+		// the newly processed results are streamed as Server-Sent Events until the client disconnects
+		"GET /subscribe":  http.NewHandlerEndpoint(subscribe(r), nil).WithStreamWriteTimeout(subscribeWriteTimeout).WithDoc(subscribeDoc),
+		"POST /subscribe": http.NewHandlerEndpoint(subscribe(r), nil).WithStreamWriteTimeout(subscribeWriteTimeout).WithDoc(subscribeQueryDoc),
+		// the submissions failed to be processed are dead-lettered to be inspected and replayed
+		"GET /deadletter":                          http.NewHandlerEndpoint(listDeadLetters(r), nil).WithDoc(listDeadLettersDoc),
+		"POST /deadletter/replay":                  http.NewHandlerEndpoint(replayDeadLetters(r), nil).WithDoc(replayDeadLettersDoc),
+		"GET /deadletter/{:submission_id}":         http.NewHandlerEndpoint(inspectDeadLetter(r), nil).WithDoc(inspectDeadLetterDoc),
+		"POST /deadletter/{:submission_id}/replay": http.NewHandlerEndpoint(replayDeadLetter(r), nil).WithDoc(replayDeadLetterDoc),
 	}
-	handlers := http.NewRequestHandlers(endpoints).WithDefaultHeaders(
+	return http.NewRequestHandlers(endpoints).WithDefaultHeaders(
 		map[string]string{
 			"tag-layer":  "process",
 			"tag-branch": "fast",
-		})
+		}).WithReadiness(readiness).WithOpenAPI(openAPIInfo)
+}
 
+func setServer() {
+	readiness := health.NewChecker().
+		Register("datastore", func(ctx context.Context) error { return r.HotStorage.Check(ctx, hotStorageCollection) }).
+		Register("pubsub_success", r.Success.CheckTopic).
+		Register("pubsub_fail", r.Fail.CheckTopic)
+	handlers := newHandlers(readiness)
+	// the responses are checked against the API contract in the test and dev environments
+	if utils.GetEnv("VALIDATE_RESPONSES", "false") == "true" {
+		handlers.WithResponseValidation()
+	}
 	s = http.NewServer(handlers)
 	s.SetName("process")
 }
 
-func init() {
+// setup configures the runner and the server.
+// tracerProvider shuts the tracer provider down on close, the ended spans are exported.
+type tracerProvider struct {
+	*sdktrace.TracerProvider
+}
+
+func (t tracerProvider) Close() error {
+	return t.Shutdown(context.Background())
+}
+
+// newTracerProvider initiates the tracer provider of the service exporting the spans by the exporter name:
+//   - "stdout" writes the spans to stdout, e.g. for local runs
+//   - "otlp" sends the spans to the OTLP/HTTP endpoint set by the envvar OTEL_EXPORTER_OTLP_ENDPOINT, e.g. http://localhost:4318
+//   - "" does not export the spans, the trace context is propagated regardless
+func newTracerProvider(ctx context.Context, serviceName, exporter string) (tracerProvider, error) {
+	opts := []sdktrace.TracerProviderOption{
+		sdktrace.WithResource(resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceNameKey.String(serviceName))),
+	}
+	switch exporter {
+	case "":
+	case "stdout":
+		e, err := stdouttrace.New()
+		if err != nil {
+			return tracerProvider{}, err
+		}
+		opts = append(opts, sdktrace.WithBatcher(e))
+	case "otlp":
+		if utils.GetEnv("OTEL_EXPORTER_OTLP_ENDPOINT", "") == "" {
+			return tracerProvider{}, fmt.Errorf("otlp exporter endpoint is not set")
+		}
+		e, err := otlptracehttp.New(ctx)
+		if err != nil {
+			return tracerProvider{}, err
+		}
+		opts = append(opts, sdktrace.WithBatcher(e))
+	default:
+		return tracerProvider{}, fmt.Errorf("unknown trace exporter %s", exporter)
+	}
+	return tracerProvider{sdktrace.NewTracerProvider(opts...)}, nil
+}
+
+func setup() {
+	logLevel, err := logging.ParseLevel(utils.GetEnv("LOG_LEVEL", "info"))
+	if err != nil {
+		logging.Default().Fatal("specify the log level, e.g. as envvar 'LOG_LEVEL=info'", "error", err)
+	}
 	projectID := utils.GetEnv("GCP_PROJECT", "")
 	if projectID == "" {
 		projectID = meta.GetProjectID()
 		if projectID == "" {
-			log.Fatalln("specify gcp project, e.g. as envvar 'GCP_PROJECT'")
+			logging.Default().Fatal("specify gcp project, e.g. as envvar 'GCP_PROJECT'")
 		}
 	}
+	logging.SetDefault(logging.New(os.Stderr, logging.NewConfig().WithLevel(logLevel).WithProjectID(projectID)))
 	topic := utils.GetEnv("NOTIFICATION_TOPIC", "")
 	if topic == "" {
-		log.Fatalln("specify the the message bus notification topic by setting envvar 'NOTIFICATION_TOPIC'")
+		logging.Default().Fatal("specify the the message bus notification topic by setting envvar 'NOTIFICATION_TOPIC'")
 	}
 	topicFail := utils.GetEnv("NOTIFICATION_TOPIC_FAIL", "")
-	if topic == "" {
-		log.Fatalln("specify the the message bus notification for fail topic by setting envvar 'NOTIFICATION_TOPIC_FAIL'")
+	if topicFail == "" {
+		logging.Default().Fatal("specify the the message bus notification for fail topic by setting envvar 'NOTIFICATION_TOPIC_FAIL'")
 	}
 	c, err := pubsub.NewClient(projectID)
 	if err != nil {
-		log.Fatalln(err)
+		logging.Default().Fatal("failed to initialize the service", "error", err)
 	}
 	r.Success = c.GetPublisher(topic).WithCCLimit(1)
 	r.Fail = c.GetPublisher(topicFail).WithCCLimit(1)
+	r.Results = hub.NewHub().WithCfg(
+		hub.NewConfig().
+			WithHistorySize(utils.MustAtoi(utils.GetEnv("SUBSCRIBE_HISTORY_SIZE", ""))).
+			WithBufferSize(utils.MustAtoi(utils.GetEnv("SUBSCRIBE_BUFFER_SIZE", ""))),
+	)
+	// the instance receives the results processed by all instances from its own subscription, see publishResult
+	results, err := c.NewTemporarySubscriber(context.Background(), topic, fmt.Sprintf("%s-results-%s", topic, utils.GenerateUUID4()))
+	if err != nil {
+		logging.Default().Fatal("failed to initialize the service", "error", err)
+	}
+	go func() {
+		if err := results.Receive(context.Background(), publishResult(r.Results)); err != nil {
+			logging.Default().Error("failed to receive the processed results", "error", err)
+		}
+	}()
+	closers = append(closers, r.Success, r.Fail, results, c)
 
 	r.ColdStorage, err = gcs.NewClient()
 	if err != nil {
-		log.Fatalln(err)
+		logging.Default().Fatal("failed to initialize the service", "error", err)
+	}
+	closers = append(closers, r.ColdStorage)
+
+	r.HotStorage, err = store.NewClient(context.Background(), projectID)
+	if err != nil {
+		logging.Default().Fatal("failed to initialize the service", "error", err)
 	}
+	closers = append(closers, r.HotStorage)
 
-	r.HotStorage, err = store.NewClient(projectID)
+	tp, err := newTracerProvider(context.Background(), "process", utils.GetEnv("TRACE_EXPORTER", ""))
 	if err != nil {
-		log.Fatalln(err)
+		logging.Default().Fatal("failed to initialize the service", "error", err)
 	}
+	otel.SetTracerProvider(tp)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	// the provider is closed last to export the spans of the shutdown
+	closers = append(closers, tp)
 
 	setServer()
 }
 
 func main() {
-	s.Start(utils.GetEnv("PORT", "9000"))
+	setup()
+	s.OnShutdown(closers...)
+	if err := s.Start(utils.GetEnv("PORT", "9000")); err != nil {
+		logging.Default().Fatal("failed to start the service", "error", err)
+	}
 }
@@ -0,0 +1,88 @@
+/* Copyright 2021 Dmitry Kisler dkisler.com
+
+Licensed under the Apache License,Version 2.0 (the "License");
+you may not use this file except in compliance with the License. You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED,
+INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE,
+AND NONINFRINGEMENT. IN NO EVENT WILL THE LICENSOR OR OTHER CONTRIBUTORS BE LIABLE FOR ANY CLAIM, DAMAGES,
+OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF,
+OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+See the License for the specific language governing permissions and limitations under the License.
+*/
+
+package models
+
+import (
+	"time"
+
+	"github.com/goccy/go-json"
+)
+
+// DeadLetter defines the submission which failed to be processed.
+type DeadLetter struct {
+	SubmitterID  string           `json:"submitter_id"`
+	SubmissionID string           `json:"submission_id"`
+	Location     *payloadLocation `json:"location" datastore:",flatten"`
+	// Error defines the error of the last processing attempt.
+	Error string `json:"error" datastore:",noindex"`
+	// Attempts defines the number of failed processing attempts.
+	Attempts      int       `json:"attempts"`
+	FirstFailedAt time.Time `json:"first_failed_at"`
+	LastFailedAt  time.Time `json:"last_failed_at"`
+}
+
+// Record records the failed processing attempt of the submission from the location.
+func (d *DeadLetter) Record(location *payloadLocation, err error, t time.Time) {
+	d.SubmitterID = location.SubmitterID
+	d.SubmissionID = location.SubmissionID
+	d.Location = location
+	d.Error = err.Error()
+	d.Attempts++
+	if d.FirstFailedAt.IsZero() {
+		d.FirstFailedAt = t
+	}
+	d.LastFailedAt = t
+}
+
+// MustSerializeLocation serializes the location of the submission to be processed.
+func (d *DeadLetter) MustSerializeLocation() []byte {
+	out, _ := json.Marshal(d.Location)
+	return out
+}
+
+func (d *DeadLetter) MustSerialize() []byte {
+	out, _ := json.Marshal(d)
+	return out
+}
+
+// DeadLetters defines the list of dead-lettered submissions.
+type DeadLetters []DeadLetter
+
+func (d DeadLetters) MustSerialize() []byte {
+	if d == nil {
+		d = DeadLetters{}
+	}
+	out, _ := json.Marshal(d)
+	return out
+}
+
+// ReplayResult defines the result of the dead-lettered submission replay.
+type ReplayResult struct {
+	SubmissionID string `json:"submission_id"`
+	// Error defines the replay error, it is empty if the submission was processed successfully.
+	Error string `json:"error,omitempty"`
+}
+
+// ReplayResults defines the list of replay results.
+type ReplayResults []*ReplayResult
+
+func (r ReplayResults) MustSerialize() []byte {
+	if r == nil {
+		r = ReplayResults{}
+	}
+	out, _ := json.Marshal(r)
+	return out
+}
@@ -0,0 +1,59 @@
+/* Copyright 2021 Dmitry Kisler dkisler.com
+
+Licensed under the Apache License,Version 2.0 (the "License");
+you may not use this file except in compliance with the License. You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED,
+INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE,
+AND NONINFRINGEMENT. IN NO EVENT WILL THE LICENSOR OR OTHER CONTRIBUTORS BE LIABLE FOR ANY CLAIM, DAMAGES,
+OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF,
+OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+See the License for the specific language governing permissions and limitations under the License.
+*/
+
+package models_test
+
+import (
+	"errors"
+	"platform/process/models"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestDeadLetterRecord(t *testing.T) {
+	payload := []byte(`{"submitter_id":"acme","submission_id":"foo","bucket":"raw","key":"acme/foo/foo.json"}`)
+	location, err := models.DeserializePayloadLocation(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t0 := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	t1 := t0.Add(time.Hour)
+
+	d := &models.DeadLetter{}
+	d.Record(location, errors.New("first"), t0)
+	d.Record(location, errors.New("second"), t1)
+
+	want := &models.DeadLetter{
+		SubmitterID:   "acme",
+		SubmissionID:  "foo",
+		Location:      location,
+		Error:         "second",
+		Attempts:      2,
+		FirstFailedAt: t0,
+		LastFailedAt:  t1,
+	}
+	if !reflect.DeepEqual(d, want) {
+		t.Fatalf("error!\nwant: %v\ngot: %v", want, d)
+	}
+
+	got, err := models.DeserializePayloadLocation(d.MustSerializeLocation())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, location) {
+		t.Fatalf("error!\nwant: %v\ngot: %v", location, got)
+	}
+}
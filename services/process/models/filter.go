@@ -0,0 +1,162 @@
+/* Copyright 2021 Dmitry Kisler dkisler.com
+
+Licensed under the Apache License,Version 2.0 (the "License");
+you may not use this file except in compliance with the License. You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED,
+INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE,
+AND NONINFRINGEMENT. IN NO EVENT WILL THE LICENSOR OR OTHER CONTRIBUTORS BE LIABLE FOR ANY CLAIM, DAMAGES,
+OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF,
+OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+See the License for the specific language governing permissions and limitations under the License.
+*/
+
+package models
+
+import (
+	"strings"
+	"time"
+)
+
+// Field defines the filterable field of the processed data.
+type Field string
+
+const (
+	FieldTimestamp Field = "timestamp"
+	FieldMean      Field = "mean"
+	FieldStddev    Field = "standard_deviation"
+	FieldSubmitter Field = "submitter"
+)
+
+// Operator defines the comparison operator.
+type Operator string
+
+const (
+	OpEqual        Operator = "="
+	OpLess         Operator = "<"
+	OpLessEqual    Operator = "<="
+	OpGreater      Operator = ">"
+	OpGreaterEqual Operator = ">="
+)
+
+// Condition defines the comparison of the field with the value.
+// The value type is time.Time for the timestamp, float64 for the mean
+// and the standard deviation, and string for the submitter.
+type Condition struct {
+	Field Field
+	Op    Operator
+	Value interface{}
+}
+
+// Filter defines the conjunction of conditions the store backends consume.
+// An empty filter matches all data.
+type Filter []*Condition
+
+// Filter converts the query into the filter.
+func (q *Query) Filter() Filter {
+	f := Filter{}
+	if q == nil {
+		return f
+	}
+	if q.PayloadTimestamp != nil {
+		if q.PayloadTimestamp.Min != nil {
+			f = append(f, &Condition{FieldTimestamp, OpGreaterEqual, *q.PayloadTimestamp.Min})
+		}
+		if q.PayloadTimestamp.Max != nil {
+			f = append(f, &Condition{FieldTimestamp, OpLessEqual, *q.PayloadTimestamp.Max})
+		}
+	}
+	if q.PayloadMean != nil {
+		f = append(f, q.PayloadMean.conditions(FieldMean)...)
+	}
+	if q.PayloadStddev != nil {
+		f = append(f, q.PayloadStddev.conditions(FieldStddev)...)
+	}
+	return f
+}
+
+func (r *queryFloat) conditions(field Field) Filter {
+	f := Filter{}
+	if r.Min != nil {
+		f = append(f, &Condition{field, OpGreaterEqual, *r.Min})
+	}
+	if r.Max != nil {
+		f = append(f, &Condition{field, OpLessEqual, *r.Max})
+	}
+	return f
+}
+
+// compare compares the values of the same type, it returns -1, 0, or +1.
+func compare(a, b interface{}) int {
+	switch a := a.(type) {
+	case time.Time:
+		b := b.(time.Time)
+		switch {
+		case a.Before(b):
+			return -1
+		case a.After(b):
+			return 1
+		}
+	case float64:
+		b := b.(float64)
+		switch {
+		case a < b:
+			return -1
+		case a > b:
+			return 1
+		}
+	case string:
+		return strings.Compare(a, b.(string))
+	}
+	return 0
+}
+
+func (c *Condition) match(o *outputProcessing) bool {
+	var v interface{}
+	switch c.Field {
+	case FieldSubmitter:
+		v = o.SubmitterID
+	case FieldTimestamp, FieldMean, FieldStddev:
+		if o.Payload == nil {
+			return false
+		}
+		v = map[Field]interface{}{
+			FieldTimestamp: o.Payload.Time,
+			FieldMean:      o.Payload.Mean,
+			FieldStddev:    o.Payload.Stddev,
+		}[c.Field]
+	default:
+		return false
+	}
+	r := compare(v, c.Value)
+	switch c.Op {
+	case OpEqual:
+		return r == 0
+	case OpLess:
+		return r < 0
+	case OpLessEqual:
+		return r <= 0
+	case OpGreater:
+		return r > 0
+	case OpGreaterEqual:
+		return r >= 0
+	}
+	return false
+}
+
+// Match checks if the query result matches all the filter conditions.
+// The result is expected to be the pointer to the processed data point loaded by the store.
+func (f Filter) Match(result interface{}) bool {
+	o, ok := result.(*outputProcessing)
+	if !ok {
+		return false
+	}
+	for _, c := range f {
+		if !c.match(o) {
+			return false
+		}
+	}
+	return true
+}
@@ -16,6 +16,8 @@ See the License for the specific language governing permissions and limitations
 package models
 
 import (
+	"fmt"
+	"platform/process/export"
 	"platform/process/transformation"
 	"time"
 
@@ -27,6 +29,8 @@ type payloadLocation struct {
 	SubmissionID string `json:"submission_id"`
 	Bucket       string `json:"bucket"`
 	Obj          string `json:"key"`
+	// SchemaVersion defines the schema version of the submission.
+	SchemaVersion int `json:"schema_version,omitempty"`
 }
 
 func DeserializePayloadLocation(data []byte) (p *payloadLocation, err error) {
@@ -45,9 +49,14 @@ func (p *Notification) MustSerialize() []byte {
 	return o
 }
 
+// input defines the submission of any schema version.
 type input struct {
 	Time time.Time `json:"time_stamp"`
 	Data []float64 `json:"data"`
+	// fields defined by the schema version 2
+	Units    string   `json:"units,omitempty"`
+	DeviceID string   `json:"device_id,omitempty"`
+	Tags     []string `json:"tags,omitempty"`
 }
 
 type payload struct {
@@ -68,11 +77,49 @@ func (o *outputProcessing) MustSerialize() []byte {
 	return out
 }
 
-func DeserializeInput(data []byte) (i *input, err error) {
-	err = json.Unmarshal(data, &i)
+// DeserializeOutput deserializes the processed data point published to the message bus.
+func DeserializeOutput(data []byte) (o *outputProcessing, err error) {
+	err = json.Unmarshal(data, &o)
 	return
 }
 
+// inputDecoders defines the decoders of the submission by schema version, see the submission schema registry.
+var inputDecoders = map[int]func(data []byte) (*input, error){
+	1: func(data []byte) (*input, error) {
+		var i struct {
+			Time time.Time `json:"time_stamp"`
+			Data []float64 `json:"data"`
+		}
+		if err := json.Unmarshal(data, &i); err != nil {
+			return nil, err
+		}
+		return &input{Time: i.Time, Data: i.Data}, nil
+	},
+	2: func(data []byte) (i *input, err error) {
+		err = json.Unmarshal(data, &i)
+		return
+	},
+}
+
+// DeserializeInput deserializes the submission of the schema version.
+// The version is not set in the notifications published before the schema versioning was introduced,
+// such submissions are of the version 1.
+func DeserializeInput(data []byte, version int) (*input, error) {
+	if version == 0 {
+		version = 1
+	}
+	decode, ok := inputDecoders[version]
+	if !ok {
+		return nil, fmt.Errorf("unsupported submission schema version %d", version)
+	}
+	return decode(data)
+}
+
+// Size returns the number of data points in the sample.
+func (i *input) Size() int {
+	return len(i.Data)
+}
+
 func (i *input) Transform() *outputProcessing {
 	dstrStats := transformation.NewDistribution(i.Data).Stats()
 	ts := transformation.ConvertTimestampUTC(i.Time)
@@ -85,8 +132,68 @@ func (i *input) Transform() *outputProcessing {
 	}
 }
 
+// Row converts the processed data point to the export row.
+func (o *outputProcessing) Row() *export.Row {
+	r := &export.Row{SubmissionID: o.SubmissionID}
+	if o.Payload != nil {
+		r.Payload = &export.Payload{
+			Timestamp: o.Payload.Time,
+			Mean:      o.Payload.Mean,
+			Stddev:    o.Payload.Stddev,
+		}
+	}
+	return r
+}
+
 type QueryResults []outputProcessing
 
+// ResultIterator defines the iterator over the query results.
+type ResultIterator interface {
+	// Next loads the next result into dst.
+	// It returns false when there are no results left.
+	Next(dst interface{}) (bool, error)
+}
+
+// ResultStream defines the stream of query results.
+type ResultStream struct {
+	it    ResultIterator
+	first *outputProcessing
+}
+
+// NewResultStream initiates the stream of query results.
+// The first result is read eagerly to report the query errors
+// before the response is started.
+func NewResultStream(it ResultIterator) (*ResultStream, error) {
+	var o outputProcessing
+	ok, err := it.Next(&o)
+	if err != nil {
+		return nil, err
+	}
+	s := &ResultStream{it: it}
+	if ok {
+		s.first = &o
+	}
+	return s, nil
+}
+
+// Export writes the query results using the export format writer.
+func (s *ResultStream) Export(w export.Writer) error {
+	for o := s.first; o != nil; {
+		if err := w.Write(o.Row()); err != nil {
+			return err
+		}
+		o = &outputProcessing{}
+		ok, err := s.it.Next(o)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			break
+		}
+	}
+	return w.Close()
+}
+
 type outputElement struct {
 	SubmissionID string   `json:"submission_id"`
 	Payload      *payload `json:"payload"`
@@ -94,6 +201,15 @@ type outputElement struct {
 
 type output []*outputElement
 
+// MustSerializeElement serializes the processed data point as the query output element.
+func (o *outputProcessing) MustSerializeElement() []byte {
+	out, _ := json.Marshal(&outputElement{
+		SubmissionID: o.SubmissionID,
+		Payload:      o.Payload,
+	})
+	return out
+}
+
 func (i *QueryResults) Transform() *output {
 	var out output
 	for _, o := range *i {
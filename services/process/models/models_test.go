@@ -0,0 +1,48 @@
+/* Copyright 2021 Dmitry Kisler dkisler.com
+
+Licensed under the Apache License,Version 2.0 (the "License");
+you may not use this file except in compliance with the License. You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED,
+INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE,
+AND NONINFRINGEMENT. IN NO EVENT WILL THE LICENSOR OR OTHER CONTRIBUTORS BE LIABLE FOR ANY CLAIM, DAMAGES,
+OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF,
+OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+See the License for the specific language governing permissions and limitations under the License.
+*/
+
+package models_test
+
+import (
+	"platform/lib/registry/submission"
+	"platform/process/models"
+	"testing"
+)
+
+func TestDeserializeInput(t *testing.T) {
+	data := []byte(`{"time_stamp": "2021-01-01T00:00:00Z", "data": [1, 2], "units": "m", "device_id": "d", "tags": ["a"]}`)
+	// every registered schema version must be supported
+	for _, v := range submission.Registry.Versions() {
+		got, err := models.DeserializeInput(data, v.Version)
+		if err != nil {
+			t.Fatalf("error for version %d!\nwant: nil\ngot: %v", v.Version, err)
+		}
+		if got.Size() != 2 {
+			t.Fatalf("error for version %d!\nwant: 2 data points\ngot: %d", v.Version, got.Size())
+		}
+	}
+
+	got, err := models.DeserializeInput(data, 0)
+	if err != nil || got.Units != "" {
+		t.Fatalf("error!\nwant: version 1 for the undeclared version\ngot: %+v, %v", got, err)
+	}
+	got, err = models.DeserializeInput(data, 2)
+	if err != nil || got.Units != "m" || got.DeviceID != "d" || len(got.Tags) != 1 {
+		t.Fatalf("error!\nwant: version 2 fields\ngot: %+v, %v", got, err)
+	}
+	if _, err := models.DeserializeInput(data, submission.Registry.Latest().Version+1); err == nil {
+		t.Fatal("error!\nwant: error for the unknown version\ngot: nil")
+	}
+}
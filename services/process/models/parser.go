@@ -0,0 +1,383 @@
+/* Copyright 2021 Dmitry Kisler dkisler.com
+
+Licensed under the Apache License,Version 2.0 (the "License");
+you may not use this file except in compliance with the License. You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED,
+INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE,
+AND NONINFRINGEMENT. IN NO EVENT WILL THE LICENSOR OR OTHER CONTRIBUTORS BE LIABLE FOR ANY CLAIM, DAMAGES,
+OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF,
+OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+See the License for the specific language governing permissions and limitations under the License.
+*/
+
+package models
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// SyntaxError defines the error of the query expression parsing.
+type SyntaxError struct {
+	// Pos defines the 1-based position of the offending character in the expression.
+	Pos int
+	Msg string
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("syntax error at position %d: %s", e.Pos, e.Msg)
+}
+
+// CompileError defines the error of the parsed query expression compilation into the filter.
+// For example, an unknown field, or the value of a wrong type.
+type CompileError struct {
+	// Pos defines the 1-based position of the offending condition in the expression.
+	Pos   int
+	Field string
+	Msg   string
+}
+
+func (e *CompileError) Error() string {
+	return fmt.Sprintf("invalid condition at position %d for the field '%s': %s", e.Pos, e.Field, e.Msg)
+}
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenIdent
+	tokenNumber
+	tokenTimestamp
+	tokenString
+	tokenOperator
+	tokenAnd
+	tokenOr
+)
+
+var tokenNames = map[tokenKind]string{
+	tokenEOF:       "end of expression",
+	tokenIdent:     "field",
+	tokenNumber:    "number",
+	tokenTimestamp: "timestamp",
+	tokenString:    "string",
+	tokenOperator:  "operator",
+	tokenAnd:       "'and'",
+	tokenOr:        "'or'",
+}
+
+type token struct {
+	kind tokenKind
+	// pos defines the 1-based position of the token start.
+	pos  int
+	text string
+	// value defines the literal value.
+	value interface{}
+}
+
+type lexer struct {
+	s   string
+	pos int
+}
+
+func isLiteralChar(c byte) bool {
+	return c == '.' || c == ':' || c == '+' || c == '-' || c == '_' ||
+		('0' <= c && c <= '9') || ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z')
+}
+
+func isIdentChar(c byte) bool {
+	return c == '_' || ('0' <= c && c <= '9') || ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z')
+}
+
+// timestampLayouts defines the supported timestamp formats.
+var timestampLayouts = []string{time.RFC3339Nano, "2006-01-02"}
+
+func parseTimestamp(s string) (time.Time, bool) {
+	for _, l := range timestampLayouts {
+		if t, err := time.Parse(l, s); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+func (l *lexer) next() (*token, error) {
+	for l.pos < len(l.s) && unicode.IsSpace(rune(l.s[l.pos])) {
+		l.pos++
+	}
+	start := l.pos
+	t := &token{pos: start + 1}
+	if l.pos >= len(l.s) {
+		t.kind = tokenEOF
+		return t, nil
+	}
+
+	c := l.s[l.pos]
+	switch {
+	case c == '<' || c == '>' || c == '=' || c == '!':
+		l.pos++
+		if l.pos < len(l.s) && l.s[l.pos] == '=' {
+			l.pos++
+		}
+		t.kind = tokenOperator
+		t.text = l.s[start:l.pos]
+		if t.text == "!" {
+			return nil, &SyntaxError{Pos: t.pos, Msg: "unexpected character '!'"}
+		}
+
+	case c == '"' || c == '\'':
+		l.pos++
+		for l.pos < len(l.s) && l.s[l.pos] != c {
+			if l.s[l.pos] == '\\' {
+				l.pos++
+			}
+			l.pos++
+		}
+		if l.pos >= len(l.s) {
+			return nil, &SyntaxError{Pos: t.pos, Msg: "unterminated string"}
+		}
+		l.pos++
+		t.kind = tokenString
+		t.text = l.s[start:l.pos]
+		raw := t.text
+		if c == '\'' {
+			raw = doubleQuote(raw[1 : len(raw)-1])
+		}
+		v, err := strconv.Unquote(raw)
+		if err != nil {
+			return nil, &SyntaxError{Pos: t.pos, Msg: "invalid escape sequence in string"}
+		}
+		t.value = v
+
+	case c == '-' || c == '+' || c == '.' || ('0' <= c && c <= '9'):
+		for l.pos < len(l.s) && isLiteralChar(l.s[l.pos]) {
+			l.pos++
+		}
+		t.text = l.s[start:l.pos]
+		if v, err := strconv.ParseFloat(t.text, 64); err == nil {
+			t.kind = tokenNumber
+			t.value = v
+		} else if v, ok := parseTimestamp(t.text); ok {
+			t.kind = tokenTimestamp
+			t.value = v
+		} else {
+			return nil, &SyntaxError{Pos: t.pos, Msg: fmt.Sprintf("invalid number or timestamp '%s'", t.text)}
+		}
+
+	case isIdentChar(c):
+		for l.pos < len(l.s) && isIdentChar(l.s[l.pos]) {
+			l.pos++
+		}
+		t.text = l.s[start:l.pos]
+		switch strings.ToLower(t.text) {
+		case "and":
+			t.kind = tokenAnd
+		case "or":
+			t.kind = tokenOr
+		default:
+			t.kind = tokenIdent
+		}
+
+	default:
+		return nil, &SyntaxError{Pos: t.pos, Msg: fmt.Sprintf("unexpected character '%c'", c)}
+	}
+	return t, nil
+}
+
+// comparison defines the parsed condition.
+type comparison struct {
+	field, op, value *token
+}
+
+// parser defines the recursive descent parser of the query expression:
+//
+//	expression = comparison { "and" comparison }
+//	comparison = field operator value
+type parser struct {
+	l   *lexer
+	tok *token
+}
+
+func (p *parser) advance() error {
+	t, err := p.l.next()
+	if err != nil {
+		return err
+	}
+	p.tok = t
+	return nil
+}
+
+func (p *parser) expect(kinds ...tokenKind) (*token, error) {
+	t := p.tok
+	for _, k := range kinds {
+		if t.kind == k {
+			return t, p.advance()
+		}
+	}
+	names := []string{}
+	for _, k := range kinds {
+		names = append(names, tokenNames[k])
+	}
+	got := tokenNames[t.kind]
+	if t.kind != tokenString && t.text != "" {
+		got = fmt.Sprintf("'%s'", t.text)
+	}
+	return nil, &SyntaxError{
+		Pos: t.pos,
+		Msg: fmt.Sprintf("expected %s, got %s", strings.Join(names, " or "), got),
+	}
+}
+
+func (p *parser) comparison() (*comparison, error) {
+	field, err := p.expect(tokenIdent)
+	if err != nil {
+		return nil, err
+	}
+	op, err := p.expect(tokenOperator)
+	if err != nil {
+		return nil, err
+	}
+	value, err := p.expect(tokenNumber, tokenTimestamp, tokenString)
+	if err != nil {
+		return nil, err
+	}
+	return &comparison{field: field, op: op, value: value}, nil
+}
+
+func (p *parser) expression() ([]*comparison, error) {
+	o := []*comparison{}
+	for {
+		c, err := p.comparison()
+		if err != nil {
+			return nil, err
+		}
+		o = append(o, c)
+		switch p.tok.kind {
+		case tokenEOF:
+			return o, nil
+		case tokenOr:
+			return nil, &SyntaxError{Pos: p.tok.pos, Msg: "'or' is not supported, only 'and' can join the conditions"}
+		}
+		if _, err := p.expect(tokenAnd, tokenEOF); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// parse parses the query expression.
+func parse(s string) ([]*comparison, error) {
+	p := &parser{l: &lexer{s: s}}
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+	return p.expression()
+}
+
+// fieldAliases maps the expression field names to the filter fields.
+var fieldAliases = map[string]Field{
+	"timestamp":          FieldTimestamp,
+	"time":               FieldTimestamp,
+	"mean":               FieldMean,
+	"standard_deviation": FieldStddev,
+	"stddev":             FieldStddev,
+	"submitter":          FieldSubmitter,
+	"submitter_id":       FieldSubmitter,
+}
+
+// fieldValues defines the literal type expected for the field.
+var fieldValues = map[Field]tokenKind{
+	FieldTimestamp: tokenTimestamp,
+	FieldMean:      tokenNumber,
+	FieldStddev:    tokenNumber,
+	FieldSubmitter: tokenString,
+}
+
+var operators = map[string]Operator{
+	"=":  OpEqual,
+	"==": OpEqual,
+	"<":  OpLess,
+	"<=": OpLessEqual,
+	">":  OpGreater,
+	">=": OpGreaterEqual,
+}
+
+func (c *comparison) compile() (*Condition, error) {
+	fail := func(msg string, args ...interface{}) error {
+		return &CompileError{Pos: c.field.pos, Field: c.field.text, Msg: fmt.Sprintf(msg, args...)}
+	}
+	field, ok := fieldAliases[strings.ToLower(c.field.text)]
+	if !ok {
+		return nil, fail("unknown field, supported fields: timestamp, mean, standard_deviation, submitter")
+	}
+	op, ok := operators[c.op.text]
+	if !ok {
+		return nil, fail("unsupported operator '%s'", c.op.text)
+	}
+	value := c.value.value
+	// a timestamp could be quoted
+	if field == FieldTimestamp && c.value.kind == tokenString {
+		t, ok := parseTimestamp(value.(string))
+		if !ok {
+			return nil, fail("invalid timestamp, RFC3339 format is expected")
+		}
+		value = t
+	} else if c.value.kind != fieldValues[field] {
+		return nil, fail("expected %s value, got %s", tokenNames[fieldValues[field]], tokenNames[c.value.kind])
+	}
+	if field == FieldSubmitter && op != OpEqual {
+		return nil, fail("only equality comparison is supported")
+	}
+	return &Condition{Field: field, Op: op, Value: value}, nil
+}
+
+// ParseFilter parses the query expression and compiles it into the filter, e.g.
+//
+//	mean > 1.5 and timestamp >= 2021-01-01T00:00:00Z and submitter = "acme"
+//
+// The supported fields are timestamp, mean, standard_deviation and submitter.
+// The blank expression results in the empty filter.
+func ParseFilter(s string) (Filter, error) {
+	if strings.TrimSpace(s) == "" {
+		return Filter{}, nil
+	}
+	comparisons, err := parse(s)
+	if err != nil {
+		return nil, err
+	}
+	f := Filter{}
+	for _, c := range comparisons {
+		cond, err := c.compile()
+		if err != nil {
+			return nil, err
+		}
+		f = append(f, cond)
+	}
+	return f, nil
+}
+
+// doubleQuote converts the body of the single-quoted string into the double-quoted string.
+func doubleQuote(s string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] == '"':
+			b.WriteString(`\"`)
+		case s[i] == '\\' && i+1 < len(s) && s[i+1] == '\'':
+			b.WriteByte('\'')
+			i++
+		case s[i] == '\\' && i+1 < len(s):
+			b.WriteString(s[i : i+2])
+			i++
+		default:
+			b.WriteByte(s[i])
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
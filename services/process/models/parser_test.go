@@ -0,0 +1,172 @@
+/* Copyright 2021 Dmitry Kisler dkisler.com
+
+Licensed under the Apache License,Version 2.0 (the "License");
+you may not use this file except in compliance with the License. You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED,
+INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE,
+AND NONINFRINGEMENT. IN NO EVENT WILL THE LICENSOR OR OTHER CONTRIBUTORS BE LIABLE FOR ANY CLAIM, DAMAGES,
+OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF,
+OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+See the License for the specific language governing permissions and limitations under the License.
+*/
+
+package models_test
+
+import (
+	"platform/process/models"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/goccy/go-json"
+)
+
+func TestParseFilter(t *testing.T) {
+	ts := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		in   string
+		want models.Filter
+	}{
+		{
+			in:   "",
+			want: models.Filter{},
+		},
+		{
+			in: `mean > 1.5 and timestamp >= 2021-01-01T00:00:00Z and submitter = "acme"`,
+			want: models.Filter{
+				{Field: models.FieldMean, Op: models.OpGreater, Value: 1.5},
+				{Field: models.FieldTimestamp, Op: models.OpGreaterEqual, Value: ts},
+				{Field: models.FieldSubmitter, Op: models.OpEqual, Value: "acme"},
+			},
+		},
+		{
+			in: `stddev>=0 AND stddev<-1e3`,
+			want: models.Filter{
+				{Field: models.FieldStddev, Op: models.OpGreaterEqual, Value: 0.},
+				{Field: models.FieldStddev, Op: models.OpLess, Value: -1e3},
+			},
+		},
+		{
+			in: `timestamp <= '2021-01-01' and submitter_id == 'a\'b'`,
+			want: models.Filter{
+				{Field: models.FieldTimestamp, Op: models.OpLessEqual, Value: ts},
+				{Field: models.FieldSubmitter, Op: models.OpEqual, Value: "a'b"},
+			},
+		},
+	}
+	for _, test := range tests {
+		got, err := models.ParseFilter(test.in)
+		if err != nil {
+			t.Fatalf("unexpected error for %s: %v", test.in, err)
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Fatalf("error!\nwant: %v\ngot: %v", test.want, got)
+		}
+	}
+}
+
+func TestParseFilterErrors(t *testing.T) {
+	tests := []struct {
+		in      string
+		pos     int
+		compile bool
+	}{
+		{in: "mean >", pos: 7},
+		{in: "mean 1.5", pos: 6},
+		{in: "mean > 1.5 mean < 2", pos: 12},
+		{in: "mean > 1.5 or mean < 0", pos: 12},
+		{in: `submitter = "acme`, pos: 13},
+		{in: "mean > 1.5.5", pos: 8},
+		{in: "mean ! 1", pos: 6},
+		{in: "mean > 1 and (stddev < 1)", pos: 14},
+		{in: "median > 1", pos: 1, compile: true},
+		{in: "mean > 1 and timestamp > 1", pos: 14, compile: true},
+		{in: `mean > 1 and submitter > "a"`, pos: 14, compile: true},
+		{in: `mean != 1`, pos: 1, compile: true},
+		{in: `timestamp > "yesterday"`, pos: 1, compile: true},
+	}
+	for _, test := range tests {
+		_, err := models.ParseFilter(test.in)
+		var pos int
+		switch e := err.(type) {
+		case *models.SyntaxError:
+			if test.compile {
+				t.Fatalf("error for %s!\nwant: compile error\ngot: %v", test.in, err)
+			}
+			pos = e.Pos
+		case *models.CompileError:
+			if !test.compile {
+				t.Fatalf("error for %s!\nwant: syntax error\ngot: %v", test.in, err)
+			}
+			pos = e.Pos
+		default:
+			t.Fatalf("error for %s!\nwant: typed error\ngot: %v", test.in, err)
+		}
+		if pos != test.pos {
+			t.Fatalf("error for %s!\nwant position: %d\ngot: %d (%v)", test.in, test.pos, pos, err)
+		}
+	}
+}
+
+func TestQueryFilter(t *testing.T) {
+	min, max := 1., 2.
+	q, err := models.DeserializeQuery([]byte(`{"mean": {"min": 1, "max": 2}, "standard_deviation": {"min": 0}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		in   *models.Query
+		want models.Filter
+	}{
+		{
+			in:   nil,
+			want: models.Filter{},
+		},
+		{
+			in: q,
+			want: models.Filter{
+				{Field: models.FieldMean, Op: models.OpGreaterEqual, Value: min},
+				{Field: models.FieldMean, Op: models.OpLessEqual, Value: max},
+				{Field: models.FieldStddev, Op: models.OpGreaterEqual, Value: 0.},
+			},
+		},
+	}
+	for _, test := range tests {
+		got := test.in.Filter()
+		if !reflect.DeepEqual(got, test.want) {
+			t.Fatalf("error!\nwant: %v\ngot: %v", test.want, got)
+		}
+	}
+}
+
+func TestFilterMatch(t *testing.T) {
+	var results models.QueryResults
+	err := json.Unmarshal([]byte(`[{"submitter_id": "acme", "payload": {"timestamp": "2021-06-01T00:00:00Z", "mean": 2, "standard_deviation": 0.5}}]`), &results)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		in   string
+		want bool
+	}{
+		{in: "", want: true},
+		{in: `mean > 1.5 and timestamp >= 2021-01-01 and submitter = "acme"`, want: true},
+		{in: "mean >= 2 and mean <= 2", want: true},
+		{in: "mean > 2", want: false},
+		{in: "stddev < 0.5", want: false},
+		{in: "timestamp < 2021-06-01", want: false},
+		{in: `submitter = "other"`, want: false},
+	}
+	for _, test := range tests {
+		f, err := models.ParseFilter(test.in)
+		if err != nil {
+			t.Fatalf("unexpected error for %s: %v", test.in, err)
+		}
+		if got := f.Match(&results[0]); got != test.want {
+			t.Fatalf("error for %s!\nwant: %v\ngot: %v", test.in, test.want, got)
+		}
+	}
+}
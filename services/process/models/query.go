@@ -16,11 +16,8 @@ See the License for the specific language governing permissions and limitations
 package models
 
 import (
-	_ "embed"
 	"time"
 
-	"platform/lib/jsonschema"
-
 	"github.com/goccy/go-json"
 )
 
@@ -42,17 +39,8 @@ type Query struct {
 }
 
 // DeserializeQuery deserializes the data.
-func DeserializeQuery(data []byte) (q *Query) {
-	json.Unmarshal(data, &q)
+// The query is validated against the schema request_query.json by the router.
+func DeserializeQuery(data []byte) (q *Query, err error) {
+	err = json.Unmarshal(data, &q)
 	return
 }
-
-//go:embed request_query.json
-var schema []byte
-
-var s, _ = jsonschema.NewSchema(schema)
-
-// ValidateQuery validates the object.
-func ValidateQuery(data []byte) error {
-	return s.ValidateBytes(data)
-}
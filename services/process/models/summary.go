@@ -0,0 +1,90 @@
+/* Copyright 2021 Dmitry Kisler dkisler.com
+
+Licensed under the Apache License,Version 2.0 (the "License");
+you may not use this file except in compliance with the License. You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED,
+INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE,
+AND NONINFRINGEMENT. IN NO EVENT WILL THE LICENSOR OR OTHER CONTRIBUTORS BE LIABLE FOR ANY CLAIM, DAMAGES,
+OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF,
+OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+See the License for the specific language governing permissions and limitations under the License.
+*/
+
+package models
+
+import (
+	"time"
+
+	"github.com/goccy/go-json"
+)
+
+// Summary defines the summary of the query results.
+type Summary struct {
+	// Count defines the number of results.
+	Count int `json:"count"`
+	// PayloadTimestamp defines the range of the results timestamp.
+	PayloadTimestamp *queryTimestamp `json:"timestamp,omitempty"`
+	// PayloadMean defines the range of the results mean values.
+	PayloadMean *queryFloat `json:"mean,omitempty"`
+	// PayloadStddev defines the range of the results standard deviation values.
+	PayloadStddev *queryFloat `json:"standard_deviation,omitempty"`
+}
+
+func (r *queryFloat) add(v float64) {
+	if r.Min == nil || v < *r.Min {
+		r.Min = &v
+	}
+	if r.Max == nil || v > *r.Max {
+		r.Max = &v
+	}
+}
+
+func (r *queryTimestamp) add(v time.Time) {
+	if r.Min == nil || v.Before(*r.Min) {
+		r.Min = &v
+	}
+	if r.Max == nil || v.After(*r.Max) {
+		r.Max = &v
+	}
+}
+
+// Add adds the query result to the summary.
+func (s *Summary) Add(o *outputProcessing) {
+	s.Count++
+	if o.Payload == nil {
+		return
+	}
+	if s.PayloadTimestamp == nil {
+		s.PayloadTimestamp = &queryTimestamp{}
+		s.PayloadMean = &queryFloat{}
+		s.PayloadStddev = &queryFloat{}
+	}
+	s.PayloadTimestamp.add(o.Payload.Time)
+	s.PayloadMean.add(o.Payload.Mean)
+	s.PayloadStddev.add(o.Payload.Stddev)
+}
+
+// MustSerialize serializes the summary.
+func (s *Summary) MustSerialize() []byte {
+	o, _ := json.Marshal(s)
+	return o
+}
+
+// SummarizeResults scans the query results to summarize them.
+func SummarizeResults(it ResultIterator) (*Summary, error) {
+	s := &Summary{}
+	for {
+		var o outputProcessing
+		ok, err := it.Next(&o)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			return s, nil
+		}
+		s.Add(&o)
+	}
+}
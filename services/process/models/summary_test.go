@@ -0,0 +1,72 @@
+/* Copyright 2021 Dmitry Kisler dkisler.com
+
+Licensed under the Apache License,Version 2.0 (the "License");
+you may not use this file except in compliance with the License. You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED,
+INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE,
+AND NONINFRINGEMENT. IN NO EVENT WILL THE LICENSOR OR OTHER CONTRIBUTORS BE LIABLE FOR ANY CLAIM, DAMAGES,
+OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF,
+OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+See the License for the specific language governing permissions and limitations under the License.
+*/
+
+package models_test
+
+import (
+	"platform/process/models"
+	"testing"
+	"time"
+
+	"github.com/goccy/go-json"
+)
+
+// iterator defines the fake store iterator over the serialized results.
+type iterator struct {
+	results []string
+}
+
+func (i *iterator) Next(dst interface{}) (bool, error) {
+	if len(i.results) == 0 {
+		return false, nil
+	}
+	o := i.results[0]
+	i.results = i.results[1:]
+	return true, json.Unmarshal([]byte(o), dst)
+}
+
+func TestSummarizeResults(t *testing.T) {
+	got, err := models.SummarizeResults(&iterator{
+		results: []string{
+			`{"payload": {"timestamp": "2021-07-08T10:00:00Z", "mean": 1.5, "standard_deviation": 0.5}}`,
+			`{"payload": {"timestamp": "2021-07-08T09:00:00Z", "mean": 2.5, "standard_deviation": 0.1}}`,
+			`{"payload": {"timestamp": "2021-07-08T11:00:00Z", "mean": -1, "standard_deviation": 1}}`,
+		},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got.Count != 3 {
+		t.Fatalf("summary fail!\nwant count: 3\ngot: %d", got.Count)
+	}
+	if !got.PayloadTimestamp.Min.Equal(time.Date(2021, 7, 8, 9, 0, 0, 0, time.UTC)) ||
+		!got.PayloadTimestamp.Max.Equal(time.Date(2021, 7, 8, 11, 0, 0, 0, time.UTC)) {
+		t.Fatalf("summary fail!\nwrong timestamp range: %v - %v", got.PayloadTimestamp.Min, got.PayloadTimestamp.Max)
+	}
+	if *got.PayloadMean.Min != -1 || *got.PayloadMean.Max != 2.5 {
+		t.Fatalf("summary fail!\nwrong mean range: %v - %v", *got.PayloadMean.Min, *got.PayloadMean.Max)
+	}
+	if *got.PayloadStddev.Min != 0.1 || *got.PayloadStddev.Max != 1 {
+		t.Fatalf("summary fail!\nwrong standard deviation range: %v - %v", *got.PayloadStddev.Min, *got.PayloadStddev.Max)
+	}
+
+	got, err = models.SummarizeResults(&iterator{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want := `{"count":0}`; string(got.MustSerialize()) != want {
+		t.Fatalf("summary fail!\nwant: %s\ngot: %s", want, got.MustSerialize())
+	}
+}
@@ -0,0 +1,228 @@
+/* Copyright 2021 Dmitry Kisler dkisler.com
+
+Licensed under the Apache License,Version 2.0 (the "License");
+you may not use this file except in compliance with the License. You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED,
+INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE,
+AND NONINFRINGEMENT. IN NO EVENT WILL THE LICENSOR OR OTHER CONTRIBUTORS BE LIABLE FOR ANY CLAIM, DAMAGES,
+OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF,
+OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+See the License for the specific language governing permissions and limitations under the License.
+*/
+
+package main
+
+import (
+	_ "embed"
+	"encoding/json"
+	httpStatus "net/http"
+	"platform/lib/api/http"
+	"platform/lib/api/openapi"
+	"platform/process/export"
+)
+
+// openAPIInfo defines the metadata of the service's OpenAPI document.
+var openAPIInfo = openapi.Info{
+	Title:       "process",
+	Description: "Processed data.",
+	Version:     "v1.0",
+}
+
+var (
+	//go:embed models/request_query.json
+	requestQuerySchema []byte
+	//go:embed models/response.json
+	responseSchema []byte
+	//go:embed models/response_summary.json
+	responseSummarySchema []byte
+)
+
+var (
+	processQueryReq    = http.NewSchema("process_query_req", requestQuerySchema)
+	processResp        = http.NewSchema("process_resp", responseSchema)
+	processRespSummary = http.NewSchema("process_resp_summary", responseSummarySchema)
+)
+
+// exportFormats defines the output formats of the processed data in the order of preference.
+var exportFormats = []*export.Format{export.JSON, export.NDJSON, export.CSV, export.Parquet, export.Arrow}
+
+func exportContentTypes() []string {
+	o := make([]string, len(exportFormats))
+	for i, f := range exportFormats {
+		o[i] = f.ContentType
+	}
+	return o
+}
+
+func formatParameterSchema() json.RawMessage {
+	names := make([]string, len(exportFormats))
+	for i, f := range exportFormats {
+		names[i] = f.Name
+	}
+	b, _ := json.Marshal(map[string]interface{}{"type": "string", "enum": names, "default": export.JSON.Name})
+	return b
+}
+
+var (
+	limitParameter = openapi.Parameter{
+		Name:        "limit",
+		In:          openapi.InQuery,
+		Description: "Limit the length of response list.",
+		Schema:      json.RawMessage(`{"type": "integer", "minimum": 0, "default": 100}`),
+	}
+	offsetParameter = openapi.Parameter{
+		Name:        "offset",
+		In:          openapi.InQuery,
+		Description: "How many db records to be skipped when reading.",
+		Schema:      json.RawMessage(`{"type": "integer", "minimum": 0, "default": 0}`),
+	}
+	formatParameter = openapi.Parameter{
+		Name:        "format",
+		In:          openapi.InQuery,
+		Description: "Output format, it takes precedence over the Accept header.",
+		Schema:      formatParameterSchema(),
+	}
+	filterParameter = openapi.Parameter{
+		Name:        "q",
+		In:          openapi.InQuery,
+		Description: `Filter expression, e.g. mean > 1.5 and timestamp >= 2021-01-01T00:00:00Z and submitter = "acme".`,
+		Schema:      json.RawMessage(`{"type": "string"}`),
+	}
+)
+
+var processDoc = &http.EndpointDoc{
+	ID:          "processRawData",
+	Description: "Process the raw data sample pushed by the message bus subscription.",
+	Responses: map[int]*http.ResponseDoc{
+		httpStatus.StatusOK: {Description: "Success"},
+	},
+}
+
+var fetchDoc = &http.EndpointDoc{
+	ID:          "fetchProcessedData",
+	Description: "Bulk fetch processed data.",
+	Parameters:  []openapi.Parameter{limitParameter, offsetParameter, formatParameter, filterParameter},
+	Responses: map[int]*http.ResponseDoc{
+		httpStatus.StatusOK:            {Description: "Success", Schema: processResp, ContentTypes: exportContentTypes()},
+		httpStatus.StatusBadRequest:    {Description: "Invalid filter expression", Schema: http.ErrorSchema},
+		httpStatus.StatusNotAcceptable: {Description: "Unsupported output format", Schema: http.ErrorSchema},
+	},
+}
+
+var queryDoc = &http.EndpointDoc{
+	ID:                 "fetchProcessedDataWithFilter",
+	Description:        "Bulk fetch pre-filtered processed data.",
+	Parameters:         []openapi.Parameter{limitParameter, offsetParameter, formatParameter},
+	Request:            processQueryReq,
+	RequestDescription: "Filtering query to fetch processed data.",
+	Responses: map[int]*http.ResponseDoc{
+		httpStatus.StatusOK:            {Description: "Success", Schema: processResp, ContentTypes: exportContentTypes()},
+		httpStatus.StatusBadRequest:    {Description: "Invalid query", Schema: http.ErrorSchema},
+		httpStatus.StatusNotAcceptable: {Description: "Unsupported output format", Schema: http.ErrorSchema},
+	},
+}
+
+var summaryDoc = &http.EndpointDoc{
+	ID:          "summarizeProcessedData",
+	Description: "Count and summarize processed data, all data unless the filter expression is set.",
+	Parameters:  []openapi.Parameter{filterParameter},
+	Responses: map[int]*http.ResponseDoc{
+		httpStatus.StatusOK:         {Description: "Success", Schema: processRespSummary},
+		httpStatus.StatusBadRequest: {Description: "Invalid filter expression", Schema: http.ErrorSchema},
+	},
+}
+
+var summaryQueryDoc = &http.EndpointDoc{
+	ID:                 "summarizeProcessedDataWithFilter",
+	Description:        "Count and summarize the pre-filtered processed data.",
+	Request:            processQueryReq,
+	RequestDescription: "Filtering query to summarize processed data.",
+	// the summary of all processed data is returned if no query is submitted
+	RequestOptional: true,
+	Responses: map[int]*http.ResponseDoc{
+		httpStatus.StatusOK:         {Description: "Success", Schema: processRespSummary},
+		httpStatus.StatusBadRequest: {Description: "Invalid query", Schema: http.ErrorSchema},
+	},
+}
+
+var (
+	lastEventIDParameters = []openapi.Parameter{
+		{
+			Name:        "Last-Event-ID",
+			In:          openapi.InHeader,
+			Description: "Id of the last received event to resume the subscription after.",
+			Schema:      json.RawMessage(`{"type": "string"}`),
+		},
+		{
+			Name:        "last_event_id",
+			In:          openapi.InQuery,
+			Description: "Id of the last received event, used if the Last-Event-ID header is not set.",
+			Schema:      json.RawMessage(`{"type": "string"}`),
+		},
+	}
+	subscribeResponses = map[int]*http.ResponseDoc{
+		httpStatus.StatusOK: {
+			Description:  "Stream of events, each event data is a processed data element.",
+			ContentTypes: []string{"text/event-stream"},
+		},
+		httpStatus.StatusBadRequest: {Description: "Invalid filter expression", Schema: http.ErrorSchema},
+	}
+)
+
+var subscribeDoc = &http.EndpointDoc{
+	ID:          "subscribeProcessedData",
+	Description: "Subscribe to newly processed data streamed as Server-Sent Events.",
+	Parameters:  append([]openapi.Parameter{filterParameter}, lastEventIDParameters...),
+	Responses:   subscribeResponses,
+}
+
+var subscribeQueryDoc = &http.EndpointDoc{
+	ID:                 "subscribeProcessedDataWithFilter",
+	Description:        "Subscribe to newly processed pre-filtered data streamed as Server-Sent Events.",
+	Parameters:         lastEventIDParameters,
+	Request:            processQueryReq,
+	RequestDescription: "Filtering query of the subscription.",
+	// all newly processed data is streamed if no query is submitted
+	RequestOptional: true,
+	Responses:       subscribeResponses,
+}
+
+var listDeadLettersDoc = &http.EndpointDoc{
+	ID:          "listDeadLetters",
+	Description: "List the submissions failed to be processed.",
+	Parameters:  []openapi.Parameter{limitParameter, offsetParameter},
+	Responses: map[int]*http.ResponseDoc{
+		httpStatus.StatusOK: {Description: "Success"},
+	},
+}
+
+var replayDeadLettersDoc = &http.EndpointDoc{
+	ID:          "replayDeadLetters",
+	Description: "Replay the submissions failed to be processed.",
+	Parameters:  []openapi.Parameter{limitParameter, offsetParameter},
+	Responses: map[int]*http.ResponseDoc{
+		httpStatus.StatusOK: {Description: "Replay results"},
+	},
+}
+
+var inspectDeadLetterDoc = &http.EndpointDoc{
+	ID:          "inspectDeadLetter",
+	Description: "Inspect the submission failed to be processed.",
+	Responses: map[int]*http.ResponseDoc{
+		httpStatus.StatusOK:       {Description: "Success"},
+		httpStatus.StatusNotFound: {Description: "Dead letter not found", Schema: http.ErrorSchema},
+	},
+}
+
+var replayDeadLetterDoc = &http.EndpointDoc{
+	ID:          "replayDeadLetter",
+	Description: "Replay the submission failed to be processed.",
+	Responses: map[int]*http.ResponseDoc{
+		httpStatus.StatusOK:                  {Description: "Replay result"},
+		httpStatus.StatusNotFound:            {Description: "Dead letter not found", Schema: http.ErrorSchema},
+		httpStatus.StatusUnprocessableEntity: {Description: "Replay failed"},
+	},
+}
@@ -0,0 +1,54 @@
+/* Copyright 2021 Dmitry Kisler dkisler.com
+
+Licensed under the Apache License,Version 2.0 (the "License");
+you may not use this file except in compliance with the License. You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED,
+INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE,
+AND NONINFRINGEMENT. IN NO EVENT WILL THE LICENSOR OR OTHER CONTRIBUTORS BE LIABLE FOR ANY CLAIM, DAMAGES,
+OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF,
+OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+See the License for the specific language governing permissions and limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"platform/lib/health"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "update the OpenAPI document")
+
+// TestOpenAPIDocument checks that the committed OpenAPI document is up to date,
+// the API gateway specification is generated from it.
+// Run `go test -run TestOpenAPIDocument -update` to update it.
+func TestOpenAPIDocument(t *testing.T) {
+	const path = "openapi.json"
+	handlers := newHandlers(health.NewChecker())
+	doc, err := handlers.OpenAPI(openAPIInfo)
+	if err != nil {
+		t.Fatalf("error!\nwant: OpenAPI document\ngot: %v", err)
+	}
+	got, err := doc.Serialize()
+	if err != nil {
+		t.Fatalf("error!\nwant: serialized document\ngot: %v", err)
+	}
+	if *update {
+		if err := os.WriteFile(path, got, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("error!\nwant: %s\ngot: %v", path, err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Fatalf("error!\nwant: %s up to date\ngot: %s", path, got)
+	}
+}
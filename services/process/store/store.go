@@ -19,24 +19,88 @@ package store
 
 import (
 	"context"
+	"fmt"
+	"platform/lib/metrics"
+	"platform/lib/retry"
 	"platform/process/models"
+	"reflect"
 	"time"
 
 	"cloud.google.com/go/datastore"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	otelcodes "go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/api/iterator"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
-var bg = context.Background()
+const (
+	timeoutDefault       = 20 * time.Second
+	timeoutStreamDefault = 10 * time.Minute
+)
+
+var (
+	operationDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "datastore_operation_duration_seconds",
+		Help:    "Latency of the store operations in seconds, including retries.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"operation", "collection", "status"})
+	writeFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "datastore_write_failures_total",
+		Help: "Number of the store write operations failed after retries.",
+	}, []string{"operation", "collection"})
+	// tracer starts the spans of the store operations
+	tracer = otel.Tracer("platform/process/store")
+)
 
-const timeoutDefault = 20 * time.Second
+// writeOperations defines the operations modifying the store.
+var writeOperations = map[string]bool{"write": true, "upsert": true, "delete": true}
+
+// instrument starts the span and the latency measurement of the operation.
+// The returned function ends them with the operation error.
+func instrument(ctx context.Context, operation, collection string) (context.Context, func(err error)) {
+	start := time.Now()
+	ctx, span := tracer.Start(ctx, "datastore."+operation,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attribute.String("datastore.collection", collection)),
+	)
+	return ctx, func(err error) {
+		operationDuration.WithLabelValues(operation, collection, metrics.Status(err)).Observe(time.Since(start).Seconds())
+		if err != nil && writeOperations[operation] {
+			writeFailures.WithLabelValues(operation, collection).Inc()
+		}
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(otelcodes.Error, err.Error())
+		}
+		span.End()
+	}
+}
 
 // Config contains configuration for the Datastore query runner.
 type Config struct {
-	timeout time.Duration
+	timeout       time.Duration
+	timeoutStream time.Duration
+	retry         *retry.Policy
 }
 
 // NewConfig return configuration for the Datastore client.
 func NewConfig() *Config {
-	return &Config{timeout: timeoutDefault}
+	return &Config{
+		timeout:       timeoutDefault,
+		timeoutStream: timeoutStreamDefault,
+		retry:         retry.NewPolicy().WithClassifier(isRetryable),
+	}
+}
+
+// isRetryable classifies the transient errors except of the unavailable service,
+// the datastore client retries the calls failed with the UNAVAILABLE code itself.
+func isRetryable(err error) bool {
+	return status.Code(err) != codes.Unavailable && retry.IsRetryable(err)
 }
 
 // WithTimeout sets the operation timeout.
@@ -45,6 +109,20 @@ func (c *Config) WithTimeout(t time.Duration) *Config {
 	return c
 }
 
+// WithStreamTimeout sets the timeout to iterate over the query results.
+func (c *Config) WithStreamTimeout(t time.Duration) *Config {
+	c.timeoutStream = t
+	return c
+}
+
+// WithRetry sets the retry policy of the operations.
+// The operations are retried within the operation timeout.
+// The iteration over the query results is not retried.
+func (c *Config) WithRetry(p *retry.Policy) *Config {
+	c.retry = p
+	return c
+}
+
 // Client defines the client to interact with datastore
 type Client struct {
 	c   *datastore.Client
@@ -52,8 +130,8 @@ type Client struct {
 }
 
 // NewClient init a new datastore client.
-func NewClient(projectID string) (*Client, error) {
-	c, err := datastore.NewClient(bg, projectID)
+func NewClient(ctx context.Context, projectID string) (*Client, error) {
+	c, err := datastore.NewClient(ctx, projectID)
 	return &Client{c: c, cfg: NewConfig()}, err
 }
 
@@ -63,55 +141,279 @@ func (c *Client) WithCfg(cfg *Config) *Client {
 	return c
 }
 
+// Close closes the client.
+func (c *Client) Close() error {
+	return c.c.Close()
+}
+
 // Write writes object to the store.
-func (c *Client) Write(collection string, obj interface{}) error {
-	ctx, cancel := context.WithTimeout(bg, c.cfg.timeout)
+// The object is written with the new key, hence the retried write can duplicate the object
+// if the acknowledgement of the successful attempt is lost.
+func (c *Client) Write(ctx context.Context, collection string, obj interface{}) (err error) {
+	ctx, done := instrument(ctx, "write", collection)
+	defer func() { done(err) }()
+	ctx, cancel := context.WithTimeout(ctx, c.cfg.timeout)
 	defer cancel()
 	newKey := datastore.IncompleteKey(collection, nil)
-	_, err := c.c.Put(ctx, newKey, obj)
+	return c.cfg.retry.Do(ctx, func(ctx context.Context) error {
+		_, err := c.c.Put(ctx, newKey, obj)
+		return err
+	})
+}
+
+// Check checks the collection can be queried, e.g. for the readiness probe.
+func (c *Client) Check(ctx context.Context, collection string) error {
+	_, err := c.c.GetAll(ctx, datastore.NewQuery(collection).KeysOnly().Limit(1), nil)
 	return err
 }
 
-// Read read object(s) from the store according to the query.
-// The method requires the collection and the query objects to identify the output
+// ErrNotFound is returned when the object does not exist in the store.
+var ErrNotFound = datastore.ErrNoSuchEntity
+
+// Get reads the object identified by the key from the store.
+// ErrNotFound is returned if the object does not exist.
+func (c *Client) Get(ctx context.Context, collection, key string, out interface{}) (err error) {
+	ctx, done := instrument(ctx, "get", collection)
+	defer func() { done(err) }()
+	ctx, cancel := context.WithTimeout(ctx, c.cfg.timeout)
+	defer cancel()
+	return c.cfg.retry.Do(ctx, func(ctx context.Context) error {
+		return c.c.Get(ctx, datastore.NameKey(collection, key, nil), out)
+	})
+}
+
+// Upsert reads the object identified by the key into obj, applies the update
+// and writes the object back to the store in a single transaction.
+// If the object does not exist, the update is applied to obj as is.
+func (c *Client) Upsert(ctx context.Context, collection, key string, obj interface{}, update func()) (err error) {
+	ctx, done := instrument(ctx, "upsert", collection)
+	defer func() { done(err) }()
+	ctx, cancel := context.WithTimeout(ctx, c.cfg.timeout)
+	defer cancel()
+	k := datastore.NameKey(collection, key, nil)
+	v := reflect.ValueOf(obj).Elem()
+	return c.cfg.retry.Do(ctx, func(ctx context.Context) error {
+		_, err := c.c.RunInTransaction(ctx, func(tx *datastore.Transaction) error {
+			// the transaction function is retried on contention
+			v.Set(reflect.Zero(v.Type()))
+			if err := tx.Get(k, obj); err != nil && err != datastore.ErrNoSuchEntity {
+				return err
+			}
+			update()
+			_, err := tx.Put(k, obj)
+			return err
+		})
+		return err
+	})
+}
+
+// Delete deletes the object identified by the key from the store.
+func (c *Client) Delete(ctx context.Context, collection, key string) (err error) {
+	ctx, done := instrument(ctx, "delete", collection)
+	defer func() { done(err) }()
+	ctx, cancel := context.WithTimeout(ctx, c.cfg.timeout)
+	defer cancel()
+	return c.cfg.retry.Do(ctx, func(ctx context.Context) error {
+		return c.c.Delete(ctx, datastore.NameKey(collection, key, nil))
+	})
+}
+
+// properties maps the filter fields to the datastore properties.
+var properties = map[models.Field]string{
+	models.FieldTimestamp: "Payload.Time",
+	models.FieldMean:      "Payload.Mean",
+	models.FieldStddev:    "Payload.Stddev",
+	models.FieldSubmitter: "SubmitterID",
+}
+
+// split splits the filter into the conditions datastore applies using the built-in
+// single-property indexes, i.e. the conditions on the first filter field,
+// and the residual conditions to be matched against the fetched entities.
+func split(filter models.Filter) (native, residual models.Filter) {
+	for _, c := range filter {
+		if c.Field == filter[0].Field {
+			native = append(native, c)
+		} else {
+			residual = append(residual, c)
+		}
+	}
+	return native, residual
+}
+
+// filterQuery defines the datastore query with the filters only.
+func filterQuery(collection string, filter models.Filter) *datastore.Query {
+	q := datastore.NewQuery(collection)
+	for _, c := range filter {
+		q = q.Filter(fmt.Sprintf("%s %s", properties[c.Field], c.Op), c.Value)
+	}
+	return q
+}
+
+// Read read object(s) from the store according to the filter.
+// The method requires the collection and the filter objects to identify the output
 // If collection is left empty, the query runs across all collections
 // - limit defines the number of results to be returned
 // - offset defines how many query results to be jumped over
-// - out is the pointer to the object expected to be returned from db
-func (c *Client) Read(collection string, query *models.Query, limit, offset int, out interface{}) error {
-	ctx, cancel := context.WithTimeout(bg, c.cfg.timeout)
+// - out is the pointer to the slice of objects expected to be returned from db
+func (c *Client) Read(ctx context.Context, collection string, filter models.Filter, limit, offset int, out interface{}) (err error) {
+	ctx, done := instrument(ctx, "read", collection)
+	defer func() { done(err) }()
+	ctx, cancel := context.WithTimeout(ctx, c.cfg.timeout)
 	defer cancel()
+	limit, offset = page(limit, offset)
+	v := reflect.ValueOf(out).Elem()
+	return c.cfg.retry.Do(ctx, func(ctx context.Context) error {
+		v.Set(reflect.Zero(v.Type()))
+		it := c.iterate(ctx, collection, filter, limit, offset)
+		for {
+			el := reflect.New(v.Type().Elem())
+			ok, err := it.Next(el.Interface())
+			if err != nil {
+				return err
+			}
+			if !ok {
+				return nil
+			}
+			v.Set(reflect.Append(v, el.Elem()))
+		}
+	})
+}
+
+// Iterator defines the iterator over the query results.
+// It fetches the results from the store in batches, hence the memory footprint
+// does not depend on the number of results.
+type Iterator struct {
+	it     *datastore.Iterator
+	cancel context.CancelFunc
+	// residual defines the filter conditions datastore cannot apply.
+	residual models.Filter
+	// limit and offset are applied by the iterator if the residual filter is set.
+	limit, offset int
+	// err defines the error the iteration failed with.
+	err error
+}
+
+// Iterate runs the filter query and returns the iterator over its results.
+// The arguments are the same as for the method Read.
+// The iterator must be closed after use.
+func (c *Client) Iterate(ctx context.Context, collection string, filter models.Filter, limit, offset int) *Iterator {
+	ctx, done := instrument(ctx, "iterate", collection)
+	ctx, cancel := context.WithTimeout(ctx, c.cfg.timeoutStream)
+	limit, offset = page(limit, offset)
+	it := c.iterate(ctx, collection, filter, limit, offset)
+	it.cancel = func() {
+		cancel()
+		done(it.err)
+	}
+	return it
+}
+
+// page sets the default limit and offset.
+func page(limit, offset int) (int, int) {
 	if limit <= 0 {
 		limit = 100
 	}
 	if offset < 0 {
 		offset = 0
 	}
-	q := datastore.NewQuery(collection).Offset(offset).Limit(limit)
-	if query != nil {
-		if query.PayloadTimestamp != nil {
-			if query.PayloadTimestamp.Min != nil {
-				q = q.Filter("Payload.Time >=", query.PayloadTimestamp.Min)
-			}
-			if query.PayloadTimestamp.Max != nil {
-				q = q.Filter("Payload.Time <=", query.PayloadTimestamp.Max)
-			}
-		} else if query.PayloadMean != nil {
-			if query.PayloadMean.Min != nil {
-				q = q.Filter("Payload.Mean >=", query.PayloadMean.Min)
-			}
-			if query.PayloadMean.Max != nil {
-				q = q.Filter("Payload.Mean <=", query.PayloadMean.Max)
-			}
-		} else if query.PayloadStddev != nil {
-			if query.PayloadStddev.Min != nil {
-				q = q.Filter("Payload.Stddev >=", query.PayloadStddev.Min)
-			}
-			if query.PayloadStddev.Max != nil {
-				q = q.Filter("Payload.Stddev <=", query.PayloadStddev.Max)
+	return limit, offset
+}
+
+// iterate runs the filter query, the negative limit means no limit.
+// The limit and offset are applied by datastore unless some filter conditions
+// have to be matched against the fetched entities.
+func (c *Client) iterate(ctx context.Context, collection string, filter models.Filter, limit, offset int) *Iterator {
+	native, residual := split(filter)
+	q := filterQuery(collection, native)
+	if len(residual) == 0 {
+		return &Iterator{it: c.c.Run(ctx, q.Offset(offset).Limit(limit)), cancel: func() {}}
+	}
+	return &Iterator{
+		it:       c.c.Run(ctx, q),
+		cancel:   func() {},
+		residual: residual,
+		limit:    limit,
+		offset:   offset,
+	}
+}
+
+// Next loads the next result into out.
+// It returns false when there are no results left.
+func (i *Iterator) Next(out interface{}) (bool, error) {
+	for {
+		if len(i.residual) > 0 && i.limit == 0 {
+			return false, nil
+		}
+		_, err := i.it.Next(out)
+		if err == iterator.Done {
+			return false, nil
+		}
+		if err != nil {
+			i.err = err
+			return false, err
+		}
+		if len(i.residual) == 0 {
+			return true, nil
+		}
+		if !i.residual.Match(out) {
+			continue
+		}
+		if i.offset > 0 {
+			i.offset--
+			continue
+		}
+		i.limit--
+		return true, nil
+	}
+}
+
+// Close releases the iterator resources.
+func (i *Iterator) Close() {
+	i.cancel()
+}
+
+// summaryProperties defines the properties to find the range of values for.
+var summaryProperties = []string{"Payload.Time", "Payload.Mean", "Payload.Stddev"}
+
+// Summary summarizes the results of the filter query.
+// If the filter is empty, the results are counted with the keys-only query,
+// and the min and max values are read with the sorted queries returning a single entity.
+// Otherwise the results are scanned, because datastore requires the first sort order
+// to be set on the property with the range filter, or the composite index otherwise.
+func (c *Client) Summary(ctx context.Context, collection string, filter models.Filter) (s *models.Summary, err error) {
+	ctx, done := instrument(ctx, "summary", collection)
+	defer func() { done(err) }()
+	ctx, cancel := context.WithTimeout(ctx, c.cfg.timeoutStream)
+	defer cancel()
+	if len(filter) > 0 {
+		return models.SummarizeResults(c.iterate(ctx, collection, filter, -1, 0))
+	}
+	q := datastore.NewQuery(collection)
+
+	s = &models.Summary{}
+	err = c.cfg.retry.Do(ctx, func(ctx context.Context) error {
+		*s = models.Summary{}
+		n, err := c.c.Count(ctx, q)
+		if err != nil {
+			return err
+		}
+		for _, p := range summaryProperties {
+			for _, order := range []string{p, "-" + p} {
+				var res models.QueryResults
+				if _, err := c.c.GetAll(ctx, q.Order(order).Limit(1), &res); err != nil {
+					return err
+				}
+				for i := range res {
+					s.Add(&res[i])
+				}
 			}
 		}
+		// the entities holding the min and max values could have been added multiple times
+		s.Count = n
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
-	_, err := c.c.GetAll(ctx, q, out)
-	return err
+	return s, nil
 }
@@ -16,91 +16,299 @@ See the License for the specific language governing permissions and limitations
 package main
 
 import (
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
 	"fmt"
 	httpStatus "net/http"
 	"path"
 	"platform/lib/api/http"
-	"platform/submit/models"
-	"sync"
-)
+	"platform/lib/io/store/gcs"
+	"platform/lib/jsonschema"
+	"platform/lib/logging"
+	"platform/lib/registry"
+	"platform/lib/registry/submission"
+	"platform/lib/trace"
+	"platform/lib/utils"
+	"regexp"
+	"strconv"
+	"time"
 
-var wg sync.WaitGroup
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.opentelemetry.io/otel"
+)
 
 const submitterID = "test"
 
+// submitterIDPattern defines the submitter IDs, they start with the alphanumeric character
+// not to collide with the reserved key prefixes of the cold storage, see quarantinePrefix.
+const submitterIDPattern = `^[A-Za-z0-9][A-Za-z0-9._-]*$`
+
+var reSubmitterID = regexp.MustCompile(submitterIDPattern)
+
+var (
+	submissionsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "submissions_total",
+		Help: "Number of the received submissions by the payload validity.",
+	}, []string{"valid"})
+	ruleViolationsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "submission_rule_violations_total",
+		Help: "Number of the semantic rules violations by rule.",
+	}, []string{"rule"})
+)
+
+// metadata keys of the cold storage objects
+const (
+	metadataSubmitterID  = "submitter_id"
+	metadataSubmissionID = "submission_id"
+	metadataValid        = "valid"
+	// metadataSubmittedAt defines the time the submission was received at, in RFC 3339 format
+	metadataSubmittedAt = "submitted_at"
+	// metadataSchemaVersion defines the schema version of the submission, it's not set if the version is unknown
+	metadataSchemaVersion = "schema_version"
+	// metadataResubmissionOf defines the ID of the quarantined submission the submission corrects
+	metadataResubmissionOf = "resubmission_of"
+	// metadataNotified flags that the notification about the object was published
+	metadataNotified = "notified"
+)
+
+// submit persists the payload in cold storage first, and then publishes the notification.
+// The object is flagged as notified once the notification is published,
+// the objects which are not flagged are notified by the reconciler.
+// The invalid payloads are stored and notified about as well, the payload is validated by the router, see submitDoc,
+// and then checked against the semantic rules of the submitter. The invalid submissions are quarantined to be reviewed.
 func submit(runner *runner, bucket *string) http.Action {
 	return func(r *http.Request) (*http.Response, error) {
-		errOut := []string{}
+		resp, status := accept(r, runner, *bucket, submitterID, utils.GenerateUUID4(), "")
+		return http.NewResponse(resp.MustSerialize(), status), nil
+	}
+}
 
-		validErrs := models.ValidatePayload(r.Body)
-		if validErrs != nil {
-			errOut = append(errOut, validErrs.Error())
+// accept stores the submission of the submitter under the submission ID, and notifies about it.
+// resubmissionOf defines the ID of the quarantined submission the payload corrects.
+func accept(r *http.Request, runner *runner, bucket, submitter, submissionID, resubmissionOf string) (*response, int) {
+	errOut := []jsonschema.FieldError{}
+	if r.ValidationError == nil {
+		// the semantic rules are checked for the submissions valid against the schema
+		if err := runner.Rules.For(submitter).Validate(r.Body); err != nil {
+			r.ValidationError = err
+			for _, e := range jsonschema.FieldErrors(err) {
+				ruleViolationsTotal.WithLabelValues(e.Keyword).Inc()
+			}
 		}
+	}
+	if r.ValidationError != nil {
+		errOut = jsonschema.FieldErrors(r.ValidationError)
+	}
 
-		payloadToDispatch := NewPayloadHotStorage(submitterID, r.Body, validErrs == nil)
-
-		keyColdStorage := path.Join(
-			payloadToDispatch.SubmitterID,
-			payloadToDispatch.SubmissionID,
-			fmt.Sprintf("%s.json", payloadToDispatch.SubmissionID),
-		)
-
-		func() {
-			wg.Add(1)
-			go func() {
-				defer wg.Done()
-				if err := runner.ColdStorage.Write(*bucket, keyColdStorage, r.Body); err != nil {
-					errOut = append(errOut, err.Error())
-				}
-			}()
-			wg.Add(1)
-			go func() {
-				defer wg.Done()
-				notification := payloadLocation{
-					SubmitterID:  payloadToDispatch.SubmitterID,
-					SubmissionID: payloadToDispatch.SubmissionID,
-					Bucket:       *bucket,
-					Obj:          keyColdStorage,
-				}
-				var err error
-				if payloadToDispatch.Valid {
-					_, err = runner.Success.Push(notification.MustSerialize())
-				} else {
-					_, err = runner.Fail.Push(notification.MustSerialize())
-				}
-				if err != nil {
-					errOut = append(errOut, err.Error())
-				}
-			}()
-		}()
-		wg.Wait()
-
-		resp := &response{
+	payloadToDispatch := NewPayloadHotStorage(submitter, submissionID, r.Body, r.ValidationError == nil)
+	if !reSubmitterID.MatchString(submitter) {
+		return &response{
 			SubmissionID: payloadToDispatch.SubmissionID,
-			Errors:       errOut,
+			Errors:       []jsonschema.FieldError{{Message: fmt.Sprintf("invalid submitter ID %q", submitter)}},
+		}, httpStatus.StatusBadRequest
+	}
+	submissionsTotal.WithLabelValues(strconv.FormatBool(payloadToDispatch.Valid)).Inc()
+	logger := logging.FromContext(r.Context()).With(
+		"submitter_id", payloadToDispatch.SubmitterID,
+		"submission_id", payloadToDispatch.SubmissionID,
+	)
+	ctx := logging.NewContext(r.Context(), logger)
+
+	keyColdStorage := path.Join(
+		payloadToDispatch.SubmitterID,
+		payloadToDispatch.SubmissionID,
+		fmt.Sprintf("%s.json", payloadToDispatch.SubmissionID),
+	)
+
+	resp := &response{SubmissionID: payloadToDispatch.SubmissionID, ResubmissionOf: resubmissionOf}
+	submittedAt := time.Unix(payloadToDispatch.SubmissionEpoch, 0).UTC()
+
+	metadata := map[string]string{
+		metadataSubmitterID:  payloadToDispatch.SubmitterID,
+		metadataSubmissionID: payloadToDispatch.SubmissionID,
+		metadataSubmittedAt:  submittedAt.Format(time.RFC3339),
+		metadataValid:        strconv.FormatBool(payloadToDispatch.Valid),
+		metadataNotified:     "false",
+	}
+	if resubmissionOf != "" {
+		metadata[metadataResubmissionOf] = resubmissionOf
+	}
+	// the version is resolved again, the invalid declaration is reported by the payload validation, see submitDoc
+	schemaVersion := 0
+	if v, err := submission.Registry.Resolve(r.Headers[registry.VersionHeader], r.Body); err == nil {
+		schemaVersion = v.Version
+		metadata[metadataSchemaVersion] = strconv.Itoa(v.Version)
+	}
+	// the reconciler continues the trace of the submission if the notification fails
+	otel.GetTextMapPropagator().Inject(r.Context(), trace.Carrier(metadata))
+	if err := runner.ColdStorage.WriteWithMetadata(ctx, bucket, keyColdStorage, r.Body, metadata); err != nil {
+		logger.Error("failed to store the submission", "error", err)
+		// the storage error is logged, the client only learns that the submission was not stored
+		resp.Errors = append(errOut, jsonschema.FieldError{Message: "failed to store the submission"})
+		return resp, httpStatus.StatusInternalServerError
+	}
+
+	status := httpStatus.StatusOK
+	if !payloadToDispatch.Valid {
+		resp.Errors = errOut
+		status = httpStatus.StatusBadRequest
+		record := &quarantineRecord{
+			SubmitterID:    payloadToDispatch.SubmitterID,
+			SubmissionID:   payloadToDispatch.SubmissionID,
+			ReceivedAt:     submittedAt,
+			SchemaVersion:  schemaVersion,
+			ResubmissionOf: resubmissionOf,
+			Errors:         errOut,
+		}
+		// the submission is stored, it can be found by the object metadata if the record fails to be written
+		if err := quarantine(ctx, runner, bucket, record); err != nil {
+			logger.Error("failed to quarantine the submission", "error", err)
+		}
+	}
+
+	notification := &payloadLocation{
+		SubmitterID:   payloadToDispatch.SubmitterID,
+		SubmissionID:  payloadToDispatch.SubmissionID,
+		Bucket:        bucket,
+		Obj:           keyColdStorage,
+		SchemaVersion: schemaVersion,
+	}
+	if err := notify(ctx, runner, notification, payloadToDispatch.Valid); err != nil {
+		// the submission is persisted, the reconciler publishes the notification later
+		logger.Warning("failed to notify about the submission", "error", err)
+		if payloadToDispatch.Valid {
+			status = httpStatus.StatusAccepted
 		}
-		status := httpStatus.StatusOK
-		if len(resp.Errors) > 0 {
-			status = httpStatus.StatusInternalServerError
-			if !payloadToDispatch.Valid {
-				status = httpStatus.StatusBadRequest
+	}
+	return resp, status
+}
+
+// defaultListLimit defines the number of the listed objects if the limit is not set.
+const defaultListLimit = 100
+
+// listParams defines the query parameters of the listings.
+type listParams struct {
+	// from and to define the time range, the zero values are not checked
+	from, to  time.Time
+	limit     int
+	pageToken string
+}
+
+// parseListParams parses the time range, the limit and the page token of the listing.
+// The parameters are validated by the router, the request is rejected if they fail to be parsed nevertheless.
+func parseListParams(query map[string]string, defaultLimit int) (*listParams, error) {
+	o := &listParams{limit: defaultLimit, pageToken: query["page_token"]}
+	for _, p := range []struct {
+		name string
+		t    *time.Time
+	}{{"from", &o.from}, {"to", &o.to}} {
+		if v := query[p.name]; v != "" {
+			var err error
+			if *p.t, err = time.Parse(time.RFC3339, v); err != nil {
+				return nil, http.WrapError(http.KindValidation, fmt.Errorf("invalid %q param: %w", p.name, err))
 			}
 		}
-		return http.NewResponse(resp.MustSerialize(), status), nil
 	}
+	if v := query["limit"]; v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit < 1 {
+			return nil, http.NewError(http.KindValidation, fmt.Sprintf("invalid \"limit\" param: %q", v))
+		}
+		o.limit = limit
+	}
+	return o, nil
 }
 
-func read(runner *runner, bucket *string) http.Action {
+// inRange checks if the time is within the range.
+func (p *listParams) inRange(t time.Time) bool {
+	return (p.from.IsZero() || !t.Before(p.from)) && (p.to.IsZero() || t.Before(p.to))
+}
+
+// list lists the raw data objects of the submitter in the submission ID order,
+// the objects can be filtered by the time they were submitted at.
+func list(runner *runner, bucket *string) http.Action {
 	return func(r *http.Request) (*http.Response, error) {
-		submissionID, ok := r.Query["submission_id"]
-		if !ok {
-			return http.NewResponse([]byte(`{"error": "missing submission_id"}`), httpStatus.StatusBadRequest), nil
+		// the parameters are validated by the router, see listDoc
+		submitter := r.Query["submitter_id"]
+		params, err := parseListParams(r.Query, defaultListLimit)
+		if err != nil {
+			return nil, err
+		}
+
+		page, err := runner.ColdStorage.List(r.Context(), *bucket, submitter+"/", params.pageToken, params.limit, func(o *gcs.Object) bool {
+			if _, ok := parseLocation(*bucket, o.Name); !ok {
+				return false
+			}
+			return params.inRange(submittedAt(o))
+		})
+		if err != nil {
+			if errors.Is(err, gcs.ErrInvalidPageToken) {
+				return nil, http.NewError(http.KindValidation, "invalid page token")
+			}
+			return nil, err
+		}
+
+		o := &objectList{Objects: []storedObject{}, NextPageToken: page.NextPageToken}
+		for _, obj := range page.Objects {
+			location, _ := parseLocation(*bucket, obj.Name)
+			el := storedObject{
+				SubmitterID:  location.SubmitterID,
+				SubmissionID: location.SubmissionID,
+				SubmittedAt:  submittedAt(obj),
+				Size:         obj.Size,
+				ContentType:  obj.ContentType,
+			}
+			// the checksums are encoded as by the storage API
+			crc32c := make([]byte, 4)
+			binary.BigEndian.PutUint32(crc32c, obj.CRC32C)
+			el.CRC32C = base64.StdEncoding.EncodeToString(crc32c)
+			if len(obj.MD5) > 0 {
+				el.MD5 = base64.StdEncoding.EncodeToString(obj.MD5)
+			}
+			if v, err := strconv.ParseBool(obj.Metadata[metadataValid]); err == nil {
+				el.Valid = &v
+			}
+			el.SchemaVersion, _ = strconv.Atoi(obj.Metadata[metadataSchemaVersion])
+			o.Objects = append(o.Objects, el)
 		}
+		return http.NewResponse(o.MustSerialize(), httpStatus.StatusOK), nil
+	}
+}
+
+// submittedAt returns the time the object was submitted at,
+// the objects stored before the time was recorded in the metadata fall back to the creation time.
+func submittedAt(o *gcs.Object) time.Time {
+	if t, err := time.Parse(time.RFC3339, o.Metadata[metadataSubmittedAt]); err == nil {
+		return t
+	}
+	return o.Created.UTC()
+}
+
+// notify publishes the notification about the stored object and flags the object as notified.
+func notify(ctx context.Context, runner *runner, notification *payloadLocation, valid bool) error {
+	publisher := runner.Success
+	if !valid {
+		publisher = runner.Fail
+	}
+	if _, err := publisher.Push(ctx, notification.MustSerialize()); err != nil {
+		return err
+	}
+	// the notification is published again by the reconciler if the flag fails to be set
+	return runner.ColdStorage.UpdateMetadata(ctx, notification.Bucket, notification.Obj, map[string]string{metadataNotified: "true"})
+}
+
+func read(runner *runner, bucket *string) http.Action {
+	return func(r *http.Request) (*http.Response, error) {
+		// the submission_id is required, see readDoc
+		submissionID := r.Query["submission_id"]
 		keyColdStorage := path.Join(submitterID, submissionID, fmt.Sprintf("%s.json", submissionID))
-		data, err := runner.ColdStorage.Read(*bucket, keyColdStorage)
+		data, err := runner.ColdStorage.Read(r.Context(), *bucket, keyColdStorage)
 		if err != nil {
-			if err.Error() == "storage: object doesn't exist" {
-				return http.NewResponse([]byte(`{"error": "data not found"}`), httpStatus.StatusNotFound), nil
+			if errors.Is(err, gcs.ErrObjectNotExist) {
+				return nil, http.NewError(http.KindNotFound, "data not found")
 			}
 			return nil, err
 		}
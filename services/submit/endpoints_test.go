@@ -0,0 +1,574 @@
+/* Copyright 2021 Dmitry Kisler dkisler.com
+
+Licensed under the Apache License,Version 2.0 (the "License");
+you may not use this file except in compliance with the License. You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED,
+INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE,
+AND NONINFRINGEMENT. IN NO EVENT WILL THE LICENSOR OR OTHER CONTRIBUTORS BE LIABLE FOR ANY CLAIM, DAMAGES,
+OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF,
+OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+See the License for the specific language governing permissions and limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	httpStatus "net/http"
+	"platform/lib/api/http"
+	"platform/lib/io/store/gcs"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/goccy/go-json"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/valyala/fasthttp"
+)
+
+// fakeStore defines the in-memory cold storage.
+type fakeStore struct {
+	mu      sync.Mutex
+	objects map[string]*gcs.Object
+	data    map[string][]byte
+	// generations defines the generations of the objects, the object's generation changes on every write
+	generations map[string]int64
+	generation  int64
+	// afterRead is called after the object's generation is read if set
+	afterRead func()
+	// failWrite fails the writes if set
+	failWrite bool
+}
+
+func newFakeStore() *fakeStore {
+	return &fakeStore{objects: map[string]*gcs.Object{}, data: map[string][]byte{}, generations: map[string]int64{}}
+}
+
+func (s *fakeStore) Read(ctx context.Context, bucket, path string) ([]byte, error) {
+	data, _, err := s.ReadGeneration(ctx, bucket, path)
+	return data, err
+}
+
+func (s *fakeStore) ReadGeneration(ctx context.Context, bucket, path string) ([]byte, int64, error) {
+	s.mu.Lock()
+	data, ok := s.data[path]
+	generation := s.generations[path]
+	s.mu.Unlock()
+	if !ok {
+		return nil, 0, gcs.ErrObjectNotExist
+	}
+	if s.afterRead != nil {
+		s.afterRead()
+	}
+	return data, generation, nil
+}
+
+func (s *fakeStore) WriteWithMetadata(ctx context.Context, bucket, path string, obj []byte, metadata map[string]string) error {
+	if s.failWrite {
+		return errors.New("write failed")
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.write(path, obj, metadata)
+	return nil
+}
+
+func (s *fakeStore) WriteIfGeneration(ctx context.Context, bucket, path string, obj []byte, metadata map[string]string, generation int64) (int64, error) {
+	if s.failWrite {
+		return 0, errors.New("write failed")
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.generations[path] != generation {
+		return 0, gcs.ErrPreconditionFailed
+	}
+	return s.write(path, obj, metadata), nil
+}
+
+// write stores the object, and returns its new generation. The store must be locked.
+func (s *fakeStore) write(path string, obj []byte, metadata map[string]string) int64 {
+	m := map[string]string{}
+	for k, v := range metadata {
+		m[k] = v
+	}
+	s.objects[path] = &gcs.Object{
+		Name:     path,
+		Created:  time.Now(),
+		Size:     int64(len(obj)),
+		CRC32C:   gcs.CRC32C(obj),
+		Metadata: m,
+	}
+	s.data[path] = obj
+	s.generation++
+	s.generations[path] = s.generation
+	return s.generation
+}
+
+func (s *fakeStore) UpdateMetadata(ctx context.Context, bucket, path string, metadata map[string]string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	o, ok := s.objects[path]
+	if !ok {
+		return gcs.ErrObjectNotExist
+	}
+	for k, v := range metadata {
+		o.Metadata[k] = v
+	}
+	return nil
+}
+
+func (s *fakeStore) Walk(ctx context.Context, bucket, prefix string, fn func(o *gcs.Object) error) error {
+	s.mu.Lock()
+	objects := []gcs.Object{}
+	for _, o := range s.objects {
+		if strings.HasPrefix(o.Name, prefix) {
+			m := map[string]string{}
+			for k, v := range o.Metadata {
+				m[k] = v
+			}
+			objects = append(objects, gcs.Object{Name: o.Name, Created: o.Created, Metadata: m})
+		}
+	}
+	s.mu.Unlock()
+	for i := range objects {
+		if err := fn(&objects[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *fakeStore) List(ctx context.Context, bucket, prefix, pageToken string, limit int, filter func(o *gcs.Object) bool) (*gcs.Page, error) {
+	last := ""
+	if pageToken != "" {
+		var err error
+		if last, err = gcs.DecodePageToken(pageToken); err != nil {
+			return nil, err
+		}
+	}
+	s.mu.Lock()
+	objects := []gcs.Object{}
+	for _, o := range s.objects {
+		if strings.HasPrefix(o.Name, prefix) && o.Name > last {
+			objects = append(objects, *o)
+		}
+	}
+	s.mu.Unlock()
+	sort.Slice(objects, func(i, j int) bool { return objects[i].Name < objects[j].Name })
+	page := &gcs.Page{}
+	for i := range objects {
+		o := &objects[i]
+		if filter == nil || filter(o) {
+			page.Objects = append(page.Objects, o)
+		}
+		// the token is set if the objects are left, as by the client
+		if len(page.Objects) == limit && i+1 < len(objects) {
+			page.NextPageToken = gcs.EncodePageToken(o.Name)
+			break
+		}
+	}
+	return page, nil
+}
+
+func (s *fakeStore) metadata(path string) map[string]string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if o, ok := s.objects[path]; ok {
+		return o.Metadata
+	}
+	return nil
+}
+
+// fakePublisher defines the in-memory message bus topic.
+type fakePublisher struct {
+	mu       sync.Mutex
+	messages []*payloadLocation
+	// fail fails the publishing of messages about the objects with the key matching the function
+	fail func(key string) bool
+}
+
+func (p *fakePublisher) Push(ctx context.Context, data []byte) (string, error) {
+	var m *payloadLocation
+	if err := json.Unmarshal(data, &m); err != nil {
+		return "", err
+	}
+	if p.fail != nil && p.fail(m.Obj) {
+		return "", errors.New("publish failed")
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.messages = append(p.messages, m)
+	return fmt.Sprint(len(p.messages)), nil
+}
+
+func (p *fakePublisher) count() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.messages)
+}
+
+func failAll(string) bool { return true }
+
+const bucket = "raw"
+
+func newTestRunner() (*runner, *fakeStore, *fakePublisher, *fakePublisher) {
+	store, success, fail := newFakeStore(), &fakePublisher{}, &fakePublisher{}
+	return &runner{Success: success, Fail: fail, ColdStorage: store}, store, success, fail
+}
+
+// doSubmit submits the payload through the router,
+// hence the payload is validated, and the response is checked against the documented schema.
+func doSubmit(runner *runner, body string) (*response, int, error) {
+	b := bucket
+	router := http.NewRequestHandlers(map[string]*http.HandlerEndpoint{
+		"/": http.NewHandlerEndpoint(submit(runner, &b), []string{"POST"}).WithDoc(submitDoc),
+	}).WithResponseValidation().Router()
+
+	ctx := &fasthttp.RequestCtx{}
+	ctx.Request.Header.SetMethod("POST")
+	ctx.Request.SetRequestURI("/")
+	ctx.Request.SetBodyString(body)
+	router(ctx)
+
+	var o *response
+	if err := json.Unmarshal(ctx.Response.Body(), &o); err != nil {
+		return nil, 0, err
+	}
+	return o, ctx.Response.StatusCode(), nil
+}
+
+func submitRequest(t *testing.T, runner *runner, body string) (*response, int) {
+	t.Helper()
+	o, status, err := doSubmit(runner, body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return o, status
+}
+
+const (
+	payloadValid   = `{"time_stamp": "2021-01-01T00:00:00Z", "data": [1, 2, 3]}`
+	payloadInvalid = `{"time_stamp": "2021-01-01T00:00:00Z", "data": []}`
+)
+
+func TestSubmit(t *testing.T) {
+	tests := []struct {
+		name         string
+		payload      string
+		failWrite    bool
+		failPublish  bool
+		wantStatus   int
+		wantStored   bool
+		wantNotified string
+		wantSuccess  int
+		wantFail     int
+	}{
+		{
+			name:         "valid",
+			payload:      payloadValid,
+			wantStatus:   httpStatus.StatusOK,
+			wantStored:   true,
+			wantNotified: "true",
+			wantSuccess:  1,
+		},
+		{
+			name:         "invalid",
+			payload:      payloadInvalid,
+			wantStatus:   httpStatus.StatusBadRequest,
+			wantStored:   true,
+			wantNotified: "true",
+			wantFail:     1,
+		},
+		{
+			name:         "publish failed",
+			payload:      payloadValid,
+			failPublish:  true,
+			wantStatus:   httpStatus.StatusAccepted,
+			wantStored:   true,
+			wantNotified: "false",
+		},
+		{
+			name:       "write failed",
+			payload:    payloadValid,
+			failWrite:  true,
+			wantStatus: httpStatus.StatusInternalServerError,
+		},
+	}
+	for _, test := range tests {
+		runner, store, success, fail := newTestRunner()
+		store.failWrite = test.failWrite
+		if test.failPublish {
+			success.fail = failAll
+		}
+		resp, status := submitRequest(t, runner, test.payload)
+		if status != test.wantStatus {
+			t.Fatalf("error for %s!\nwant: %d\ngot: %d", test.name, test.wantStatus, status)
+		}
+		key := fmt.Sprintf("%s/%s/%s.json", submitterID, resp.SubmissionID, resp.SubmissionID)
+		metadata := store.metadata(key)
+		if (metadata != nil) != test.wantStored {
+			t.Fatalf("error for %s!\nwant stored: %v\ngot: %v", test.name, test.wantStored, metadata != nil)
+		}
+		if _, err := time.Parse(time.RFC3339, metadata[metadataSubmittedAt]); test.wantStored && (err != nil || metadata[metadataSubmitterID] != submitterID) {
+			t.Fatalf("error for %s!\nwant metadata of the submission\ngot: %v", test.name, metadata)
+		}
+		if metadata[metadataNotified] != test.wantNotified {
+			t.Fatalf("error for %s!\nwant notified: %s\ngot: %s", test.name, test.wantNotified, metadata[metadataNotified])
+		}
+		if success.count() != test.wantSuccess || fail.count() != test.wantFail {
+			t.Fatalf("error for %s!\nwant messages: %d, %d\ngot: %d, %d",
+				test.name, test.wantSuccess, test.wantFail, success.count(), fail.count())
+		}
+	}
+}
+
+func TestSubmitSchemaVersion(t *testing.T) {
+	tests := []struct {
+		payload     string
+		wantStatus  int
+		wantVersion int
+	}{
+		{payload: payloadValid, wantStatus: httpStatus.StatusOK, wantVersion: 1},
+		{
+			payload:     `{"schema_version": 2, "time_stamp": "2021-01-01T00:00:00Z", "data": [1], "units": "m", "tags": ["a"]}`,
+			wantStatus:  httpStatus.StatusOK,
+			wantVersion: 2,
+		},
+		{
+			payload:     `{"schema_version": 2, "time_stamp": "2021-01-01T00:00:00Z", "data": [1], "tags": ["a", "a"]}`,
+			wantStatus:  httpStatus.StatusBadRequest,
+			wantVersion: 2,
+		},
+		{
+			payload:    `{"schema_version": 100, "time_stamp": "2021-01-01T00:00:00Z", "data": [1]}`,
+			wantStatus: httpStatus.StatusBadRequest,
+		},
+	}
+	for _, test := range tests {
+		runner, store, success, fail := newTestRunner()
+		resp, status := submitRequest(t, runner, test.payload)
+		if status != test.wantStatus {
+			t.Fatalf("error for %s!\nwant: %d\ngot: %d %v", test.payload, test.wantStatus, status, resp.Errors)
+		}
+		messages := append(success.messages, fail.messages...)
+		if len(messages) != 1 || messages[0].SchemaVersion != test.wantVersion {
+			t.Fatalf("error for %s!\nwant: notification about version %d\ngot: %v", test.payload, test.wantVersion, messages)
+		}
+		key := fmt.Sprintf("%s/%s/%s.json", submitterID, resp.SubmissionID, resp.SubmissionID)
+		if got, _ := strconv.Atoi(store.metadata(key)[metadataSchemaVersion]); got != test.wantVersion {
+			t.Fatalf("error for %s!\nwant: version %d stored\ngot: %d", test.payload, test.wantVersion, got)
+		}
+	}
+}
+
+func TestSubmitRules(t *testing.T) {
+	rules, err := loadRules("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now().UTC().Format(time.RFC3339)
+	tests := []struct {
+		payload    string
+		wantStatus int
+		wantErrors []string
+	}{
+		{payload: fmt.Sprintf(`{"time_stamp": "%s", "data": [1, 2]}`, now), wantStatus: httpStatus.StatusOK},
+		{
+			payload:    fmt.Sprintf(`{"schema_version": 2, "time_stamp": "%s", "data": [1, 2], "units": "m/s", "device_id": "sensor-01"}`, now),
+			wantStatus: httpStatus.StatusOK,
+		},
+		{
+			// the fields valid against the schema are checked against the custom formats
+			payload:    fmt.Sprintf(`{"schema_version": 2, "time_stamp": "%s", "data": [1, 2], "units": "m / s", "device_id": "sensor 01"}`, now),
+			wantStatus: httpStatus.StatusBadRequest,
+			wantErrors: []string{"/device_id", "/units"},
+		},
+		{
+			// the historical samples are accepted by the default rules
+			payload:    `{"time_stamp": "2001-01-01T00:00:00Z", "data": [1, 2]}`,
+			wantStatus: httpStatus.StatusOK,
+		},
+		{
+			payload:    `{"time_stamp": "1969-12-31T23:59:59Z", "data": [1, -9999]}`,
+			wantStatus: httpStatus.StatusBadRequest,
+			wantErrors: []string{"/time_stamp", "/data/1"},
+		},
+		{
+			// the rules are not checked for the submission invalid against the schema
+			payload:    `{"time_stamp": "1970-01-01T00:00:00Z", "data": []}`,
+			wantStatus: httpStatus.StatusBadRequest,
+			wantErrors: []string{"/data"},
+		},
+	}
+	for _, test := range tests {
+		runner, _, success, fail := newTestRunner()
+		runner.Rules = rules
+		resp, status := submitRequest(t, runner, test.payload)
+		got := []string{}
+		for _, e := range resp.Errors {
+			got = append(got, e.Pointer)
+		}
+		if status != test.wantStatus || strings.Join(got, ",") != strings.Join(test.wantErrors, ",") {
+			t.Fatalf("error for %s!\nwant: %d %v\ngot: %d %v", test.payload, test.wantStatus, test.wantErrors, status, got)
+		}
+		// the submissions violating the rules are routed to the fail topic
+		if wantFail := test.wantStatus != httpStatus.StatusOK; (fail.count() == 1) != wantFail || (success.count() == 1) == wantFail {
+			t.Fatalf("error for %s!\nwant: fail topic %v\ngot: %d, %d messages", test.payload, wantFail, success.count(), fail.count())
+		}
+	}
+}
+
+// TestSubmitConcurrent checks that concurrent requests do not share state, run it with -race.
+func TestSubmitConcurrent(t *testing.T) {
+	runner, store, success, fail := newTestRunner()
+	const n = 50
+	validBefore := testutil.ToFloat64(submissionsTotal.WithLabelValues("true"))
+	invalidBefore := testutil.ToFloat64(submissionsTotal.WithLabelValues("false"))
+	var wg sync.WaitGroup
+	responses := make([]*response, n)
+	errs := make([]error, n)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			payload := payloadValid
+			if i%2 == 0 {
+				payload = payloadInvalid
+			}
+			responses[i], _, errs[i] = doSubmit(runner, payload)
+		}(i)
+	}
+	wg.Wait()
+
+	for i, resp := range responses {
+		if errs[i] != nil {
+			t.Fatal(errs[i])
+		}
+		wantErrors := i%2 == 0
+		if got := len(resp.Errors) > 0; got != wantErrors {
+			t.Fatalf("error for request %d!\nwant: errors %v\ngot: %v", i, wantErrors, resp.Errors)
+		}
+	}
+	// the invalid submissions are quarantined
+	if len(store.objects) != n+n/2 || success.count() != n/2 || fail.count() != n/2 {
+		t.Fatalf("error!\nwant: %d objects, %d success and %d fail messages\ngot: %d, %d, %d",
+			n+n/2, n/2, n/2, len(store.objects), success.count(), fail.count())
+	}
+	valid := testutil.ToFloat64(submissionsTotal.WithLabelValues("true")) - validBefore
+	invalid := testutil.ToFloat64(submissionsTotal.WithLabelValues("false")) - invalidBefore
+	if valid != n/2 || invalid != n/2 {
+		t.Fatalf("error!\nwant: %d valid and %d invalid submissions counted\ngot: %v, %v", n/2, n/2, valid, invalid)
+	}
+}
+
+func TestList(t *testing.T) {
+	runner, _, _, _ := newTestRunner()
+	for i := 0; i < 5; i++ {
+		payload := payloadValid
+		if i < 2 {
+			payload = payloadInvalid
+		}
+		submitRequest(t, runner, payload)
+	}
+	b := bucket
+	router := http.NewRequestHandlers(map[string]*http.HandlerEndpoint{
+		"/list": http.NewHandlerEndpoint(list(runner, &b), []string{"GET"}).WithDoc(listDoc),
+	}).WithResponseValidation().Router()
+	do := func(query string) (*objectList, int) {
+		ctx := &fasthttp.RequestCtx{}
+		ctx.Request.Header.SetMethod("GET")
+		ctx.Request.SetRequestURI("/list" + query)
+		router(ctx)
+		var o *objectList
+		_ = json.Unmarshal(ctx.Response.Body(), &o)
+		return o, ctx.Response.StatusCode()
+	}
+
+	listed := map[string]bool{}
+	valid := 0
+	token := ""
+	for pages := 1; ; pages++ {
+		o, status := do("?submitter_id=" + submitterID + "&limit=2&page_token=" + token)
+		if status != httpStatus.StatusOK || len(o.Objects) > 2 || pages > 3 {
+			t.Fatalf("error for page %d!\nwant: %d with up to 2 objects\ngot: %d %+v", pages, httpStatus.StatusOK, status, o)
+		}
+		for _, obj := range o.Objects {
+			if listed[obj.SubmissionID] || obj.Size == 0 || obj.CRC32C == "" || obj.Valid == nil || obj.SchemaVersion != 1 {
+				t.Fatalf("error!\nwant: object listed once with its attributes\ngot: %+v", obj)
+			}
+			listed[obj.SubmissionID] = true
+			if *obj.Valid {
+				valid++
+			}
+		}
+		if token = o.NextPageToken; token == "" {
+			break
+		}
+	}
+	if len(listed) != 5 || valid != 3 {
+		t.Fatalf("error!\nwant: 5 objects, 3 valid\ngot: %d, %d", len(listed), valid)
+	}
+
+	future := time.Now().Add(time.Hour).UTC().Format(time.RFC3339)
+	if o, status := do("?submitter_id=" + submitterID + "&from=" + future); status != httpStatus.StatusOK || len(o.Objects) != 0 {
+		t.Fatalf("error!\nwant: no objects submitted after %s\ngot: %d %+v", future, status, o)
+	}
+	if o, status := do("?submitter_id=" + submitterID + "&to=" + future); status != httpStatus.StatusOK || len(o.Objects) != 5 {
+		t.Fatalf("error!\nwant: 5 objects submitted before %s\ngot: %d %+v", future, status, o)
+	}
+	for _, query := range []string{
+		"",
+		"?submitter_id=" + submitterID + "&page_token=!",
+		"?submitter_id=" + submitterID + "&limit=two",
+		"?submitter_id=" + submitterID + "&from=yesterday",
+	} {
+		if _, status := do(query); status != httpStatus.StatusBadRequest {
+			t.Fatalf("error for %s!\nwant: %d\ngot: %d", query, httpStatus.StatusBadRequest, status)
+		}
+	}
+}
+
+func TestParseListParams(t *testing.T) {
+	tests := []struct {
+		query map[string]string
+		want  *listParams
+	}{
+		{query: map[string]string{}, want: &listParams{limit: 10}},
+		{
+			query: map[string]string{"from": "2021-01-01T00:00:00Z", "to": "2021-01-02T00:00:00Z", "limit": "5", "page_token": "a"},
+			want: &listParams{
+				from:      time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC),
+				to:        time.Date(2021, 1, 2, 0, 0, 0, 0, time.UTC),
+				limit:     5,
+				pageToken: "a",
+			},
+		},
+		// the invalid parameters are not replaced with the defaults
+		{query: map[string]string{"from": "yesterday"}},
+		{query: map[string]string{"to": "2021-01-02"}},
+		{query: map[string]string{"limit": "two"}},
+		{query: map[string]string{"limit": "0"}},
+	}
+	for _, test := range tests {
+		got, err := parseListParams(test.query, 10)
+		if test.want == nil {
+			var e *http.Error
+			if !errors.As(err, &e) || e.Kind != http.KindValidation {
+				t.Fatalf("error for %v!\nwant: validation error\ngot: %v", test.query, err)
+			}
+			continue
+		}
+		if err != nil || !reflect.DeepEqual(got, test.want) {
+			t.Fatalf("error for %v!\nwant: %+v\ngot: %+v %v", test.query, test.want, got, err)
+		}
+	}
+}
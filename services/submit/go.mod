@@ -19,8 +19,13 @@ go 1.16
 replace platform/lib => ../lib
 
 require (
-	github.com/goccy/go-json v0.7.4
-	github.com/xeipuuv/gojsonpointer v0.0.0-20190905194746-02993c407bfb // indirect
-	github.com/xeipuuv/gojsonschema v1.2.0 // indirect
+	github.com/goccy/go-json v0.10.5
+	github.com/prometheus/client_golang v1.12.2
+	github.com/valyala/fasthttp v1.28.0
+	go.opentelemetry.io/otel v1.7.0
+	go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp v1.7.0
+	go.opentelemetry.io/otel/exporters/stdout/stdouttrace v1.7.0
+	go.opentelemetry.io/otel/sdk v1.7.0
+	go.opentelemetry.io/otel/trace v1.7.0
 	platform/lib v0.0.0-00010101000000-000000000000
 )
@@ -16,84 +16,271 @@ Package defines the service which provides the interface for the data submission
 
 Modus operandi:
 
-1. Validates the input data.
+1. Validates the input data against its schema version, and the semantic rules of the submitter.
 2. Stores data to the cold storage (GCP Storage).
 3. Pushes notification message to the message bus (GCP PubSub).
 The message contains the location of received dataset in cold storage.
+The stored objects which failed to be notified about are re-notified by the reconciler.
 4. Returns the response with the unique submission ID (UUIDv4).
+5. Quarantines the invalid submissions to be reviewed, and resubmitted with the corrected payload.
 */
 
 package main
 
 import (
-	"log"
+	"context"
+	_ "embed"
+	"fmt"
+	"io"
+	"os"
 	"platform/lib/api/http"
+	"platform/lib/health"
 	"platform/lib/io/bus/pubsub"
+	"platform/lib/io/fs"
 	"platform/lib/io/meta"
 	"platform/lib/io/store/gcs"
+	"platform/lib/jsonschema"
+	"platform/lib/logging"
 	"platform/lib/utils"
+	"regexp"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.10.0"
 )
 
+// publisher defines the message bus publisher.
+type publisher interface {
+	Push(ctx context.Context, data []byte) (id string, err error)
+}
+
+// objectStore defines the cold storage client.
+type objectStore interface {
+	Read(ctx context.Context, bucket, path string) ([]byte, error)
+	ReadGeneration(ctx context.Context, bucket, path string) ([]byte, int64, error)
+	WriteWithMetadata(ctx context.Context, bucket, path string, obj []byte, metadata map[string]string) error
+	WriteIfGeneration(ctx context.Context, bucket, path string, obj []byte, metadata map[string]string, generation int64) (int64, error)
+	UpdateMetadata(ctx context.Context, bucket, path string, metadata map[string]string) error
+	Walk(ctx context.Context, bucket, prefix string, fn func(o *gcs.Object) error) error
+	List(ctx context.Context, bucket, prefix, pageToken string, limit int, filter func(o *gcs.Object) bool) (*gcs.Page, error)
+}
+
 type runner struct {
-	Success     *pubsub.Publisher
-	Fail        *pubsub.Publisher
-	ColdStorage *gcs.Client
+	Success     publisher
+	Fail        publisher
+	ColdStorage objectStore
+	// Rules defines the semantic checks of the submissions by submitter.
+	Rules *jsonschema.RuleSets
 }
 
+//go:embed models/rules.json
+var defaultRules []byte
+
+// loadRules reads the rule sets from the file, the default rule sets are used if the path is not set.
+func loadRules(path string) (*jsonschema.RuleSets, error) {
+	b := defaultRules
+	if path != "" {
+		var err error
+		if b, err = fs.FRead(path); err != nil {
+			return nil, err
+		}
+	}
+	return jsonschema.ParseRuleSets(b)
+}
+
+// formats defines the custom formats of the submission fields, they are checked by the "format" rules, see models/rules.json.
+var formats = map[string]*regexp.Regexp{
+	// the alphanumeric ID with the separators inside, e.g. "sensor-01.eu:7"
+	"device-id": regexp.MustCompile(`^[A-Za-z0-9](?:[A-Za-z0-9._:-]{0,62}[A-Za-z0-9])?$`),
+	// the unit in the case-sensitive UCUM notation, e.g. "Cel", "m/s", "kg.m2", only its character set is checked
+	"ucum": regexp.MustCompile(`^[!-~]{1,32}$`),
+}
+
+// init registers the formats before the rules referring to them are loaded.
+func init() {
+	for name, re := range formats {
+		re := re
+		jsonschema.AddFormat(name, func(input interface{}) bool {
+			v, ok := input.(string)
+			return !ok || re.MatchString(v)
+		})
+	}
+}
+
+// requestTimeout defines the deadline to handle the request.
+const requestTimeout = 60 * time.Second
+
 var (
 	r *runner = &runner{}
 	s *http.Server
+	// closers defines the runner's clients to be closed on shutdown,
+	// the publishers are closed first to flush pending messages
+	closers []io.Closer
 )
 
-func setServer(bucket string) {
+// newHandlers defines the service endpoints, they are documented in the OpenAPI document.
+func newHandlers(bucket string, rc *reconciler, readiness *health.Checker) *http.Handlers {
 	endpoints := map[string]*http.HandlerEndpoint{
-		"/":     http.NewHandlerEndpoint(submit(r, &bucket), []string{"POST"}),
-		"/read": http.NewHandlerEndpoint(read(r, &bucket), []string{"GET"}),
+		"/":     http.NewHandlerEndpoint(submit(r, &bucket), []string{"POST"}).WithTimeout(requestTimeout).WithDoc(submitDoc),
+		"/read": http.NewHandlerEndpoint(read(r, &bucket), []string{"GET"}).WithTimeout(requestTimeout).WithDoc(readDoc),
+		"/list": http.NewHandlerEndpoint(list(r, &bucket), []string{"GET"}).WithTimeout(requestTimeout).WithDoc(listDoc),
+		// the invalid submissions are reviewed and corrected
+		"/quarantine": http.NewHandlerEndpoint(listQuarantine(r, &bucket), []string{"GET"}).WithTimeout(requestTimeout).WithDoc(listQuarantineDoc),
+		"/quarantine/{:submitter_id}/{:submission_id:uuid}": http.NewHandlerEndpoint(getQuarantine(r, &bucket), []string{"GET"}).
+			WithTimeout(requestTimeout).WithDoc(getQuarantineDoc),
+		"/quarantine/{:submitter_id}/{:submission_id:uuid}/resubmit": http.NewHandlerEndpoint(resubmit(r, &bucket), []string{"POST"}).
+			WithTimeout(requestTimeout).WithDoc(resubmitDoc),
+		// the notifications failed to be published on submission are re-emitted
+		"/reconcile": http.NewHandlerEndpoint(reconcile(rc), []string{"POST"}).WithDoc(reconcileDoc),
 	}
-	handlers := http.NewRequestHandlers(endpoints).WithDefaultHeaders(
+	return http.NewRequestHandlers(endpoints).WithDefaultHeaders(
 		map[string]string{
 			"tag-layer": "submit",
-		})
+		}).WithReadiness(readiness).WithOpenAPI(openAPIInfo)
+}
+
+func setServer(bucket string, rc *reconciler, readiness *health.Checker) {
+	handlers := newHandlers(bucket, rc, readiness)
+	// the responses are checked against the API contract in the test and dev environments
+	if utils.GetEnv("VALIDATE_RESPONSES", "false") == "true" {
+		handlers.WithResponseValidation()
+	}
 	s = http.NewServer(handlers)
 	s.SetName("submit")
 }
 
-func init() {
+// setup configures the runner and the server.
+func setup() {
+	logLevel, err := logging.ParseLevel(utils.GetEnv("LOG_LEVEL", "info"))
+	if err != nil {
+		logging.Default().Fatal("specify the log level, e.g. as envvar 'LOG_LEVEL=info'", "error", err)
+	}
 	projectID := utils.GetEnv("GCP_PROJECT", "")
 	if projectID == "" {
 		projectID = meta.GetProjectID()
 		if projectID == "" {
-			log.Fatalln("specify gcp project, e.g. as envvar 'GCP_PROJECT'")
+			logging.Default().Fatal("specify gcp project, e.g. as envvar 'GCP_PROJECT'")
 		}
 	}
+	logging.SetDefault(logging.New(os.Stderr, logging.NewConfig().WithLevel(logLevel).WithProjectID(projectID)))
 	bucket := utils.GetEnv("COLD_STORAGE_BUCKET", "")
 	if bucket == "" {
-		log.Fatalln("specify the bucket to store raw data project by setting envvar 'COLD_STORAGE_BUCKET'")
+		logging.Default().Fatal("specify the bucket to store raw data project by setting envvar 'COLD_STORAGE_BUCKET'")
 	}
 	topic := utils.GetEnv("NOTIFICATION_TOPIC", "")
 	if topic == "" {
-		log.Fatalln("specify the the message bus notification topic by setting envvar 'NOTIFICATION_TOPIC'")
+		logging.Default().Fatal("specify the the message bus notification topic by setting envvar 'NOTIFICATION_TOPIC'")
 	}
 	topicFail := utils.GetEnv("NOTIFICATION_TOPIC_FAIL", "")
 	if topicFail == "" {
-		log.Fatalln("specify the the message bus notification for fail topic by setting envvar 'NOTIFICATION_TOPIC_FAIL'")
+		logging.Default().Fatal("specify the the message bus notification for fail topic by setting envvar 'NOTIFICATION_TOPIC_FAIL'")
 	}
 
+	rules, err := loadRules(utils.GetEnv("SUBMISSION_RULES", ""))
+	if err != nil {
+		logging.Default().Fatal("failed to read the submission rules set by envvar 'SUBMISSION_RULES'", "error", err)
+	}
+	r.Rules = rules
+
 	c, err := pubsub.NewClient(projectID)
 	if err != nil {
-		log.Fatalln(err)
+		logging.Default().Fatal("failed to initialize the service", "error", err)
+	}
+	success := c.GetPublisher(topic).WithCCLimit(1)
+	fail := c.GetPublisher(topicFail).WithCCLimit(1)
+	r.Success, r.Fail = success, fail
+	closers = append(closers, success, fail, c)
+
+	coldStorage, err := gcs.NewClient()
+	if err != nil {
+		logging.Default().Fatal("failed to initialize the service", "error", err)
 	}
-	r.Success = c.GetPublisher(topic).WithCCLimit(1)
-	r.Fail = c.GetPublisher(topicFail).WithCCLimit(1)
+	r.ColdStorage = coldStorage
+	closers = append(closers, coldStorage)
 
-	r.ColdStorage, err = gcs.NewClient()
+	gracePeriod, err := time.ParseDuration(utils.GetEnv("RECONCILE_GRACE_PERIOD", "10m"))
 	if err != nil {
-		log.Fatalln(err)
+		logging.Default().Fatal("failed to initialize the service", "error", err)
 	}
+	rc := newReconciler(r, bucket, gracePeriod)
+	// the reconciliation runs periodically if the interval is set,
+	// otherwise it shall be triggered by requests to the "/reconcile" endpoint
+	if v := utils.GetEnv("RECONCILE_INTERVAL", ""); v != "" {
+		interval, err := time.ParseDuration(v)
+		if err != nil {
+			logging.Default().Fatal("failed to initialize the service", "error", err)
+		}
+		rc.Start(interval)
+	}
+	// the reconciler is stopped before the clients are closed
+	closers = append([]io.Closer{rc}, closers...)
+
+	tp, err := newTracerProvider(context.Background(), "submit", utils.GetEnv("TRACE_EXPORTER", ""))
+	if err != nil {
+		logging.Default().Fatal("failed to initialize the service", "error", err)
+	}
+	otel.SetTracerProvider(tp)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	// the provider is closed last to export the spans of the shutdown
+	closers = append(closers, tp)
+
+	readiness := health.NewChecker().
+		Register("gcs", func(ctx context.Context) error { return coldStorage.CheckBucket(ctx, bucket) }).
+		Register("pubsub_success", success.CheckTopic).
+		Register("pubsub_fail", fail.CheckTopic)
 
-	setServer(bucket)
+	setServer(bucket, rc, readiness)
+}
+
+// tracerProvider shuts the tracer provider down on close, the ended spans are exported.
+type tracerProvider struct {
+	*sdktrace.TracerProvider
+}
+
+func (t tracerProvider) Close() error {
+	return t.Shutdown(context.Background())
+}
+
+// newTracerProvider initiates the tracer provider of the service exporting the spans by the exporter name:
+//   - "stdout" writes the spans to stdout, e.g. for local runs
+//   - "otlp" sends the spans to the OTLP/HTTP endpoint set by the envvar OTEL_EXPORTER_OTLP_ENDPOINT, e.g. http://localhost:4318
+//   - "" does not export the spans, the trace context is propagated regardless
+func newTracerProvider(ctx context.Context, serviceName, exporter string) (tracerProvider, error) {
+	opts := []sdktrace.TracerProviderOption{
+		sdktrace.WithResource(resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceNameKey.String(serviceName))),
+	}
+	switch exporter {
+	case "":
+	case "stdout":
+		e, err := stdouttrace.New()
+		if err != nil {
+			return tracerProvider{}, err
+		}
+		opts = append(opts, sdktrace.WithBatcher(e))
+	case "otlp":
+		if utils.GetEnv("OTEL_EXPORTER_OTLP_ENDPOINT", "") == "" {
+			return tracerProvider{}, fmt.Errorf("otlp exporter endpoint is not set")
+		}
+		e, err := otlptracehttp.New(ctx)
+		if err != nil {
+			return tracerProvider{}, err
+		}
+		opts = append(opts, sdktrace.WithBatcher(e))
+	default:
+		return tracerProvider{}, fmt.Errorf("unknown trace exporter %s", exporter)
+	}
+	return tracerProvider{sdktrace.NewTracerProvider(opts...)}, nil
 }
 
 func main() {
-	s.Start(utils.GetEnv("PORT", "9000"))
+	setup()
+	s.OnShutdown(closers...)
+	if err := s.Start(utils.GetEnv("PORT", "9000")); err != nil {
+		logging.Default().Fatal("failed to start the service", "error", err)
+	}
 }
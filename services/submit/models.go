@@ -16,7 +16,7 @@ See the License for the specific language governing permissions and limitations
 package main
 
 import (
-	"platform/lib/utils"
+	"platform/lib/jsonschema"
 	"time"
 
 	"github.com/goccy/go-json"
@@ -30,10 +30,10 @@ type PayloadHotstorage struct {
 	Payload         []byte `json:"payload"`
 }
 
-func NewPayloadHotStorage(submitterID string, payload []byte, valid bool) *PayloadHotstorage {
+func NewPayloadHotStorage(submitterID, submissionID string, payload []byte, valid bool) *PayloadHotstorage {
 	return &PayloadHotstorage{
 		SubmitterID:     submitterID,
-		SubmissionID:    utils.GenerateUUID4(),
+		SubmissionID:    submissionID,
 		SubmissionEpoch: time.Now().Unix(),
 		Valid:           valid,
 		Payload:         payload,
@@ -46,6 +46,8 @@ type payloadLocation struct {
 	SubmissionID string `json:"submission_id"`
 	Bucket       string `json:"bucket"`
 	Obj          string `json:"key"`
+	// SchemaVersion defines the schema version of the submission, it's not set if the version is unknown.
+	SchemaVersion int `json:"schema_version,omitempty"`
 }
 
 func (p *payloadLocation) MustSerialize() []byte {
@@ -54,11 +56,39 @@ func (p *payloadLocation) MustSerialize() []byte {
 }
 
 type response struct {
-	SubmissionID string   `json:"submission_id"`
-	Errors       []string `json:"errors,omitempty"`
+	SubmissionID string `json:"submission_id"`
+	// ResubmissionOf defines the ID of the quarantined submission the submission corrects.
+	ResubmissionOf string                  `json:"resubmission_of,omitempty"`
+	Errors         []jsonschema.FieldError `json:"errors,omitempty"`
 }
 
 func (r *response) MustSerialize() []byte {
 	o, _ := json.Marshal(r)
 	return o
 }
+
+// storedObject defines the stored raw data object in the list.
+type storedObject struct {
+	SubmitterID  string    `json:"submitter_id"`
+	SubmissionID string    `json:"submission_id"`
+	SubmittedAt  time.Time `json:"submitted_at"`
+	Size         int64     `json:"size"`
+	ContentType  string    `json:"content_type,omitempty"`
+	// Valid is not set for the objects stored before the validity was recorded.
+	Valid         *bool `json:"valid,omitempty"`
+	SchemaVersion int   `json:"schema_version,omitempty"`
+	// CRC32C and MD5 define the base64 encoded checksums of the object.
+	CRC32C string `json:"crc32c"`
+	MD5    string `json:"md5,omitempty"`
+}
+
+type objectList struct {
+	Objects []storedObject `json:"objects"`
+	// NextPageToken defines the token to list the next page, it's not set if all objects are listed.
+	NextPageToken string `json:"next_page_token,omitempty"`
+}
+
+func (l *objectList) MustSerialize() []byte {
+	o, _ := json.Marshal(l)
+	return o
+}
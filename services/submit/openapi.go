@@ -0,0 +1,249 @@
+/* Copyright 2021 Dmitry Kisler dkisler.com
+
+Licensed under the Apache License,Version 2.0 (the "License");
+you may not use this file except in compliance with the License. You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED,
+INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE,
+AND NONINFRINGEMENT. IN NO EVENT WILL THE LICENSOR OR OTHER CONTRIBUTORS BE LIABLE FOR ANY CLAIM, DAMAGES,
+OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF,
+OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+See the License for the specific language governing permissions and limitations under the License.
+*/
+
+package main
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	httpStatus "net/http"
+	"platform/lib/api/http"
+	"platform/lib/api/openapi"
+	"platform/lib/registry"
+	"platform/lib/registry/submission"
+)
+
+// openAPIInfo defines the metadata of the service's OpenAPI document.
+var openAPIInfo = openapi.Info{
+	Title:       "submit",
+	Description: "Raw data submission.",
+	Version:     "v1.0",
+}
+
+var (
+	//go:embed models/response_ok.json
+	responseOKSchema []byte
+	//go:embed models/response_fail.json
+	responseFailSchema []byte
+	//go:embed models/list.json
+	listSchema []byte
+	//go:embed models/quarantine_list.json
+	quarantineListSchema []byte
+	//go:embed models/quarantine_record.json
+	quarantineRecordSchema []byte
+)
+
+// submissionDataReq defines the submission schemas by version, see the submission registry.
+var submissionDataReq = func() map[int]*http.Schema {
+	o := map[int]*http.Schema{}
+	for _, v := range submission.Registry.Versions() {
+		o[v.Version] = http.NewSchema(fmt.Sprintf("submission_data_req_v%d", v.Version), v.JSON)
+	}
+	return o
+}()
+
+// submissionSchema selects the schema of the submission by its declared version.
+func submissionSchema(r *http.Request) (*http.Schema, error) {
+	v, err := submission.Registry.Resolve(r.Headers[registry.VersionHeader], r.Body)
+	if err != nil {
+		return nil, err
+	}
+	return submissionDataReq[v.Version], nil
+}
+
+var (
+	submissionRespOK     = http.NewSchema("submission_resp_ok", responseOKSchema)
+	submissionRespFail   = http.NewSchema("submission_resp_fail", responseFailSchema)
+	objectListResp       = http.NewSchema("raw_data_list", listSchema)
+	quarantineListResp   = http.NewSchema("quarantine_list", quarantineListSchema)
+	quarantineRecordResp = http.NewSchema("quarantine_record", quarantineRecordSchema)
+)
+
+var submitDoc = &http.EndpointDoc{
+	ID:          "publishRawData",
+	Description: "Publish a raw data sample to the platform.",
+	Parameters: []openapi.Parameter{
+		{
+			Name: registry.VersionHeader,
+			In:   openapi.InHeader,
+			Description: "Schema version of the submission, it can be declared with the schema_version field instead. " +
+				"The submission which does not declare the version is validated against the version 1.",
+			Schema: json.RawMessage(`{"type": "integer", "minimum": 1}`),
+		},
+	},
+	Request:            submissionDataReq[submission.Registry.Latest().Version],
+	RequestDescription: "Data sample of the latest schema version.",
+	SelectRequest:      submissionSchema,
+	// the invalid submissions are stored to be inspected, and rejected with the status code 400
+	AcceptInvalidRequest: true,
+	Responses: map[int]*http.ResponseDoc{
+		httpStatus.StatusOK:                  {Description: "Success", Schema: submissionRespOK},
+		httpStatus.StatusAccepted:            {Description: "Stored, the notification is pending", Schema: submissionRespOK},
+		httpStatus.StatusBadRequest:          {Description: "Invalid input", Schema: submissionRespFail},
+		httpStatus.StatusInternalServerError: {Description: "Service internal error", Schema: submissionRespFail},
+	},
+}
+
+// submitterIDSchema defines the schema of the submitter ID parameters.
+var submitterIDSchema = json.RawMessage(fmt.Sprintf(`{"type": "string", "pattern": %q}`, submitterIDPattern))
+
+var readDoc = &http.EndpointDoc{
+	ID:          "getRawDataBySubmissionID",
+	Description: "Fetch previously submitted raw data sample.",
+	Parameters: []openapi.Parameter{
+		{
+			Name:        "submission_id",
+			In:          openapi.InQuery,
+			Description: "Raw data submission ID.",
+			Required:    true,
+			Schema:      json.RawMessage(`{"type": "string", "format": "uuid"}`),
+		},
+	},
+	Responses: map[int]*http.ResponseDoc{
+		httpStatus.StatusOK:       {Description: "Success", Schema: submissionDataReq[submission.Registry.Latest().Version]},
+		httpStatus.StatusNotFound: {Description: "Data not found", Schema: http.ErrorSchema},
+	},
+}
+
+var listDoc = &http.EndpointDoc{
+	ID:          "listRawData",
+	Description: "List the raw data submissions of the submitter in the submission ID order.",
+	Parameters: []openapi.Parameter{
+		{
+			Name:        "submitter_id",
+			In:          openapi.InQuery,
+			Description: "Submitter of the submissions.",
+			Required:    true,
+			Schema:      submitterIDSchema,
+		},
+		{
+			Name:        "from",
+			In:          openapi.InQuery,
+			Description: "Time the submissions were submitted at or after.",
+			Schema:      json.RawMessage(`{"type": "string", "format": "date-time"}`),
+		},
+		{
+			Name:        "to",
+			In:          openapi.InQuery,
+			Description: "Time the submissions were submitted before.",
+			Schema:      json.RawMessage(`{"type": "string", "format": "date-time"}`),
+		},
+		{
+			Name:        "limit",
+			In:          openapi.InQuery,
+			Description: "Max number of the listed submissions, the page may contain less submissions even if more are left.",
+			Schema:      json.RawMessage(fmt.Sprintf(`{"type": "integer", "minimum": 1, "maximum": 1000, "default": %d}`, defaultListLimit)),
+		},
+		{
+			Name:        "page_token",
+			In:          openapi.InQuery,
+			Description: "Token of the page to list returned as next_page_token, the first page is listed if not set.",
+			Schema:      json.RawMessage(`{"type": "string"}`),
+		},
+	},
+	Responses: map[int]*http.ResponseDoc{
+		httpStatus.StatusOK:         {Description: "Success", Schema: objectListResp},
+		httpStatus.StatusBadRequest: {Description: "Invalid parameters", Schema: http.ErrorSchema},
+	},
+}
+
+var reconcileDoc = &http.EndpointDoc{
+	ID:          "reconcile",
+	Description: "Publish the notifications failed to be published on submission.",
+	Parameters: []openapi.Parameter{
+		{
+			Name:        "prefix",
+			In:          openapi.InQuery,
+			Description: "Prefix of the objects to reconcile, all objects are reconciled if not set.",
+			Schema:      json.RawMessage(`{"type": "string"}`),
+		},
+	},
+	Responses: map[int]*http.ResponseDoc{
+		httpStatus.StatusOK: {Description: "Reconciliation report"},
+	},
+}
+
+var listQuarantineDoc = &http.EndpointDoc{
+	ID:          "listQuarantinedRawData",
+	Description: "List the quarantined invalid submissions in the submitter and the submission ID order.",
+	Tags:        []string{"quarantine"},
+	Parameters: []openapi.Parameter{
+		{
+			Name:        "submitter_id",
+			In:          openapi.InQuery,
+			Description: "Submitter of the submissions, the submissions of all submitters are listed if not set.",
+			Schema:      submitterIDSchema,
+		},
+		{
+			Name:        "from",
+			In:          openapi.InQuery,
+			Description: "Time the submissions were received at or after.",
+			Schema:      json.RawMessage(`{"type": "string", "format": "date-time"}`),
+		},
+		{
+			Name:        "to",
+			In:          openapi.InQuery,
+			Description: "Time the submissions were received before.",
+			Schema:      json.RawMessage(`{"type": "string", "format": "date-time"}`),
+		},
+		{
+			Name:        "limit",
+			In:          openapi.InQuery,
+			Description: "Max number of the listed submissions, the page may contain less submissions even if more are left.",
+			Schema:      json.RawMessage(fmt.Sprintf(`{"type": "integer", "minimum": 1, "maximum": 1000, "default": %d}`, defaultQuarantineLimit)),
+		},
+		{
+			Name:        "page_token",
+			In:          openapi.InQuery,
+			Description: "Token of the page to list returned as next_page_token, the first page is listed if not set.",
+			Schema:      json.RawMessage(`{"type": "string"}`),
+		},
+	},
+	Responses: map[int]*http.ResponseDoc{
+		httpStatus.StatusOK:         {Description: "Quarantined submissions", Schema: quarantineListResp},
+		httpStatus.StatusBadRequest: {Description: "Invalid parameters", Schema: http.ErrorSchema},
+	},
+}
+
+var getQuarantineDoc = &http.EndpointDoc{
+	ID:          "getQuarantinedRawData",
+	Description: "Fetch the validation errors of the quarantined submission.",
+	Tags:        []string{"quarantine"},
+	Responses: map[int]*http.ResponseDoc{
+		httpStatus.StatusOK:       {Description: "Quarantined submission", Schema: quarantineRecordResp},
+		httpStatus.StatusNotFound: {Description: "Quarantined submission not found", Schema: http.ErrorSchema},
+	},
+}
+
+var resubmitDoc = &http.EndpointDoc{
+	ID:                 "resubmitQuarantinedRawData",
+	Description:        "Publish the corrected data sample of the quarantined submission.",
+	Tags:               []string{"quarantine"},
+	Parameters:         submitDoc.Parameters,
+	Request:            submitDoc.Request,
+	RequestDescription: submitDoc.RequestDescription,
+	SelectRequest:      submitDoc.SelectRequest,
+	// the invalid correction is quarantined as well
+	AcceptInvalidRequest: true,
+	Responses: map[int]*http.ResponseDoc{
+		httpStatus.StatusOK:                  {Description: "Success", Schema: submissionRespOK},
+		httpStatus.StatusAccepted:            {Description: "Stored, the notification is pending", Schema: submissionRespOK},
+		httpStatus.StatusBadRequest:          {Description: "Invalid input", Schema: submissionRespFail},
+		httpStatus.StatusNotFound:            {Description: "Quarantined submission not found", Schema: http.ErrorSchema},
+		httpStatus.StatusConflict:            {Description: "Submission was already resubmitted, or is being resubmitted", Schema: http.ErrorSchema},
+		httpStatus.StatusInternalServerError: {Description: "Service internal error", Schema: submissionRespFail},
+	},
+}
@@ -0,0 +1,229 @@
+/* Copyright 2021 Dmitry Kisler dkisler.com
+
+Licensed under the Apache License,Version 2.0 (the "License");
+you may not use this file except in compliance with the License. You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED,
+INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE,
+AND NONINFRINGEMENT. IN NO EVENT WILL THE LICENSOR OR OTHER CONTRIBUTORS BE LIABLE FOR ANY CLAIM, DAMAGES,
+OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF,
+OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+See the License for the specific language governing permissions and limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	httpStatus "net/http"
+	"path"
+	"platform/lib/api/http"
+	"platform/lib/io/store/gcs"
+	"platform/lib/jsonschema"
+	"platform/lib/logging"
+	"platform/lib/utils"
+	"time"
+
+	"github.com/goccy/go-json"
+)
+
+// quarantinePrefix defines the prefix of the quarantine records objects {prefix}/{submitter}/{submission}.json.
+// The prefix is reserved, it can't be the submitter ID of the raw data objects, see submitterIDPattern.
+const quarantinePrefix = "_quarantine"
+
+// metadata keys of the quarantine records objects
+const (
+	metadataReceivedAt    = "received_at"
+	metadataResubmittedAs = "resubmitted_as"
+)
+
+// quarantineRecord defines the invalid submission to be reviewed.
+type quarantineRecord struct {
+	SubmitterID   string    `json:"submitter_id"`
+	SubmissionID  string    `json:"submission_id"`
+	ReceivedAt    time.Time `json:"received_at"`
+	SchemaVersion int       `json:"schema_version,omitempty"`
+	// ResubmissionOf defines the ID of the quarantined submission the submission corrects.
+	ResubmissionOf string `json:"resubmission_of,omitempty"`
+	// ResubmittedAs defines the ID of the valid submission correcting the submission,
+	// it's set while the correction is submitted to claim the submission, see resubmit.
+	ResubmittedAs string                  `json:"resubmitted_as,omitempty"`
+	Errors        []jsonschema.FieldError `json:"errors"`
+}
+
+func (q *quarantineRecord) MustSerialize() []byte {
+	o, _ := json.Marshal(q)
+	return o
+}
+
+// quarantineSummary defines the quarantined submission in the list.
+type quarantineSummary struct {
+	SubmitterID   string    `json:"submitter_id"`
+	SubmissionID  string    `json:"submission_id"`
+	ReceivedAt    time.Time `json:"received_at"`
+	ResubmittedAs string    `json:"resubmitted_as,omitempty"`
+}
+
+type quarantineList struct {
+	Submissions []quarantineSummary `json:"submissions"`
+	// NextPageToken defines the token to list the next page, it's not set if all submissions are listed.
+	NextPageToken string `json:"next_page_token,omitempty"`
+}
+
+func (l *quarantineList) MustSerialize() []byte {
+	o, _ := json.Marshal(l)
+	return o
+}
+
+func quarantineKey(submitter, submissionID string) string {
+	return path.Join(quarantinePrefix, submitter, fmt.Sprintf("%s.json", submissionID))
+}
+
+// quarantineMetadata returns the metadata of the record's object, it defines the attributes to list the records by.
+func quarantineMetadata(record *quarantineRecord) map[string]string {
+	metadata := map[string]string{
+		metadataSubmitterID:  record.SubmitterID,
+		metadataSubmissionID: record.SubmissionID,
+		metadataReceivedAt:   record.ReceivedAt.Format(time.RFC3339),
+	}
+	if record.ResubmittedAs != "" {
+		metadata[metadataResubmittedAs] = record.ResubmittedAs
+	}
+	return metadata
+}
+
+// quarantine writes the quarantine record of the submission.
+func quarantine(ctx context.Context, runner *runner, bucket string, record *quarantineRecord) error {
+	return runner.ColdStorage.WriteWithMetadata(ctx, bucket, quarantineKey(record.SubmitterID, record.SubmissionID),
+		record.MustSerialize(), quarantineMetadata(record))
+}
+
+// requarantine rewrites the quarantine record of the submission if it was not changed since its generation was read,
+// and returns the generation of the rewritten record.
+func requarantine(ctx context.Context, runner *runner, bucket string, record *quarantineRecord, generation int64) (int64, error) {
+	return runner.ColdStorage.WriteIfGeneration(ctx, bucket, quarantineKey(record.SubmitterID, record.SubmissionID),
+		record.MustSerialize(), quarantineMetadata(record), generation)
+}
+
+var errQuarantineNotFound = http.NewError(http.KindNotFound, "quarantined submission not found")
+
+// quarantined reads the quarantine record of the submission, and returns the generation of the record's object.
+func quarantined(ctx context.Context, runner *runner, bucket, submitter, submissionID string) (*quarantineRecord, int64, error) {
+	// the submissions of the invalid submitter IDs are not quarantined
+	if !reSubmitterID.MatchString(submitter) {
+		return nil, 0, errQuarantineNotFound
+	}
+	data, generation, err := runner.ColdStorage.ReadGeneration(ctx, bucket, quarantineKey(submitter, submissionID))
+	if err != nil {
+		if errors.Is(err, gcs.ErrObjectNotExist) {
+			return nil, 0, errQuarantineNotFound
+		}
+		return nil, 0, err
+	}
+	var o *quarantineRecord
+	if err := json.Unmarshal(data, &o); err != nil {
+		return nil, 0, err
+	}
+	return o, generation, nil
+}
+
+// defaultQuarantineLimit defines the number of the listed quarantine records if the limit is not set.
+const defaultQuarantineLimit = 100
+
+// listQuarantine lists the quarantined submissions in the submitter and the submission ID order.
+// The submissions can be filtered by submitter, and by the time they were received at.
+func listQuarantine(runner *runner, bucket *string) http.Action {
+	return func(r *http.Request) (*http.Response, error) {
+		// the parameters are validated by the router, see listQuarantineDoc
+		prefix := quarantinePrefix + "/"
+		if submitter := r.Query["submitter_id"]; submitter != "" {
+			prefix = path.Join(quarantinePrefix, submitter) + "/"
+		}
+		params, err := parseListParams(r.Query, defaultQuarantineLimit)
+		if err != nil {
+			return nil, err
+		}
+
+		page, err := runner.ColdStorage.List(r.Context(), *bucket, prefix, params.pageToken, params.limit, func(obj *gcs.Object) bool {
+			received, err := time.Parse(time.RFC3339, obj.Metadata[metadataReceivedAt])
+			if err != nil {
+				logging.FromContext(r.Context()).Warning("unexpected quarantine record", "key", obj.Name)
+				return false
+			}
+			return params.inRange(received)
+		})
+		if err != nil {
+			if errors.Is(err, gcs.ErrInvalidPageToken) {
+				return nil, http.NewError(http.KindValidation, "invalid page token")
+			}
+			return nil, err
+		}
+
+		o := &quarantineList{Submissions: []quarantineSummary{}, NextPageToken: page.NextPageToken}
+		for _, obj := range page.Objects {
+			// the time is parsed by the filter
+			received, _ := time.Parse(time.RFC3339, obj.Metadata[metadataReceivedAt])
+			o.Submissions = append(o.Submissions, quarantineSummary{
+				SubmitterID:   obj.Metadata[metadataSubmitterID],
+				SubmissionID:  obj.Metadata[metadataSubmissionID],
+				ReceivedAt:    received.UTC(),
+				ResubmittedAs: obj.Metadata[metadataResubmittedAs],
+			})
+		}
+		return http.NewResponse(o.MustSerialize(), httpStatus.StatusOK), nil
+	}
+}
+
+// getQuarantine returns the quarantine record of the submission, the payload is read by the "/read" endpoint.
+func getQuarantine(runner *runner, bucket *string) http.Action {
+	return func(r *http.Request) (*http.Response, error) {
+		record, _, err := quarantined(r.Context(), runner, *bucket, r.RouteParameters["submitter_id"], r.RouteParameters["submission_id"])
+		if err != nil {
+			return nil, err
+		}
+		return http.NewResponse(record.MustSerialize(), httpStatus.StatusOK), nil
+	}
+}
+
+// resubmit submits the corrected payload of the quarantined submission.
+// The corrected submission is linked to the quarantined one, and the quarantine record is linked to the corrected
+// submission once it's accepted as valid. The submission can be corrected only once,
+// the invalid correction is quarantined itself, and can be corrected in turn.
+func resubmit(runner *runner, bucket *string) http.Action {
+	return func(r *http.Request) (*http.Response, error) {
+		record, generation, err := quarantined(r.Context(), runner, *bucket, r.RouteParameters["submitter_id"], r.RouteParameters["submission_id"])
+		if err != nil {
+			return nil, err
+		}
+		if record.ResubmittedAs != "" {
+			return nil, http.NewError(http.KindConflict, fmt.Sprintf("the submission was already resubmitted as %s", record.ResubmittedAs))
+		}
+
+		// the record is claimed by linking it to the correction before the correction is submitted,
+		// the concurrent resubmissions fail to claim the record as it was changed since they read it
+		submissionID := utils.GenerateUUID4()
+		record.ResubmittedAs = submissionID
+		claimed, err := requarantine(r.Context(), runner, *bucket, record, generation)
+		if err != nil {
+			if errors.Is(err, gcs.ErrPreconditionFailed) {
+				return nil, http.NewError(http.KindConflict, "the submission is being resubmitted")
+			}
+			return nil, err
+		}
+
+		resp, status := accept(r, runner, *bucket, record.SubmitterID, submissionID, record.SubmissionID)
+		if status != httpStatus.StatusOK && status != httpStatus.StatusAccepted {
+			// the claim is released, the submission can be corrected again
+			record.ResubmittedAs = ""
+			if _, err := requarantine(r.Context(), runner, *bucket, record, claimed); err != nil {
+				logging.FromContext(r.Context()).Error("failed to release the quarantined submission", "error", err,
+					"submission_id", record.SubmissionID, "resubmitted_as", submissionID)
+			}
+		}
+		return http.NewResponse(resp.MustSerialize(), status), nil
+	}
+}
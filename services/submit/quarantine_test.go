@@ -0,0 +1,224 @@
+/* Copyright 2021 Dmitry Kisler dkisler.com
+
+Licensed under the Apache License,Version 2.0 (the "License");
+you may not use this file except in compliance with the License. You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED,
+INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE,
+AND NONINFRINGEMENT. IN NO EVENT WILL THE LICENSOR OR OTHER CONTRIBUTORS BE LIABLE FOR ANY CLAIM, DAMAGES,
+OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF,
+OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+See the License for the specific language governing permissions and limitations under the License.
+*/
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	httpStatus "net/http"
+	"platform/lib/api/http"
+	"platform/lib/utils"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/goccy/go-json"
+	"github.com/valyala/fasthttp"
+)
+
+func TestQuarantine(t *testing.T) {
+	runner, store, success, _ := newTestRunner()
+	b := bucket
+	router := http.NewRequestHandlers(map[string]*http.HandlerEndpoint{
+		"/":           http.NewHandlerEndpoint(submit(runner, &b), []string{"POST"}).WithDoc(submitDoc),
+		"/quarantine": http.NewHandlerEndpoint(listQuarantine(runner, &b), []string{"GET"}).WithDoc(listQuarantineDoc),
+		"/quarantine/{:submitter_id}/{:submission_id:uuid}": http.NewHandlerEndpoint(getQuarantine(runner, &b), []string{"GET"}).
+			WithDoc(getQuarantineDoc),
+		"/quarantine/{:submitter_id}/{:submission_id:uuid}/resubmit": http.NewHandlerEndpoint(resubmit(runner, &b), []string{"POST"}).
+			WithDoc(resubmitDoc),
+	}).WithResponseValidation().Router()
+	do := func(method, uri, body string, dst interface{}) int {
+		t.Helper()
+		ctx := &fasthttp.RequestCtx{}
+		ctx.Request.Header.SetMethod(method)
+		ctx.Request.SetRequestURI(uri)
+		ctx.Request.SetBodyString(body)
+		router(ctx)
+		if err := json.Unmarshal(ctx.Response.Body(), dst); err != nil {
+			t.Fatalf("error for %s %s!\nwant: JSON\ngot: %s", method, uri, ctx.Response.Body())
+		}
+		return ctx.Response.StatusCode()
+	}
+	list := func(query string) []quarantineSummary {
+		t.Helper()
+		var o quarantineList
+		if status := do("GET", "/quarantine"+query, "", &o); status != httpStatus.StatusOK {
+			t.Fatalf("error for %s!\nwant: %d\ngot: %d", query, httpStatus.StatusOK, status)
+		}
+		return o.Submissions
+	}
+
+	var invalid response
+	if status := do("POST", "/", payloadInvalid, &invalid); status != httpStatus.StatusBadRequest {
+		t.Fatalf("error!\nwant: %d\ngot: %d", httpStatus.StatusBadRequest, status)
+	}
+	var record quarantineRecord
+	uri := fmt.Sprintf("/quarantine/%s/%s", submitterID, invalid.SubmissionID)
+	if status := do("GET", uri, "", &record); status != httpStatus.StatusOK || len(record.Errors) == 0 {
+		t.Fatalf("error!\nwant: quarantine record with errors\ngot: %d %+v", status, record)
+	}
+
+	from := time.Now().Add(-time.Hour).UTC().Format(time.RFC3339)
+	if got := list(""); len(got) != 1 || got[0].SubmissionID != invalid.SubmissionID {
+		t.Fatalf("error!\nwant: %s listed\ngot: %+v", invalid.SubmissionID, got)
+	}
+	if got := list("?submitter_id=" + submitterID + "&from=" + from); len(got) != 1 {
+		t.Fatalf("error!\nwant: 1 submission listed\ngot: %+v", got)
+	}
+	if got := list("?submitter_id=other"); len(got) != 0 {
+		t.Fatalf("error!\nwant: no submissions of other submitter\ngot: %+v", got)
+	}
+	if got := list("?to=" + from); len(got) != 0 {
+		t.Fatalf("error!\nwant: no submissions received before %s\ngot: %+v", from, got)
+	}
+
+	var e struct {
+		Error string `json:"error"`
+	}
+	if status := do("GET", fmt.Sprintf("/quarantine/%s/%s", submitterID, "00000000-0000-4000-8000-000000000000"), "", &e); status != httpStatus.StatusNotFound {
+		t.Fatalf("error!\nwant: %d\ngot: %d", httpStatus.StatusNotFound, status)
+	}
+
+	// the invalid correction is quarantined itself
+	var retry response
+	if status := do("POST", uri+"/resubmit", payloadInvalid, &retry); status != httpStatus.StatusBadRequest || retry.ResubmissionOf != invalid.SubmissionID {
+		t.Fatalf("error!\nwant: %d resubmission of %s\ngot: %d %+v", httpStatus.StatusBadRequest, invalid.SubmissionID, status, retry)
+	}
+	if got := list(""); len(got) != 2 || got[0].ResubmittedAs != "" || got[1].ResubmittedAs != "" {
+		t.Fatalf("error!\nwant: 2 submissions not resubmitted\ngot: %+v", got)
+	}
+	// the submissions are listed page by page
+	listed := map[string]bool{}
+	token := ""
+	for pages := 1; ; pages++ {
+		var o quarantineList
+		query := "?limit=1&page_token=" + token
+		if status := do("GET", "/quarantine"+query, "", &o); status != httpStatus.StatusOK || len(o.Submissions) != 1 || pages > 2 {
+			t.Fatalf("error for page %d!\nwant: %d with 1 submission\ngot: %d %+v", pages, httpStatus.StatusOK, status, o)
+		}
+		listed[o.Submissions[0].SubmissionID] = true
+		if token = o.NextPageToken; token == "" {
+			break
+		}
+	}
+	if !listed[invalid.SubmissionID] || !listed[retry.SubmissionID] {
+		t.Fatalf("error!\nwant: %s and %s listed\ngot: %v", invalid.SubmissionID, retry.SubmissionID, listed)
+	}
+	if status := do("GET", "/quarantine?page_token=!", "", &e); status != httpStatus.StatusBadRequest {
+		t.Fatalf("error!\nwant: %d\ngot: %d", httpStatus.StatusBadRequest, status)
+	}
+
+	var fixed response
+	if status := do("POST", uri+"/resubmit", payloadValid, &fixed); status != httpStatus.StatusOK || fixed.ResubmissionOf != invalid.SubmissionID {
+		t.Fatalf("error!\nwant: %d resubmission of %s\ngot: %d %+v", httpStatus.StatusOK, invalid.SubmissionID, status, fixed)
+	}
+	key := fmt.Sprintf("%s/%s/%s.json", submitterID, fixed.SubmissionID, fixed.SubmissionID)
+	if got := store.metadata(key)[metadataResubmissionOf]; got != invalid.SubmissionID || success.count() != 1 {
+		t.Fatalf("error!\nwant: submission linked to %s and notified\ngot: %s, %d", invalid.SubmissionID, got, success.count())
+	}
+	if status := do("GET", uri, "", &record); status != httpStatus.StatusOK || record.ResubmittedAs != fixed.SubmissionID {
+		t.Fatalf("error!\nwant: resubmitted as %s\ngot: %+v", fixed.SubmissionID, record)
+	}
+	if status := do("POST", uri+"/resubmit", payloadValid, &e); status != httpStatus.StatusConflict {
+		t.Fatalf("error!\nwant: %d\ngot: %d", httpStatus.StatusConflict, status)
+	}
+}
+
+// TestReservedSubmitterID checks that the submitter IDs can't collide with the quarantine records prefix.
+func TestReservedSubmitterID(t *testing.T) {
+	runner, store, success, fail := newTestRunner()
+	for _, submitter := range []string{quarantinePrefix, "_other", ".hidden", ""} {
+		resp, status := accept(&http.Request{Body: []byte(payloadValid)}, runner, bucket, submitter, utils.GenerateUUID4(), "")
+		if status != httpStatus.StatusBadRequest || resp.SubmissionID == "" || len(resp.Errors) != 1 {
+			t.Fatalf("error for %q!\nwant: %d with the error\ngot: %d %+v", submitter, httpStatus.StatusBadRequest, status, resp)
+		}
+	}
+	if len(store.objects) != 0 || success.count() != 0 || fail.count() != 0 {
+		t.Fatalf("error!\nwant: nothing stored and notified\ngot: %d objects, %d, %d messages", len(store.objects), success.count(), fail.count())
+	}
+
+	b := bucket
+	router := http.NewRequestHandlers(map[string]*http.HandlerEndpoint{
+		"/list":       http.NewHandlerEndpoint(list(runner, &b), []string{"GET"}).WithDoc(listDoc),
+		"/quarantine": http.NewHandlerEndpoint(listQuarantine(runner, &b), []string{"GET"}).WithDoc(listQuarantineDoc),
+		"/quarantine/{:submitter_id}/{:submission_id:uuid}": http.NewHandlerEndpoint(getQuarantine(runner, &b), []string{"GET"}).
+			WithDoc(getQuarantineDoc),
+	}).WithResponseValidation().Router()
+	for uri, want := range map[string]int{
+		"/list?submitter_id=" + quarantinePrefix:                                               httpStatus.StatusBadRequest,
+		"/quarantine?submitter_id=" + quarantinePrefix:                                         httpStatus.StatusBadRequest,
+		"/quarantine/" + quarantinePrefix + "/00000000-0000-4000-8000-000000000000":            httpStatus.StatusNotFound,
+		"/quarantine/" + submitterID + "/00000000-0000-4000-8000-000000000000":                 httpStatus.StatusNotFound,
+		"/list?submitter_id=" + submitterID + "&from=" + time.Now().UTC().Format(time.RFC3339): httpStatus.StatusOK,
+	} {
+		ctx := &fasthttp.RequestCtx{}
+		ctx.Request.Header.SetMethod("GET")
+		ctx.Request.SetRequestURI(uri)
+		router(ctx)
+		if ctx.Response.StatusCode() != want {
+			t.Fatalf("error for %s!\nwant: %d\ngot: %d", uri, want, ctx.Response.StatusCode())
+		}
+	}
+}
+
+// TestConcurrentResubmit checks that the quarantined submission is resubmitted once by the concurrent requests.
+func TestConcurrentResubmit(t *testing.T) {
+	runner, store, success, _ := newTestRunner()
+	invalid, status, err := doSubmit(runner, payloadInvalid)
+	if err != nil || status != httpStatus.StatusBadRequest {
+		t.Fatalf("error!\nwant: %d\ngot: %d %v", httpStatus.StatusBadRequest, status, err)
+	}
+
+	const requests = 3
+	// the record is read by all requests before any of them claims it
+	var read sync.WaitGroup
+	read.Add(requests)
+	store.afterRead = func() {
+		read.Done()
+		read.Wait()
+	}
+	b := bucket
+	statuses := make(chan int, requests)
+	var wg sync.WaitGroup
+	for i := 0; i < requests; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, err := resubmit(runner, &b)(&http.Request{
+				RouteParameters: map[string]string{"submitter_id": submitterID, "submission_id": invalid.SubmissionID},
+				Body:            []byte(payloadValid),
+			})
+			var e *http.Error
+			if errors.As(err, &e) {
+				statuses <- e.StatusCode()
+				return
+			}
+			statuses <- resp.StatusCode
+		}()
+	}
+	wg.Wait()
+	close(statuses)
+
+	got := map[int]int{}
+	for s := range statuses {
+		got[s]++
+	}
+	want := map[int]int{httpStatus.StatusOK: 1, httpStatus.StatusConflict: requests - 1}
+	if !reflect.DeepEqual(got, want) || success.count() != 1 {
+		t.Fatalf("error!\nwant: %v, 1 notification\ngot: %v, %d", want, got, success.count())
+	}
+}
@@ -0,0 +1,205 @@
+/* Copyright 2021 Dmitry Kisler dkisler.com
+
+Licensed under the Apache License,Version 2.0 (the "License");
+you may not use this file except in compliance with the License. You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED,
+INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE,
+AND NONINFRINGEMENT. IN NO EVENT WILL THE LICENSOR OR OTHER CONTRIBUTORS BE LIABLE FOR ANY CLAIM, DAMAGES,
+OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF,
+OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+See the License for the specific language governing permissions and limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	httpStatus "net/http"
+	"platform/lib/api/http"
+	"platform/lib/errgroup"
+	"platform/lib/io/store/gcs"
+	"platform/lib/logging"
+	"platform/lib/trace"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/goccy/go-json"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	oteltrace "go.opentelemetry.io/otel/trace"
+)
+
+// reconciler publishes the notifications about the cold storage objects
+// which were stored, but failed to be notified by the submit endpoint.
+type reconciler struct {
+	runner *runner
+	bucket string
+	// gracePeriod defines the age of the not notified object to be reconciled,
+	// it prevents from notifying about the objects with the submission in-flight
+	gracePeriod time.Duration
+
+	// ctx is canceled when the reconciler is closed
+	ctx    context.Context
+	cancel context.CancelFunc
+	done   sync.WaitGroup
+}
+
+func newReconciler(runner *runner, bucket string, gracePeriod time.Duration) *reconciler {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &reconciler{
+		runner:      runner,
+		bucket:      bucket,
+		gracePeriod: gracePeriod,
+		ctx:         ctx,
+		cancel:      cancel,
+	}
+}
+
+// reconcileReport defines the reconciliation result.
+type reconcileReport struct {
+	// Scanned defines the number of scanned objects.
+	Scanned int `json:"scanned"`
+	// Notified defines the keys of objects the notifications were published about.
+	Notified []string `json:"notified"`
+	// Errors defines the errors of publishing the notifications.
+	Errors []string `json:"errors,omitempty"`
+}
+
+func (r *reconcileReport) MustSerialize() []byte {
+	o, _ := json.Marshal(r)
+	return o
+}
+
+// parseLocation identifies the submission location from the object key {submitter}/{submission}/{submission}.json.
+func parseLocation(bucket, key string) (*payloadLocation, bool) {
+	el := strings.Split(key, "/")
+	if len(el) != 3 || el[2] != fmt.Sprintf("%s.json", el[1]) {
+		return nil, false
+	}
+	return &payloadLocation{
+		SubmitterID:  el[0],
+		SubmissionID: el[1],
+		Bucket:       bucket,
+		Obj:          key,
+	}, true
+}
+
+// reconcileConcurrency defines the max number of notifications published concurrently.
+const reconcileConcurrency = 8
+
+// tracer starts the spans of the reconciliation.
+var tracer = otel.Tracer("platform/submit")
+
+// Run scans the objects with the key prefix, and notifies about the objects which were not notified.
+// The objects stored before the metadata flags were introduced are skipped.
+func (r *reconciler) Run(ctx context.Context, prefix string) (*reconcileReport, error) {
+	ctx, span := tracer.Start(ctx, "reconcile")
+	report := &reconcileReport{Notified: []string{}}
+	before := time.Now().Add(-r.gracePeriod)
+
+	var mu sync.Mutex
+	g := errgroup.New().WithLimit(reconcileConcurrency)
+	err := r.runner.ColdStorage.Walk(ctx, r.bucket, prefix, func(o *gcs.Object) error {
+		report.Scanned++
+		if o.Metadata[metadataNotified] != "false" || o.Created.After(before) {
+			return nil
+		}
+		location, ok := parseLocation(r.bucket, o.Name)
+		if !ok {
+			return nil
+		}
+		// the version is not set for the objects stored before the schema versioning was introduced
+		location.SchemaVersion, _ = strconv.Atoi(o.Metadata[metadataSchemaVersion])
+		valid := o.Metadata[metadataValid] == "true"
+		// the notification continues the trace of the submission stored in the object metadata
+		ctxNotify, spanNotify := tracer.Start(
+			otel.GetTextMapPropagator().Extract(ctx, trace.Carrier(o.Metadata)), "reconcile.notify",
+			oteltrace.WithLinks(oteltrace.Link{SpanContext: span.SpanContext()}),
+		)
+		logger := logging.FromContext(ctx).With(
+			"submitter_id", location.SubmitterID,
+			"submission_id", location.SubmissionID,
+		)
+		ctxNotify = logging.NewContext(ctxNotify, logger)
+		g.Go(o.Name, func() error {
+			defer spanNotify.End()
+			if err := notify(ctxNotify, r.runner, location, valid); err != nil {
+				spanNotify.RecordError(err)
+				spanNotify.SetStatus(codes.Error, err.Error())
+				logger.Error("failed to notify about the submission", "error", err)
+				return err
+			}
+			logger.Info("notified about the submission")
+			mu.Lock()
+			report.Notified = append(report.Notified, location.Obj)
+			mu.Unlock()
+			return nil
+		})
+		return nil
+	})
+	for _, e := range g.Wait() {
+		report.Errors = append(report.Errors, e.Error())
+	}
+	sort.Strings(report.Notified)
+	span.SetAttributes(attribute.Int("scanned", report.Scanned), attribute.Int("notified", len(report.Notified)))
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+	return report, err
+}
+
+// Start runs the reconciliation periodically in background until the reconciler is closed.
+func (r *reconciler) Start(interval time.Duration) {
+	r.done.Add(1)
+	go func() {
+		defer r.done.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-r.ctx.Done():
+				return
+			case <-ticker.C:
+				ctx, cancel := context.WithTimeout(r.ctx, interval)
+				report, err := r.Run(ctx, "")
+				cancel()
+				if err != nil {
+					logging.Default().Error("reconciliation failed", "error", err)
+				}
+				if len(report.Notified) > 0 || len(report.Errors) > 0 {
+					logging.Default().Info(
+						"reconciliation completed",
+						"scanned", report.Scanned, "notified", len(report.Notified), "errors", len(report.Errors),
+					)
+				}
+			}
+		}
+	}()
+}
+
+// Close stops the periodic reconciliation and waits for the running one to be canceled.
+func (r *reconciler) Close() error {
+	r.cancel()
+	r.done.Wait()
+	return nil
+}
+
+func reconcile(r *reconciler) http.Action {
+	return func(req *http.Request) (*http.Response, error) {
+		report, err := r.Run(req.Context(), req.Query["prefix"])
+		if err != nil {
+			return nil, err
+		}
+		return http.NewResponse(report.MustSerialize(), httpStatus.StatusOK), nil
+	}
+}
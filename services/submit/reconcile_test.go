@@ -0,0 +1,79 @@
+/* Copyright 2021 Dmitry Kisler dkisler.com
+
+Licensed under the Apache License,Version 2.0 (the "License");
+you may not use this file except in compliance with the License. You may obtain a copy of the License at
+http://www.apache.org/licenses/LICENSE-2.0
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR IMPLIED,
+INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY, FITNESS FOR A PARTICULAR PURPOSE,
+AND NONINFRINGEMENT. IN NO EVENT WILL THE LICENSOR OR OTHER CONTRIBUTORS BE LIABLE FOR ANY CLAIM, DAMAGES,
+OR OTHER LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM, OUT OF,
+OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN THE SOFTWARE.
+
+See the License for the specific language governing permissions and limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"platform/lib/io/store/gcs"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReconcile(t *testing.T) {
+	runner, store, success, fail := newTestRunner()
+	old := time.Now().Add(-time.Hour)
+	objects := []struct {
+		id       string
+		created  time.Time
+		valid    string
+		notified string
+	}{
+		{id: "a", created: old, valid: "true", notified: "false"},
+		{id: "b", created: old, valid: "false", notified: "false"},
+		{id: "c", created: old, valid: "true", notified: "true"},
+		// within the grace period
+		{id: "d", created: time.Now(), valid: "true", notified: "false"},
+		// publishing fails
+		{id: "e", created: old, valid: "true", notified: "false"},
+		// stored without metadata
+		{id: "f", created: old},
+	}
+	for _, o := range objects {
+		key := fmt.Sprintf("acme/%s/%s.json", o.id, o.id)
+		metadata := map[string]string{}
+		if o.notified != "" {
+			metadata[metadataValid] = o.valid
+			metadata[metadataNotified] = o.notified
+		}
+		store.objects[key] = &gcs.Object{Name: key, Created: o.created, Metadata: metadata}
+	}
+	store.objects["acme/foo.json"] = &gcs.Object{Name: "acme/foo.json", Created: old, Metadata: map[string]string{metadataNotified: "false"}}
+	success.fail = func(key string) bool { return strings.HasPrefix(key, "acme/e/") }
+
+	report, err := newReconciler(runner, bucket, time.Minute).Run(context.Background(), "acme/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &reconcileReport{
+		Scanned:  7,
+		Notified: []string{"acme/a/a.json", "acme/b/b.json"},
+		Errors:   []string{"acme/e/e.json: publish failed"},
+	}
+	if !reflect.DeepEqual(report, want) {
+		t.Fatalf("error!\nwant: %+v\ngot: %+v", want, report)
+	}
+	if success.count() != 1 || fail.count() != 1 {
+		t.Fatalf("error!\nwant messages: 1, 1\ngot: %d, %d", success.count(), fail.count())
+	}
+	for _, key := range want.Notified {
+		if v := store.metadata(key)[metadataNotified]; v != "true" {
+			t.Fatalf("error for %s!\nwant notified: true\ngot: %s", key, v)
+		}
+	}
+}